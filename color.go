@@ -0,0 +1,62 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+)
+
+// ANSI SGR codes for the colors gonew uses.
+const (
+	colorBold   = "1"
+	colorRed    = "31"
+	colorGreen  = "32"
+	colorYellow = "33"
+	colorCyan   = "36"
+)
+
+// A palette paints text with ANSI colors, or leaves it alone if it is off.
+type palette struct {
+	on bool
+}
+
+// paint returns s in the color given by the SGR code.
+func (p palette) paint(code, s string) string {
+	if !p.on || s == "" {
+		return s
+	}
+	return "\x1b[" + code + "m" + s + "\x1b[m"
+}
+
+// stderrColors is the palette for log output, set by setupColor.
+var stderrColors palette
+
+// setupColor validates the -color flag and sets stderrColors.
+func setupColor() {
+	switch *colorMode {
+	case "auto", "always", "never":
+	default:
+		fatalf("invalid -color %q: want auto, always, or never", *colorMode)
+	}
+	stderrColors = colorsFor(os.Stderr)
+}
+
+// colorsFor returns the palette for output written to f, according to the
+// -color flag: always and never force colors on and off, and auto enables
+// them only when f is a terminal and the NO_COLOR environment variable is
+// unset (see https://no-color.org).
+func colorsFor(f *os.File) palette {
+	switch *colorMode {
+	case "always":
+		return palette{on: true}
+	case "never":
+		return palette{}
+	}
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return palette{}
+	}
+	info, err := f.Stat()
+	return palette{on: err == nil && info.Mode()&os.ModeCharDevice != 0}
+}
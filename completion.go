@@ -0,0 +1,326 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// commands lists gonew's commands, in the order completion offers them.
+var commands = []string{"new", "add", "lint", "templatize", "update", "list", "cache", "completion", "version"}
+
+// shells lists the shells gonew completion writes scripts for.
+var shells = []string{"bash", "zsh", "fish", "powershell"}
+
+// completion implements "gonew completion shell".
+func completion(args []string) {
+	if len(args) != 1 {
+		usage()
+	}
+	var write func(io.Writer, completionInfo)
+	switch args[0] {
+	case "bash":
+		write = writeBash
+	case "zsh":
+		write = writeZsh
+	case "fish":
+		write = writeFish
+	case "powershell", "pwsh":
+		write = writePowerShell
+	default:
+		fatalf("unknown shell %s: gonew completes %s", args[0], strings.Join(shells, ", "))
+	}
+	write(os.Stdout, newCompletionInfo())
+}
+
+// completionInfo describes gonew's command line to a completion script.
+type completionInfo struct {
+	flags []completionFlag
+}
+
+// A completionFlag is a flag completion offers.
+type completionFlag struct {
+	name, usage string
+	value       bool // whether the flag takes a value, as -dir does but -v does not
+}
+
+func newCompletionInfo() completionInfo {
+	var info completionInfo
+	flag.VisitAll(func(f *flag.Flag) {
+		_, usage := flag.UnquoteUsage(f)
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		info.flags = append(info.flags, completionFlag{name: f.Name, usage: usage, value: !ok || !b.IsBoolFlag()})
+	})
+	return info
+}
+
+// valueFlags returns the names of the flags taking a value, each with
+// the given prefixes.
+func (c completionInfo) valueFlags(prefixes ...string) []string {
+	var names []string
+	for _, f := range c.flags {
+		if f.value {
+			for _, p := range prefixes {
+				names = append(names, p+f.name)
+			}
+		}
+	}
+	return names
+}
+
+// flagNames returns the names of all the flags, each with a leading dash.
+func (c completionInfo) flagNames() []string {
+	var names []string
+	for _, f := range c.flags {
+		names = append(names, "-"+f.name)
+	}
+	return names
+}
+
+// shQuote quotes s for a POSIX shell, and so for fish too.
+func shQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// The scripts find the positional arguments typed so far, skipping
+// the flags and the values of those taking one, and complete:
+// a command or template as the first; a template after new, add,
+// or lint; a directory after templatize and update, and otherwise;
+// and the fixed words after cache and completion. A template is a
+// name in the registry, as gonew list prints them, or a directory.
+
+func writeBash(w io.Writer, c completionInfo) {
+	fmt.Fprintf(w, `# bash completion for gonew. To use it, run, or add to ~/.bashrc:
+#
+#	source <(gonew completion bash)
+
+_gonew() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+	case $prev in
+	%s)
+		COMPREPLY=($(compgen -f -- "$cur"))
+		return
+		;;
+	esac
+	if [[ $cur == -* ]]; then
+		COMPREPLY=($(compgen -W %s -- "$cur"))
+		return
+	fi
+	local i w cmd= n=0
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		w=${COMP_WORDS[i]}
+		case $w in
+		-*=*) ;;
+		%[1]s) ((i++)) ;;
+		-*) ;;
+		*)
+			if [[ -z $cmd && $n == 0 && " "%[3]s" " == *" $w "* ]]; then
+				cmd=$w
+			else
+				((n++))
+			fi
+			;;
+		esac
+	done
+	case $cmd,$n in
+	cache,0) COMPREPLY=($(compgen -W "clean dir list" -- "$cur")) ;;
+	completion,0) COMPREPLY=($(compgen -W %[4]s -- "$cur")) ;;
+	cache,* | completion,* | list,* | version,*) ;;
+	,0 | new,0 | add,0 | lint,0)
+		local words=$(gonew list 2>/dev/null | awk '{print $1}')
+		[[ -z $cmd ]] && words+=" "%[3]s
+		COMPREPLY=($(compgen -W "$words" -- "$cur") $(compgen -d -- "$cur"))
+		;;
+	*) COMPREPLY=($(compgen -d -- "$cur")) ;;
+	esac
+}
+
+complete -F _gonew gonew
+`, strings.Join(c.valueFlags("-", "--"), "|"), shQuote(strings.Join(c.flagNames(), " ")),
+		shQuote(strings.Join(commands, " ")), shQuote(strings.Join(shells, " ")))
+}
+
+func writeZsh(w io.Writer, c completionInfo) {
+	fmt.Fprintf(w, `#compdef gonew
+# zsh completion for gonew. To use it, save it as _gonew in a directory
+# in $fpath, or run, or add to ~/.zshrc after compinit:
+#
+#	source <(gonew completion zsh)
+
+_gonew() {
+	local -a flags valueflags cmds templates
+	flags=(
+`)
+	for _, f := range c.flags {
+		fmt.Fprintf(w, "\t\t%s\n", shQuote("-"+strings.ReplaceAll(f.name, ":", `\:`)+":"+strings.ReplaceAll(f.usage, ":", `\:`)))
+	}
+	fmt.Fprintf(w, `	)
+	valueflags=(%s)
+	cmds=(%s)
+	if (( CURRENT > 2 )) && (( ${valueflags[(Ie)${words[CURRENT-1]}]} )); then
+		_files
+		return
+	fi
+	if [[ $PREFIX == -* ]]; then
+		_describe -t flags flag flags
+		return
+	fi
+	local i w cmd= n=0
+	for ((i = 2; i < CURRENT; i++)); do
+		w=${words[i]}
+		if [[ $w == -*=* ]]; then
+			:
+		elif (( ${valueflags[(Ie)$w]} )); then
+			((i++))
+		elif [[ $w == -* ]]; then
+			:
+		elif [[ -z $cmd && $n == 0 ]] && (( ${cmds[(Ie)$w]} )); then
+			cmd=$w
+		else
+			((n++))
+		fi
+	done
+	case $cmd,$n in
+	cache,0) compadd clean dir list ;;
+	completion,0) compadd %s ;;
+	cache,* | completion,* | list,* | version,*) ;;
+	,0 | new,0 | add,0 | lint,0)
+		templates=(${(f)"$(gonew list 2>/dev/null | awk '{print $1}')"})
+		[[ -z $cmd ]] && compadd -a cmds
+		compadd -a templates
+		_files -/
+		;;
+	*) _files -/ ;;
+	esac
+}
+
+if [[ $funcstack[1] == _gonew ]]; then
+	_gonew "$@"
+else
+	compdef _gonew gonew
+fi
+`, strings.Join(c.valueFlags("-", "--"), " "), strings.Join(commands, " "), strings.Join(shells, " "))
+}
+
+func writeFish(w io.Writer, c completionInfo) {
+	var cases []string
+	for _, name := range c.valueFlags("-", "--") {
+		cases = append(cases, shQuote(name))
+	}
+	fmt.Fprintf(w, `# fish completion for gonew. To use it, run:
+#
+#	gonew completion fish > ~/.config/fish/completions/gonew.fish
+
+function __gonew_args
+	set -l skip 0
+	for w in (commandline -opc)[2..-1]
+		if test $skip = 1
+			set skip 0
+			continue
+		end
+		switch $w
+			case '-*=*'
+			case %s
+				set skip 1
+			case '-*'
+			case '*'
+				echo $w
+		end
+	end
+end
+
+function __gonew_after
+	set -l args (__gonew_args)
+	test (count $args) -eq (count $argv); and test "$args" = "$argv"
+end
+
+function __gonew_needs_src
+	set -l args (__gonew_args)
+	test (count $args) -eq 0; or begin
+		test (count $args) -eq 1; and contains -- $args[1] new add lint
+	end
+end
+
+function __gonew_needs_dir
+	set -l args (__gonew_args)
+	test (count $args) -ge 1; and not contains -- $args[1] cache completion list version
+	and not __gonew_needs_src
+end
+
+complete -c gonew -f
+`, strings.Join(cases, " "))
+	for _, f := range c.flags {
+		req := ""
+		if f.value {
+			req = " -r -F"
+		}
+		fmt.Fprintf(w, "complete -c gonew -o %s%s -d %s\n", f.name, req, shQuote(f.usage))
+	}
+	fmt.Fprintf(w, `complete -c gonew -n 'test (count (__gonew_args)) -eq 0' -a %s
+complete -c gonew -n __gonew_needs_src -a '(gonew list 2>/dev/null | string replace -r "\\s.*" "")' -d template
+complete -c gonew -n __gonew_needs_src -a '(__fish_complete_directories)'
+complete -c gonew -n __gonew_needs_dir -a '(__fish_complete_directories)'
+complete -c gonew -n '__gonew_after cache' -a 'clean dir list'
+complete -c gonew -n '__gonew_after completion' -a %s
+`, shQuote(strings.Join(commands, " ")), shQuote(strings.Join(shells, " ")))
+}
+
+func writePowerShell(w io.Writer, c completionInfo) {
+	psList := func(list []string) string {
+		var quoted []string
+		for _, s := range list {
+			quoted = append(quoted, "'"+strings.ReplaceAll(s, "'", "''")+"'")
+		}
+		return "@(" + strings.Join(quoted, ", ") + ")"
+	}
+	fmt.Fprintf(w, `# PowerShell completion for gonew. To use it, run, or add to $PROFILE:
+#
+#	gonew completion powershell | Out-String | Invoke-Expression
+
+Register-ArgumentCompleter -Native -CommandName gonew -ScriptBlock {
+	param($wordToComplete, $commandAst, $cursorPosition)
+	$flags = %s
+	$valueFlags = %s
+	$commands = %s
+	$words = @($commandAst.CommandElements | Select-Object -Skip 1 | ForEach-Object { $_.ToString() })
+	if ($wordToComplete -ne '') {
+		$words = @($words | Select-Object -SkipLast 1)
+	}
+	$pos = @()
+	$skip = $false
+	foreach ($w in $words) {
+		if ($skip) { $skip = $false; continue }
+		if ($w -like '-*=*') { continue }
+		if ($w -like '-*') {
+			if ($valueFlags -contains $w) { $skip = $true }
+			continue
+		}
+		$pos += $w
+	}
+	$templates = { gonew list 2>$null | ForEach-Object { ($_ -split '\s+')[0] } }
+	$candidates = @()
+	if ($wordToComplete -like '-*') {
+		$candidates = $flags
+	} elseif ($words.Count -gt 0 -and $valueFlags -contains $words[-1]) {
+		return
+	} elseif ($pos.Count -eq 0) {
+		$candidates = $commands + @(& $templates)
+	} elseif ($pos.Count -eq 1 -and @('new', 'add', 'lint') -contains $pos[0]) {
+		$candidates = @(& $templates)
+	} elseif ($pos.Count -eq 1 -and $pos[0] -eq 'cache') {
+		$candidates = @('clean', 'dir', 'list')
+	} elseif ($pos.Count -eq 1 -and $pos[0] -eq 'completion') {
+		$candidates = %s
+	}
+	$candidates | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+		[System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+	}
+}
+`, psList(c.flagNames()), psList(c.valueFlags("-", "--")), psList(commands), psList(shells))
+}
@@ -5,4 +5,5 @@ go 1.23.0
 require (
 	golang.org/x/mod v0.20.0
 	golang.org/x/tools v0.24.0
+	gopkg.in/yaml.v3 v3.0.1
 )
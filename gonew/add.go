@@ -0,0 +1,241 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+)
+
+// Add applies a template to the existing module in dir, as for a template
+// holding one component, such as a gRPC handler, instead of a whole project.
+// It creates the template as [Clone] would with the module path read from
+// dir's go.mod as DstMod, so that the template's imports of its own packages
+// become imports of the module's, and copies the files into dir. The
+// template's go.mod and go.sum are not copied; instead, the requirements
+// and checksums they list that dir's lack are added to dir's.
+//
+// Add fails with an [ExistError], before changing anything, if dir already
+// has a file the template would create, unless opts.Force is set, to replace
+// such files, or opts.AllowExisting, to keep them. The Options fields
+// that only make sense when creating a module, such as Dir, DstMod,
+// GitInit, Record, and Exec, are ignored; Tidy runs "go mod tidy" in dir
+// once the files are added.
+func Add(ctx context.Context, dir string, opts Options) (*Result, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	gomod := filepath.Join(root, "go.mod")
+	data, err := os.ReadFile(gomod)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not the root of a module: %v", root, err)
+	}
+	mod := modfile.ModulePath(data)
+	if mod == "" {
+		return nil, fmt.Errorf("%s has no module statement", gomod)
+	}
+
+	tidy := opts.Tidy
+	opts.DstMod = mod
+	opts.Record = false
+	opts.Keep = false
+	opts.KeepGit, opts.RenameOrigin = false, false
+	opts.GitInit, opts.GitCommit, opts.SetOrigin, opts.Push = false, false, false, false
+	opts.Gitignore, opts.GitignoreMerge = false, false
+	opts.Tidy, opts.Format, opts.Verify, opts.RunHooks = false, false, false, false
+	opts.Exec = nil
+	opts.ModTime, opts.TouchModTime = time.Time{}, false
+
+	tmp, err := os.MkdirTemp("", "gonew-add-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	opts.Dir = filepath.Join(tmp, path.Base(mod))
+	res, err := CloneContext(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	src := res.Dir
+
+	var files, exist []string
+	for _, name := range res.Files {
+		if name == "go.mod" || name == "go.sum" {
+			continue
+		}
+		files = append(files, name)
+		if _, err := os.Lstat(filepath.Join(root, filepath.FromSlash(name))); err == nil {
+			exist = append(exist, name)
+		}
+	}
+	if len(exist) > 0 && !opts.Force && !opts.AllowExisting {
+		return nil, &ExistError{Dir: root, Files: exist}
+	}
+	if opts.AllowExisting {
+		for _, name := range exist {
+			opts.logf("kept existing %s", name)
+		}
+		res.Kept = exist
+		files = omitKept(files, exist)
+	}
+	for _, name := range files {
+		if opts.Force && slices.Contains(exist, name) {
+			opts.logf("replacing %s", name)
+		} else {
+			opts.logf("adding %s", name)
+		}
+		if err := addFile(filepath.Join(src, filepath.FromSlash(name)), filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			return nil, err
+		}
+	}
+
+	changed, err := mergeRequirements(root, src)
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, changed...)
+	if tidy {
+		opts.logf("running go mod tidy")
+		if err := runHook(ctx, root, []string{"go", "mod", "tidy"}, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	res.Dir = root
+	res.Files = files
+	return res, nil
+}
+
+// An ExistError reports that [Add] did not add a template to the module
+// in Dir because the module already has some of the template's files.
+type ExistError struct {
+	Dir   string
+	Files []string // slash-separated and relative to Dir, in sorted order
+}
+
+func (e *ExistError) Error() string {
+	return fmt.Sprintf("%s already has %s, which the template would overwrite", e.Dir, strings.Join(e.Files, ", "))
+}
+
+// addFile copies the file or symbolic link src to dst, replacing
+// any file there and creating its parent directories.
+func addFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+		return err
+	}
+	if err := os.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		link, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, dst)
+	}
+	return copyFile(src, dst, info.Mode().Perm())
+}
+
+// mergeRequirements adds to the go.mod and go.sum files in root the
+// requirements and checksums of those in the template tree tmpl that
+// they lack, raising the version of any requirement that the template
+// needs a later version of. It returns the names of the files it changed.
+func mergeRequirements(root, tmpl string) ([]string, error) {
+	var changed []string
+	gomod := filepath.Join(root, "go.mod")
+	data, err := os.ReadFile(gomod)
+	if err != nil {
+		return nil, err
+	}
+	host, err := modfile.Parse(gomod, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	tdata, err := os.ReadFile(filepath.Join(tmpl, "go.mod"))
+	if err != nil {
+		return nil, err
+	}
+	t, err := modfile.Parse("go.mod", tdata, nil)
+	if err != nil {
+		return nil, err
+	}
+	have := make(map[string]string)
+	for _, r := range host.Require {
+		have[r.Mod.Path] = r.Mod.Version
+	}
+	for _, r := range t.Require {
+		v, ok := have[r.Mod.Path]
+		switch {
+		case !ok:
+			host.AddNewRequire(r.Mod.Path, r.Mod.Version, r.Indirect)
+		case semver.Compare(v, r.Mod.Version) < 0:
+			if err := host.AddRequire(r.Mod.Path, r.Mod.Version); err != nil {
+				return nil, err
+			}
+		}
+	}
+	host.Cleanup()
+	out, err := host.Format()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(out, data) {
+		if err := writeKeepMode(gomod, out); err != nil {
+			return nil, err
+		}
+		changed = append(changed, "go.mod")
+	}
+
+	tsum, err := os.ReadFile(filepath.Join(tmpl, "go.sum"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return changed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	gosum := filepath.Join(root, "go.sum")
+	sum, err := os.ReadFile(gosum)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(string(sum), "\n") {
+		lines[line] = true
+	}
+	newSum := sum
+	if len(newSum) > 0 && newSum[len(newSum)-1] != '\n' {
+		newSum = append(newSum, '\n')
+	}
+	for _, line := range strings.Split(string(tsum), "\n") {
+		if line != "" && !lines[line] {
+			newSum = append(newSum, line+"\n"...)
+			lines[line] = true
+		}
+	}
+	if !bytes.Equal(newSum, sum) {
+		if err := os.WriteFile(gosum, newSum, 0666); err != nil {
+			return nil, err
+		}
+		changed = append(changed, "go.sum")
+	}
+	return changed, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Answers are the inputs a template needs beyond its location, which
+// a user would otherwise give on the command line or when prompted,
+// kept in a file so that generating a module can be repeated exactly,
+// as in CI, and the inputs reviewed.
+type Answers struct {
+	Module   string            `yaml:"module,omitempty" json:"module,omitempty"` // new module path
+	Vars     map[string]string `yaml:"vars" json:"vars"`                         // template variables
+	Features []string          `yaml:"features" json:"features"`                 // as in Options.Features
+}
+
+// ReadAnswers reads an answers file, in YAML or JSON, such as
+//
+//	module: example.com/greeter
+//	vars:
+//	  Author: Jane Doe
+//	  Description: A tool for greeting people.
+//	features: [postgres, docker]
+//
+// A file without features leaves them nil, choosing the template's
+// defaults, while an empty list chooses none. A file with neither a vars
+// mapping nor a features list is instead read as a mapping of the variable
+// names to values, as [ReadVars] reads it.
+func ReadAnswers(file string) (*Answers, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var top map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &top); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	a := new(Answers)
+	if v, f := top["vars"], top["features"]; v.Kind == yaml.MappingNode || f.Kind == yaml.SequenceNode {
+		err = yaml.Unmarshal(data, a)
+	} else {
+		err = yaml.Unmarshal(data, &a.Vars)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return a, nil
+}
+
+// WriteAnswers writes a to file, in JSON if its name ends in .json
+// and otherwise in YAML, in the form ReadAnswers reads.
+func WriteAnswers(file string, a *Answers) error {
+	out := *a
+	if out.Features == nil {
+		out.Features = []string{} // without features is not the same as with the defaults
+	}
+	var data []byte
+	var err error
+	if filepath.Ext(file) == ".json" {
+		data, err = json.MarshalIndent(out, "", "\t")
+		data = append(data, '\n')
+	} else {
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		err = enc.Encode(out)
+		data = buf.Bytes()
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0666)
+}
@@ -0,0 +1,243 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// isArchive reports whether the local template src names
+// a zip file or tarball rather than a directory.
+func isArchive(src string) bool {
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(src, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// An archiveFile is a file read from an archive.
+type archiveFile struct {
+	name string // slash-separated, relative to the archive root
+	mode fs.FileMode
+	link string // target, for a symbolic link
+	open func() (io.ReadCloser, error)
+}
+
+// walkArchive calls fn for each file in the archive, in order.
+func walkArchive(file string, fn func(f archiveFile) error) error {
+	if strings.HasSuffix(file, ".zip") {
+		zr, err := zip.OpenReader(file)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		for _, zf := range zr.File {
+			f := archiveFile{name: zf.Name, mode: zf.Mode(), open: zf.Open}
+			if f.mode&fs.ModeSymlink != 0 {
+				r, err := zf.Open()
+				if err != nil {
+					return err
+				}
+				target, err := io.ReadAll(io.LimitReader(r, 4096))
+				r.Close()
+				if err != nil {
+					return err
+				}
+				f.link = string(target)
+			}
+			if err := fn(f); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	r, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	var tr *tar.Reader
+	if strings.HasSuffix(file, ".tar") {
+		tr = tar.NewReader(r)
+	} else {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		defer zr.Close()
+		tr = tar.NewReader(zr)
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue // as in GitHub's tarballs; not a file
+		}
+		f := archiveFile{
+			name: hdr.Name,
+			mode: hdr.FileInfo().Mode(),
+			link: hdr.Linkname,
+			open: func() (io.ReadCloser, error) { return io.NopCloser(tr), nil },
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+}
+
+// archivePrefix returns the directory, ending in a slash, that holds the
+// template in the archive file, or "" if the template is at its root.
+// Archives made by code hosts, like GitHub's repo-main.zip, put all their
+// files in a single top-level directory, which is taken to be the root
+// unless the archive also has a go.mod file at the top level.
+func archivePrefix(file string) (string, error) {
+	prefix := ""
+	single, rootMod := true, false
+	err := walkArchive(file, func(f archiveFile) error {
+		name := strings.TrimPrefix(f.name, "./")
+		if name == "" {
+			return nil // the root itself
+		}
+		if name == "go.mod" {
+			rootMod = true
+		}
+		top, _, ok := strings.Cut(name, "/")
+		if !ok && f.mode.IsDir() {
+			ok = true
+		}
+		switch {
+		case !ok: // a file at the top level
+			single = false
+		case prefix == "":
+			prefix = top + "/"
+		case prefix != top+"/":
+			single = false
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if rootMod || !single {
+		return "", nil
+	}
+	return prefix, nil
+}
+
+// archiveModulePath returns the module path declared by the go.mod file
+// at the root of the template in the archive file.
+func archiveModulePath(file string) (string, error) {
+	prefix, err := archivePrefix(file)
+	if err != nil {
+		return "", err
+	}
+	var data []byte
+	found := false
+	err = walkArchive(file, func(f archiveFile) error {
+		if strings.TrimPrefix(f.name, "./") != prefix+"go.mod" || !f.mode.IsRegular() {
+			return nil
+		}
+		r, err := f.open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		data, err = io.ReadAll(r)
+		found = true
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", fmt.Errorf("%s: no go.mod file", file)
+	}
+	mod := modfile.ModulePath(data)
+	if mod == "" {
+		return "", fmt.Errorf("%s: %sgo.mod: missing module statement", file, prefix)
+	}
+	return mod, nil
+}
+
+// extractArchive extracts the template in the archive file into dst.
+// Entries whose names would place them outside dst are an error,
+// and symbolic links are created only once every file has been written,
+// so that no file is ever written through one.
+func extractArchive(file, dst string) error {
+	prefix, err := archivePrefix(file)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0777); err != nil {
+		return err
+	}
+	type link struct{ target, name string }
+	var links []link
+	err = walkArchive(file, func(f archiveFile) error {
+		name := strings.TrimPrefix(strings.TrimPrefix(f.name, "./"), prefix)
+		name = strings.TrimSuffix(name, "/")
+		if name == "" || name == "." {
+			return nil
+		}
+		if path.IsAbs(name) || !fs.ValidPath(name) {
+			return fmt.Errorf("%s: invalid file name %q", file, f.name)
+		}
+		target := filepath.Join(dst, filepath.FromSlash(name))
+		switch {
+		case f.mode.IsDir():
+			return os.MkdirAll(target, f.mode.Perm()|0700)
+		case f.mode&fs.ModeSymlink != 0:
+			links = append(links, link{f.link, target})
+			return nil
+		case f.mode.IsRegular():
+			if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+				return err
+			}
+			r, err := f.open()
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			w, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, f.mode.Perm()|0200)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(w, r); err != nil {
+				w.Close()
+				return err
+			}
+			return w.Close()
+		}
+		return nil // devices and the like
+	})
+	if err != nil {
+		return err
+	}
+	for _, l := range links {
+		if err := os.Symlink(l.target, l.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// cacheEntry returns the directory within cache holding the clone of
+// repo at version vers. An empty vers denotes the default branch.
+func cacheEntry(cache, repo, vers string) (string, error) {
+	if vers == "" {
+		vers = "latest"
+	}
+	enc, err := module.EscapePath(repo)
+	if err != nil {
+		// Module path escaping rejects a host with a port.
+		// No valid module path contains a percent sign, so this cannot collide.
+		if !strings.Contains(repo, ":") {
+			return "", err
+		}
+		enc = strings.ReplaceAll(repo, ":", "%3A")
+	}
+	encVers, err := module.EscapeVersion(vers)
+	if err != nil {
+		// Not a semantic version; fall back to a plain file name.
+		encVers = strings.ReplaceAll(vers, "/", "_")
+	}
+	return filepath.Join(cache, filepath.FromSlash(enc), "@"+encVers), nil
+}
+
+// saveCache records the clone of repo at vers in dir in the cache,
+// replacing any earlier copy.
+func saveCache(cache, repo, vers, dir string) error {
+	if cache == "" {
+		return fmt.Errorf("no cache directory")
+	}
+	entry, err := cacheEntry(cache, repo, vers)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(entry), 0777); err != nil {
+		return err
+	}
+	// Copy into a scratch directory and rename it into place,
+	// so that a concurrent or interrupted run never sees a partial entry.
+	tmp, err := os.MkdirTemp(filepath.Dir(entry), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := copyTree(dir, tmp); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(entry); err != nil {
+		return err
+	}
+	return os.Rename(tmp, entry)
+}
+
+// loadCache copies the cached clone of repo at vers into dir.
+func loadCache(cache, repo, vers, dir string) error {
+	if cache == "" {
+		return fmt.Errorf("offline: no cache directory")
+	}
+	entry, err := cacheEntry(cache, repo, vers)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(entry); err != nil {
+		if vers == "" {
+			vers = "latest"
+		}
+		return fmt.Errorf("offline: %s@%s is not in the template cache (%s)", repo, vers, entry)
+	}
+	return copyTree(entry, dir)
+}
+
+// cachedCommit returns the commit of the cached clone of repo at vers,
+// or "" if there is none.
+func cachedCommit(cache, repo, vers string) string {
+	entry, err := cacheEntry(cache, repo, vers)
+	if err != nil {
+		return ""
+	}
+	return headCommit(entry)
+}
+
+// A CachedTemplate is a clone of a template in the template cache.
+type CachedTemplate struct {
+	Repo    string // repository, as in Options.SrcRepo
+	Version string // branch or tag cloned, or "" for the default branch
+	Commit  string // commit cloned, if known
+	Dir     string // directory holding the clone
+}
+
+// CachedTemplates returns the templates in the cache directory,
+// sorted by Repo and then Version. A cache that does not exist is empty.
+func CachedTemplates(cache string) ([]CachedTemplate, error) {
+	var list []CachedTemplate
+	err := filepath.WalkDir(cache, func(file string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && file == cache {
+			return filepath.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || file == cache {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".tmp-") {
+			return filepath.SkipDir // being saved
+		}
+		vers, ok := strings.CutPrefix(d.Name(), "@")
+		if !ok {
+			return nil
+		}
+		rel, err := filepath.Rel(cache, filepath.Dir(file))
+		if err != nil {
+			return err
+		}
+		repo, err := module.UnescapePath(filepath.ToSlash(rel))
+		if err != nil {
+			repo = strings.ReplaceAll(filepath.ToSlash(rel), "%3A", ":")
+		}
+		if vers == "latest" {
+			vers = ""
+		} else if v, err := module.UnescapeVersion(vers); err == nil {
+			vers = v
+		}
+		list = append(list, CachedTemplate{Repo: repo, Version: vers, Commit: headCommit(file), Dir: file})
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Repo != list[j].Repo {
+			return list[i].Repo < list[j].Repo
+		}
+		return list[i].Version < list[j].Version
+	})
+	return list, nil
+}
+
+// copyTree copies the tree rooted at src to dst, which must not exist.
+// It preserves file modes and symbolic links, except that the copies
+// are always writable by their owner, so that they can be rewritten
+// even when src is read-only, like the module cache.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, name)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(name)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(name, target, info.Mode().Perm()|0200)
+		}
+		return nil
+	})
+}
+
+// copyFile copies the regular file src to dst with the given permissions.
+func copyFile(src, dst string, perm fs.FileMode) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,31 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheEntry(t *testing.T) {
+	tests := []struct {
+		repo, vers, want string
+	}{
+		{"github.com/example/hello", "", "github.com/example/hello/@latest"},
+		{"github.com/Example/Hello", "v1.2.3", "github.com/!example/!hello/@v1.2.3"},
+		{"github.com/example/hello", "feature/x", "github.com/example/hello/@feature_x"},
+		{"git.corp.com:8443/team/repo", "v1.0.0", "git.corp.com%3A8443/team/repo/@v1.0.0"},
+	}
+	for _, tt := range tests {
+		got, err := cacheEntry("cache", tt.repo, tt.vers)
+		if err != nil {
+			t.Errorf("cacheEntry(%q, %q): %v", tt.repo, tt.vers, err)
+			continue
+		}
+		if want := filepath.Join("cache", filepath.FromSlash(tt.want)); got != want {
+			t.Errorf("cacheEntry(%q, %q) = %q, want %q", tt.repo, tt.vers, got, want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A Config is an organization's policy for creating modules, which its
+// platform team installs on each developer's machine so that gonew sets
+// the same defaults and enforces the same rules for all of them.
+// It is read from a YAML file of the form
+//
+//	registry: https://gonew.example.com/registry.yaml
+//	vars:
+//	  Org: Example Corp
+//	org: Example Corp
+//	license: apache2
+//	allowed_templates:
+//	  - github.com/example/*
+//	  - github.com/example-templates/**
+//	require_prefix: [github.com/example]
+//	record: true
+//	exec:
+//	  - provenance stamp .
+//
+// in which every field is optional.
+type Config struct {
+	Registry         string            `yaml:"registry"`          // default template registry, a file or URL
+	Vars             map[string]string `yaml:"vars"`              // as in Options.DefaultVars
+	Org              string            `yaml:"org"`               // default copyright holder, as in Options.Org
+	License          string            `yaml:"license"`           // default license, as in Options.License
+	AllowedTemplates []string          `yaml:"allowed_templates"` // as in Options.AllowedTemplates
+	RequirePrefix    []string          `yaml:"require_prefix"`    // prefixes one of which each new module path must lie within
+	RequirePattern   string            `yaml:"require_pattern"`   // regular expression each new module path must match
+	Record           bool              `yaml:"record"`            // always set Options.Record
+	Exec             []string          `yaml:"exec"`              // commands to run in each new module, after any others
+}
+
+// ReadConfig reads the policy file file. Unlike most of gonew's files,
+// it must not have fields ReadConfig does not know, since a misspelled
+// restriction would otherwise be silently ignored.
+func ReadConfig(file string) (*Config, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	c := new(Config)
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	if c.License != "" {
+		if _, err := checkLicense(c.License); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	if c.RequirePattern != "" {
+		if _, err := regexp.Compile(c.RequirePattern); err != nil {
+			return nil, fmt.Errorf("%s: invalid require_pattern: %v", file, err)
+		}
+	}
+	for _, h := range c.Exec {
+		if strings.TrimSpace(h) == "" {
+			return nil, fmt.Errorf("%s: empty exec command", file)
+		}
+	}
+	return c, nil
+}
+
+// checkAllowed reports an error if the template repo, a local directory
+// or a repository as in Options.SrcRepo, matches none of the patterns
+// of Options.AllowedTemplates.
+func checkAllowed(repo string, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	name := repo
+	if IsLocal(repo) {
+		abs, err := filepath.Abs(localDir(repo))
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(abs)
+	}
+	if matchAny(allowed, name) {
+		return nil
+	}
+	return fmt.Errorf("template %s is not allowed by policy, which allows only %s", repo, strings.Join(allowed, ", "))
+}
@@ -0,0 +1,166 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// knownHosts lists the code hosts whose repository URLs follow directly
+// from the module path, so that no go-import lookup is needed.
+var knownHosts = []string{"github.com", "gitlab.com", "bitbucket.org"}
+
+// A repoURLs holds the URLs for cloning a template repository
+// over SSH and over HTTPS.
+type repoURLs struct {
+	ssh, https string
+}
+
+// findRepo returns the URLs of the git repository holding the module mod.
+// For a module on a known host, they follow from the module path. Otherwise
+// findRepo looks for a go-import meta tag the way "go get" does, fetching
+// https://mod?go-get=1; if there is none, it falls back to the module path
+// as for a known host.
+func findRepo(ctx context.Context, mod string, logf func(string, ...any)) (repoURLs, error) {
+	byPath := repoURLs{sshURL(mod), httpsURL(mod)}
+	host, _, _ := strings.Cut(mod, "/")
+	for _, h := range knownHosts {
+		if host == h {
+			return byPath, nil
+		}
+	}
+	logf("looking up go-import meta tag for %s", mod)
+	imp, err := lookupImport(ctx, mod)
+	if err != nil {
+		logf("%v; cloning from %s", err, host)
+		return byPath, nil
+	}
+	if imp.prefix != mod {
+		return repoURLs{}, fmt.Errorf("%s: module is in a subdirectory of repository %s, which gonew cannot clone", mod, imp.prefix)
+	}
+	switch imp.vcs {
+	case "git":
+	case "mod":
+		return repoURLs{}, fmt.Errorf("%s is served only by a module proxy, not a repository", mod)
+	default:
+		return repoURLs{}, fmt.Errorf("%s: repository uses %s, but gonew only supports git", mod, imp.vcs)
+	}
+	u, err := url.Parse(imp.repo)
+	if err != nil || u.Host == "" {
+		return repoURLs{}, fmt.Errorf("%s: invalid repository URL %q in go-import meta tag", mod, imp.repo)
+	}
+	logf("found repository %s", imp.repo)
+	if u.Scheme != "https" {
+		// Use the given URL, such as an ssh:// one, for both.
+		return repoURLs{imp.repo, imp.repo}, nil
+	}
+	return repoURLs{
+		ssh:   sshURL(u.Host + strings.TrimSuffix(u.Path, ".git")),
+		https: imp.repo,
+	}, nil
+}
+
+// A metaImport is the content of a go-import meta tag.
+type metaImport struct {
+	prefix, vcs, repo string
+}
+
+// lookupImport fetches https://mod?go-get=1 and returns the go-import
+// meta tag whose prefix is mod or a path leading to it.
+func lookupImport(ctx context.Context, mod string) (metaImport, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://"+mod+"?go-get=1", nil)
+	if err != nil {
+		return metaImport{}, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return metaImport{}, fmt.Errorf("looking up %s: %v", mod, err)
+	}
+	defer resp.Body.Close()
+	imports, err := parseMetaImports(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return metaImport{}, fmt.Errorf("looking up %s: parsing meta tags: %v", mod, err)
+	}
+	var found []metaImport
+	for _, imp := range imports {
+		if mod == imp.prefix || strings.HasPrefix(mod, imp.prefix+"/") {
+			found = append(found, imp)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return metaImport{}, fmt.Errorf("looking up %s: no go-import meta tag", mod)
+	case 1:
+		return found[0], nil
+	}
+	// As in cmd/go, a mod tag may accompany the tag for the repository.
+	var vcs []metaImport
+	for _, imp := range found {
+		if imp.vcs != "mod" {
+			vcs = append(vcs, imp)
+		}
+	}
+	if len(vcs) == 1 {
+		return vcs[0], nil
+	}
+	return metaImport{}, fmt.Errorf("looking up %s: multiple go-import meta tags", mod)
+}
+
+// parseMetaImports returns the go-import meta tags in the HTML head read
+// from r, which, as in cmd/go, is parsed leniently as XML.
+func parseMetaImports(r io.Reader) ([]metaImport, error) {
+	d := xml.NewDecoder(r)
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "utf-8", "ascii":
+			return input, nil
+		}
+		return nil, fmt.Errorf("can't decode XML document using charset %q", charset)
+	}
+	d.Strict = false
+	var imports []metaImport
+	for {
+		t, err := d.RawToken()
+		if err != nil {
+			if err == io.EOF || len(imports) > 0 {
+				break
+			}
+			return nil, err
+		}
+		if e, ok := t.(xml.StartElement); ok && strings.EqualFold(e.Name.Local, "body") {
+			break
+		}
+		if e, ok := t.(xml.EndElement); ok && strings.EqualFold(e.Name.Local, "head") {
+			break
+		}
+		e, ok := t.(xml.StartElement)
+		if !ok || !strings.EqualFold(e.Name.Local, "meta") || attrValue(e.Attr, "name") != "go-import" {
+			continue
+		}
+		if f := strings.Fields(attrValue(e.Attr, "content")); len(f) == 3 {
+			imports = append(imports, metaImport{prefix: f[0], vcs: f[1], repo: f[2]})
+		}
+	}
+	return imports, nil
+}
+
+// attrValue returns the value of the attribute named name, ignoring case.
+func attrValue(attrs []xml.Attr, name string) string {
+	for _, a := range attrs {
+		if strings.EqualFold(a.Name.Local, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
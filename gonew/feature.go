@@ -0,0 +1,140 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A manifestFeature declares an optional part of a template, such as
+// postgres or docker support: the files and directories, which may be
+// globs, that belong to it, and the blocks of text marked with its name.
+// Default includes it when Options.Features is nil.
+type manifestFeature struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	Default     bool     `yaml:"default"`
+	Files       []string `yaml:"files"`
+}
+
+// featureNameRE matches a valid feature name.
+var featureNameRE = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// features returns, for each feature m declares, whether it is included:
+// if selected is nil, whether it is on by default, and otherwise whether
+// selected names it. It is an error for selected to name a feature m
+// does not declare, unless lenient is set, as for a template composed
+// with others that may declare it. M may be nil.
+func (m *manifest) features(selected []string, lenient bool) (map[string]bool, error) {
+	on := make(map[string]bool)
+	if m != nil {
+		for _, f := range m.Features {
+			on[f.Name] = selected == nil && f.Default
+		}
+	}
+	for _, name := range selected {
+		if _, ok := on[name]; !ok {
+			if lenient {
+				continue
+			}
+			if len(on) == 0 {
+				return nil, fmt.Errorf("unknown feature %s: the template has no optional features", name)
+			}
+			var names []string
+			for n := range on {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown feature %s: the template has %s", name, strings.Join(names, ", "))
+		}
+		on[name] = true
+	}
+	return on, nil
+}
+
+// removeFeatureFiles removes from the tree rooted at root the files and
+// directories of the features m declares that on does not include.
+func removeFeatureFiles(root string, m *manifest, on map[string]bool) error {
+	var drop []string
+	for _, f := range m.Features {
+		if !on[f.Name] {
+			drop = append(drop, f.Files...)
+		}
+	}
+	return removePaths(root, drop)
+}
+
+// featureMarkerRE matches a line marking the start of a feature block,
+// "gonew:feature name", or "gonew:feature !name" for a block included only
+// without the feature, or its end, "gonew:end", in a comment beginning
+// with //, /*, #, --, ;, or <!--.
+var featureMarkerRE = regexp.MustCompile(`^\s*(?://|/\*|#|--|;|<!--)\s*gonew:(?:feature\s+(!?)([A-Za-z0-9_-]+)|(end))\b`)
+
+// applyFeatureBlocks edits the text files in the tree rooted at root,
+// removing the blocks of the features that on does not include, and the
+// lines marking every block, adding the files it changes to rewritten.
+// Blocks may nest, and each must be closed in the file that opens it.
+func applyFeatureBlocks(root string, on map[string]bool, opts *RewriteOptions, rewritten map[string]bool) error {
+	var err error
+	walkErr := walkText(root, nil, opts, rewritten, func(rel string, data []byte) []byte {
+		if err != nil || !bytes.Contains(data, []byte("gonew:")) {
+			return data
+		}
+		var out []byte
+		out, err = stripFeatures(rel, data, on)
+		return out
+	})
+	if walkErr != nil {
+		return walkErr
+	}
+	return err
+}
+
+// stripFeatures returns data, the content of the file rel, with the
+// blocks of the features that on does not include, and all block markers,
+// removed.
+func stripFeatures(rel string, data []byte, on map[string]bool) ([]byte, error) {
+	type block struct {
+		line int  // of the start marker
+		keep bool // whether the block's lines are kept
+	}
+	var stack []block
+	keep := true
+	var out []byte
+	for i, line := range bytes.SplitAfter(data, []byte("\n")) {
+		m := featureMarkerRE.FindSubmatch(line)
+		switch {
+		case m == nil:
+			if keep {
+				out = append(out, line...)
+			}
+		case m[3] != nil: // gonew:end
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("%s:%d: gonew:end without gonew:feature", rel, i+1)
+			}
+			stack = stack[:len(stack)-1]
+			keep = len(stack) == 0 || stack[len(stack)-1].keep
+		default:
+			name := string(m[2])
+			included, ok := on[name]
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: unknown feature %s", rel, i+1, name)
+			}
+			if len(m[1]) > 0 {
+				included = !included
+			}
+			keep = keep && included
+			stack = append(stack, block{i + 1, keep})
+		}
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("%s:%d: gonew:feature without gonew:end", rel, stack[len(stack)-1].line)
+	}
+	return out, nil
+}
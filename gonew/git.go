@@ -0,0 +1,289 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sshURL returns the URL for cloning repo over SSH.
+// A host without a port uses the scp-like form:
+// github.com/<org>/<project> -> git@github.com:<org>/<project>.git.
+// That form cannot carry a port, so a host with one, as in
+// git.corp.com:8443/<team>/<project>, uses an ssh:// URL instead.
+func sshURL(repo string) string {
+	host, rest, _ := strings.Cut(repo, "/")
+	if strings.Contains(host, ":") {
+		return "ssh://git@" + host + "/" + rest + ".git"
+	}
+	return "git@" + host + ":" + rest + ".git"
+}
+
+// httpsURL returns the URL for cloning repo over HTTPS:
+// gitlab.com/<group>/<subgroup>/<project> -> https://gitlab.com/<group>/<subgroup>/<project>.git.
+func httpsURL(repo string) string {
+	return "https://" + repo + ".git"
+}
+
+// cloneRepo clones the repository at giturl into dir. Unless full is set,
+// it makes a shallow clone of only the branch or tag vers, or of the default
+// branch if vers is empty or names a pull request head. If vers names
+// something else, such as a commit, the shallow clone fails and cloneRepo
+// falls back to a full clone, in which the caller can check out vers.
+// Env, if non-nil, is added to git's environment, and progress,
+// if non-nil, receives git's progress output.
+func cloneRepo(ctx context.Context, giturl, dir, vers string, full bool, env []string, progress io.Writer, logf func(string, ...any)) error {
+	if !full {
+		args := []string{"clone", "--depth", "1", "--single-branch"}
+		if _, ok := pullRef(vers); vers != "" && !ok {
+			args = append(args, "--branch", vers)
+		}
+		err := gitClone(ctx, env, progress, logf, append(args, giturl, dir)...)
+		if err == nil || vers == "" {
+			return err
+		}
+		logf("shallow clone failed; retrying with a full clone")
+	}
+	return gitClone(ctx, env, progress, logf, "clone", giturl, dir)
+}
+
+// gitClone runs git with the clone arguments args, adding env to its
+// environment, and copying its progress output to progress, if non-nil.
+func gitClone(ctx context.Context, env []string, progress io.Writer, logf func(string, ...any), args ...string) error {
+	logf("running git %s", strings.Join(args, " "))
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if progress != nil {
+		// Git reports progress only to a terminal unless asked,
+		// and the advice on cloning a tag is no use here.
+		cmd.Args = append([]string{"git", "-c", "advice.detachedHead=false", args[0], "--progress"}, args[1:]...)
+		cmd.Stderr = io.MultiWriter(&stderr, progress)
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git clone %s: %v\n%s%s", args[len(args)-2], err, stderr.Bytes(), stdout.Bytes())
+	}
+	return nil
+}
+
+// sshAuthFailed reports whether err, from cloning over SSH, shows that
+// the SSH connection itself failed, typically for lack of a usable key,
+// as opposed to the repository or version being missing.
+func sshAuthFailed(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "Permission denied (publickey") ||
+		strings.Contains(msg, "Could not read from remote repository") ||
+		strings.Contains(msg, "Host key verification failed")
+}
+
+// tokenEnv returns the environment variables that make git authenticate
+// to the host of giturl with the access token, or nil if token is empty.
+// The token is passed as the password of HTTP basic authentication, which
+// GitHub, GitLab, Gitea, and Bitbucket all accept, through configuration
+// given in the environment, so that it never appears in a command line or
+// in the clone's .git/config.
+func tokenEnv(giturl, token string) []string {
+	if token == "" {
+		return nil
+	}
+	u, err := url.Parse(giturl)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+	cred := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + token))
+	return []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http." + u.Scheme + "://" + u.Host + "/.extraHeader",
+		"GIT_CONFIG_VALUE_0=Authorization: Basic " + cred,
+	}
+}
+
+// remoteCommit returns the commit that vers, a tag or branch, or empty for
+// the default branch, names in the repository at giturl, using
+// "git ls-remote" with env added to git's environment, so that a cached
+// clone can be checked for being up to date without cloning again.
+// A tag is preferred over a branch of the same name, as by "git checkout".
+func remoteCommit(ctx context.Context, giturl, vers string, env []string) (string, error) {
+	patterns := []string{"HEAD"} // for "git ls-remote"
+	prefer := patterns           // refs to use, in order
+	if n, ok := pullRef(vers); ok {
+		patterns = []string{"refs/pull/" + n + "/head"}
+		prefer = patterns
+	} else if vers != "" {
+		patterns = []string{"refs/tags/" + vers, "refs/heads/" + vers}
+		// An annotated tag is listed both as itself and, with ^{},
+		// as the commit it points to.
+		prefer = []string{"refs/tags/" + vers + "^{}", "refs/tags/" + vers, "refs/heads/" + vers}
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", append([]string{"ls-remote", giturl}, patterns...)...)
+	cmd.Env = append(os.Environ(), append([]string{"GIT_TERMINAL_PROMPT=0"}, env...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git ls-remote %s: %v\n%s", giturl, err, stderr.Bytes())
+	}
+	found := make(map[string]string)
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if hash, ref, ok := strings.Cut(line, "\t"); ok {
+			found[ref] = hash
+		}
+	}
+	for _, ref := range prefer {
+		if hash := found[ref]; hash != "" {
+			return hash, nil
+		}
+	}
+	return "", fmt.Errorf("git ls-remote %s: no ref named %s", giturl, vers)
+}
+
+// checkoutVersion checks out vers, which names a tag, branch, commit,
+// or pull request head, in the clone in dir, fetching from its origin
+// with env added to git's environment if need be.
+func checkoutVersion(ctx context.Context, dir, vers string, env []string) error {
+	if n, ok := pullRef(vers); ok {
+		return checkoutPull(ctx, dir, n, env)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", "-C", dir, "checkout", "-q", vers, "--")
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("no tag, branch, or commit named %s: %v\n%s", vers, err, stderr.Bytes())
+	}
+	return nil
+}
+
+// pullRef reports whether vers names a pull request head, of the form
+// pull/N/head, and if so returns N.
+func pullRef(vers string) (string, bool) {
+	n, ok := strings.CutPrefix(vers, "pull/")
+	if !ok {
+		return "", false
+	}
+	n, ok = strings.CutSuffix(n, "/head")
+	if !ok || n == "" || strings.Trim(n, "0123456789") != "" {
+		return "", false
+	}
+	return n, true
+}
+
+// checkoutPull fetches the head of pull request n from the origin
+// of the clone in dir, adding env to git's environment, and checks it out.
+func checkoutPull(ctx context.Context, dir, n string, env []string) error {
+	ref := "pull/" + n + "/head"
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", "-C", dir, "fetch", "origin", ref)
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("fetching %s (does the host publish pull request refs?): %v\n%s", ref, err, stderr.Bytes())
+	}
+	stderr.Reset()
+	cmd = exec.Command("git", "-C", dir, "checkout", "-q", "--detach", "FETCH_HEAD")
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("checking out %s: %v\n%s", ref, err, stderr.Bytes())
+	}
+	return nil
+}
+
+// initRepo starts a new git repository in dir. If message is not empty,
+// it also commits every file in dir with that commit message. If origin
+// is not empty, it adds origin as the URL of the origin remote.
+func initRepo(ctx context.Context, dir, message, origin string) error {
+	cmds := [][]string{{"init", "-q"}}
+	if message != "" {
+		cmds = append(cmds,
+			[]string{"add", "-A"},
+			[]string{"commit", "-q", "-m", message})
+	}
+	if origin != "" {
+		cmds = append(cmds, []string{"remote", "add", "origin", origin})
+	}
+	for _, args := range cmds {
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("git %s: %v\n%s", args[0], err, stderr.Bytes())
+		}
+	}
+	return nil
+}
+
+// commitAll commits every change in the work tree of the git
+// repository in dir with the given message.
+func commitAll(ctx context.Context, dir, message string) error {
+	for _, args := range [][]string{{"add", "-A"}, {"commit", "-q", "--allow-empty", "-m", message}} {
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("git %s: %v\n%s", args[0], err, stderr.Bytes())
+		}
+	}
+	return nil
+}
+
+// pushRepo pushes the current branch of the git repository in dir
+// to its origin remote, setting it as the branch's upstream.
+func pushRepo(ctx context.Context, dir string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", "-C", dir, "push", "-q", "-u", "origin", "HEAD")
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git push: %v\n%s", err, stderr.Bytes())
+	}
+	return nil
+}
+
+// renameOrigin renames the origin remote of the git repository in dir
+// to template. A repository without an origin remote is left alone.
+func renameOrigin(dir string) error {
+	out, err := exec.Command("git", "-C", dir, "remote").Output()
+	if err != nil {
+		return fmt.Errorf("listing git remotes: %v", err)
+	}
+	if !slices.Contains(strings.Fields(string(out)), "origin") {
+		return nil
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", "-C", dir, "remote", "rename", "origin", "template")
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git remote rename: %v\n%s", err, stderr.Bytes())
+	}
+	return nil
+}
+
+// commitTime returns the committer time of HEAD in the git repository at dir.
+func commitTime(dir string) (time.Time, error) {
+	out, err := exec.Command("git", "-C", dir, "log", "-1", "--format=%ct").Output()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("reading commit time: %v", err)
+	}
+	sec, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("reading commit time: %v", err)
+	}
+	return time.Unix(sec, 0), nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// versionRepo makes a template repository whose hello.go says which
+// of its three commits is checked out: the tag v1.0.0 is the first,
+// the branch old the second, and main the third. It returns the
+// repository's directory and the hashes of the commits.
+func versionRepo(t *testing.T) (string, []string) {
+	t.Helper()
+	repo := t.TempDir()
+	hashes := gitRepo(t, repo,
+		map[string]string{"go.mod": "module github.com/example/hello\n", "hello.go": "package hello // one\n"},
+		map[string]string{"hello.go": "package hello // two\n"},
+		map[string]string{"hello.go": "package hello // three\n"},
+	)
+	git(t, repo, "tag", "v1.0.0", hashes[0])
+	git(t, repo, "branch", "old", hashes[1])
+	return repo, hashes
+}
+
+func TestCheckoutVersion(t *testing.T) {
+	repo, hashes := versionRepo(t)
+	tests := []struct {
+		vers, want string
+	}{
+		{"", "three"},
+		{"main", "three"},
+		{"v1.0.0", "one"},
+		{"old", "two"},
+		{hashes[1], "two"},
+		{hashes[0][:12], "one"},
+	}
+	for _, tt := range tests {
+		for _, full := range []bool{false, true} {
+			dir := filepath.Join(t.TempDir(), "clone")
+			logf := func(string, ...any) {}
+			err := cloneRepo(context.Background(), "file://"+filepath.ToSlash(repo), dir, tt.vers, full, nil, nil, logf)
+			if err == nil && tt.vers != "" {
+				err = checkoutVersion(context.Background(), dir, tt.vers, nil)
+			}
+			if err != nil {
+				t.Errorf("cloning @%s (full %v): %v", tt.vers, full, err)
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "hello.go"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(data), tt.want) {
+				t.Errorf("cloning @%s (full %v): hello.go = %q, want commit %s", tt.vers, full, data, tt.want)
+			}
+		}
+	}
+}
+
+func TestCheckoutVersionMissing(t *testing.T) {
+	repo, _ := versionRepo(t)
+	dir := filepath.Join(t.TempDir(), "clone")
+	logf := func(string, ...any) {}
+	// The shallow clone of v9.9.9 fails, and the full one cloned instead
+	// has no such version to check out.
+	if err := cloneRepo(context.Background(), "file://"+filepath.ToSlash(repo), dir, "v9.9.9", false, nil, nil, logf); err != nil {
+		t.Fatal(err)
+	}
+	err := checkoutVersion(context.Background(), dir, "v9.9.9", nil)
+	if err == nil || !strings.Contains(err.Error(), "no tag, branch, or commit named v9.9.9") {
+		t.Errorf("checking out v9.9.9: %v, want no tag, branch, or commit", err)
+	}
+}
+
+func TestCloneLocalVersion(t *testing.T) {
+	repo, _ := versionRepo(t)
+	for vers, want := range map[string]string{"v1.0.0": "one", "old": "two", "main": "three"} {
+		dir := cloneLocal(t, Options{SrcRepo: repo, Version: vers, DstMod: "example.com/hi"})
+		checkTree(t, dir, map[string]string{"hello.go": "package hi // " + want + "\n"})
+	}
+	_, err := CloneContext(context.Background(), Options{SrcRepo: repo, Version: "nope", DstMod: "example.com/hi", Dir: filepath.Join(t.TempDir(), "out")})
+	if err == nil || !strings.Contains(err.Error(), "no tag, branch, or commit named nope") {
+		t.Errorf("cloning @nope: %v, want no tag, branch, or commit", err)
+	}
+}
+
+func TestRepoURLs(t *testing.T) {
+	tests := []struct {
+		repo, ssh, https string
+	}{
+		{"github.com/example/hello", "git@github.com:example/hello.git", "https://github.com/example/hello.git"},
+		{"gitlab.com/group/sub/project", "git@gitlab.com:group/sub/project.git", "https://gitlab.com/group/sub/project.git"},
+		{"git.corp.com:8443/team/repo", "ssh://git@git.corp.com:8443/team/repo.git", "https://git.corp.com:8443/team/repo.git"},
+	}
+	for _, tt := range tests {
+		if got := sshURL(tt.repo); got != tt.ssh {
+			t.Errorf("sshURL(%q) = %q, want %q", tt.repo, got, tt.ssh)
+		}
+		if got := httpsURL(tt.repo); got != tt.https {
+			t.Errorf("httpsURL(%q) = %q, want %q", tt.repo, got, tt.https)
+		}
+	}
+}
+
+func TestPortedModuleRewrite(t *testing.T) {
+	const srcMod = "git.corp.com:8443/team/repo"
+	in := "package main\n\nimport (\n\t\"git.corp.com:8443/team/repo/api\"\n\t\"git.corp.com/team/repo/api\"\n)\n"
+	want := "package main\n\nimport (\n\t\"example.com/hi/api\"\n\t\"git.corp.com/team/repo/api\"\n)\n"
+	got, err := fixGo([]byte(in), "main.go", srcMod, "example.com/hi", primaryPackage{}, false, nil, &RewriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("fixGo:\n%s\nwant:\n%s", got, want)
+	}
+	text := string(rewriteText([]byte("go get git.corp.com:8443/team/repo@latest\n"), srcMod, "example.com/hi", &RewriteOptions{}))
+	if text != "go get example.com/hi@latest\n" {
+		t.Errorf("rewriteText = %q, want go get example.com/hi@latest", text)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	_ "embed"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//go:embed default.gitignore
+var defaultGitignore string
+
+// writeGitignore writes the default .gitignore into dir if it has none.
+// If dir already has a .gitignore, writeGitignore leaves it alone unless
+// merge is set, in which case it appends the default patterns the existing
+// file lacks.
+func writeGitignore(dir string, merge bool) error {
+	file := filepath.Join(dir, ".gitignore")
+	old, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return os.WriteFile(file, []byte(defaultGitignore), 0666)
+	}
+	if err != nil || !merge {
+		return err
+	}
+
+	have := make(map[string]bool)
+	for _, line := range strings.Split(string(old), "\n") {
+		have[strings.TrimSpace(line)] = true
+	}
+	var add []string
+	for _, line := range strings.Split(defaultGitignore, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") && !have[line] {
+			add = append(add, line)
+		}
+	}
+	if len(add) == 0 {
+		return nil
+	}
+	text := string(old)
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	text += "\n# Added by gonew\n" + strings.Join(add, "\n") + "\n"
+	return os.WriteFile(file, []byte(text), 0666)
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteGitignore(t *testing.T) {
+	var missing []string
+	for _, line := range strings.Split(defaultGitignore, "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") && line != "*.test" && line != ".env" {
+			missing = append(missing, line)
+		}
+	}
+	merged := "*.test\n/bin/\n.env\n\n# Added by gonew\n" + strings.Join(missing, "\n") + "\n"
+	tests := []struct {
+		name  string
+		old   *string // nil for no .gitignore
+		merge bool
+		want  string
+	}{
+		{"absent", nil, false, defaultGitignore},
+		{"absent merge", nil, true, defaultGitignore},
+		{"present", ptr("/bin/\n"), false, "/bin/\n"},
+		{"merge", ptr("*.test\n/bin/\n.env"), true, merged},
+		{"merge complete", ptr(defaultGitignore), true, defaultGitignore},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		if tt.old != nil {
+			writeTree(t, dir, map[string]string{".gitignore": *tt.old})
+		}
+		if err := writeGitignore(dir, tt.merge); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		checkTree(t, dir, map[string]string{".gitignore": tt.want})
+	}
+}
+
+func ptr(s string) *string { return &s }
+
+func TestCloneGitignore(t *testing.T) {
+	tmpl := t.TempDir()
+	writeTree(t, tmpl, map[string]string{"go.mod": "module github.com/example/hello\n"})
+	dir := cloneLocal(t, Options{SrcRepo: tmpl, DstMod: "example.com/hi", Gitignore: true})
+	checkTree(t, dir, map[string]string{".gitignore": defaultGitignore})
+
+	writeTree(t, tmpl, map[string]string{".gitignore": "/hello\n"})
+	dir = cloneLocal(t, Options{SrcRepo: tmpl, DstMod: "example.com/hi", Gitignore: true})
+	checkTree(t, dir, map[string]string{".gitignore": "/hello\n"})
+}
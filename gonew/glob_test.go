@@ -0,0 +1,81 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"go.mod", "go.mod", true},
+		{"*.go", "hello.go", true},
+		{"*.go", "cmd/hello/main.go", false},
+		{"cmd/*/main.go", "cmd/hello/main.go", true},
+		{"cmd/**", "cmd/hello/main.go", true},
+		{"cmd/**", "cmd", true},
+		{"**/*.go", "hello.go", true},
+		{"**/*.go", "internal/a/b/c.go", true},
+		{"internal/**/c.go", "internal/c.go", true},
+		{"internal/**/c.go", "internal/a/b/c.go", true},
+		{"internal/**/c.go", "internal/a/b/d.go", false},
+		{"api", "api/api.go", false},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCloneInclude(t *testing.T) {
+	tmpl := t.TempDir()
+	writeTree(t, tmpl, map[string]string{
+		"go.mod":             "module github.com/example/hello\n",
+		"cmd/hello/main.go":  "package main\n\nimport _ \"github.com/example/hello/api\"\n",
+		"cmd/hello/extra.go": "package main\n\nimport _ \"github.com/example/hello/api\"\n",
+		"internal/db/db.go":  "package db\n\nimport _ \"github.com/example/hello/api\"\n",
+		"api/api.go":         "package api\n",
+	})
+	rewritten := "package main\n\nimport _ \"example.com/hi/api\"\n"
+	verbatim := "package db\n\nimport _ \"github.com/example/hello/api\"\n"
+
+	t.Run("include", func(t *testing.T) {
+		dir := cloneLocal(t, Options{
+			SrcRepo:        tmpl,
+			DstMod:         "example.com/hi",
+			RewriteOptions: RewriteOptions{Include: []string{"go.mod", "cmd/**"}},
+		})
+		checkTree(t, dir, map[string]string{
+			"go.mod":             "module example.com/hi\n",
+			"cmd/hello/main.go":  rewritten,
+			"cmd/hello/extra.go": rewritten,
+			"internal/db/db.go":  verbatim,
+		})
+	})
+
+	// Exclude takes precedence: an excluded file is not copied at all,
+	// even though Include would have it rewritten.
+	t.Run("include and exclude", func(t *testing.T) {
+		dir := cloneLocal(t, Options{
+			SrcRepo:        tmpl,
+			DstMod:         "example.com/hi",
+			Exclude:        []string{"extra.go"},
+			RewriteOptions: RewriteOptions{Include: []string{"go.mod", "cmd/**"}},
+		})
+		checkTree(t, dir, map[string]string{
+			"cmd/hello/main.go": rewritten,
+			"internal/db/db.go": verbatim,
+		})
+		if _, err := os.Stat(filepath.Join(dir, "cmd/hello/extra.go")); !os.IsNotExist(err) {
+			t.Errorf("excluded cmd/hello/extra.go: Stat = %v, want not exist", err)
+		}
+	})
+}
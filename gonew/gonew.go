@@ -0,0 +1,1592 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package gonew starts new Go modules from template repositories.
+// It is the implementation of the gonew command, for use by programs
+// that want to create modules from templates without running it.
+//
+// [Clone] clones a template into a new directory and changes its module
+// path, and [CloneContext] does so under a context that can cancel it;
+// [Update] merges later changes to a template into a module created from it;
+// [Add] applies a template for one component to an existing module;
+// [Rehome] changes the module path of an existing module in place;
+// and [RenameImports] rewrites just the import paths of a tree of Go files.
+package gonew
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// RewriteOptions controls how a module's files are rewritten
+// to change its module path.
+type RewriteOptions struct {
+	// Renames lists additional import path renames to apply.
+	Renames []Rename
+
+	// Include, if non-empty, restricts rewriting to the files whose
+	// slash-separated path relative to the module root matches one of
+	// these globs. Globs use path.Match syntax, plus "**" to match any
+	// number of directories. Other files are copied verbatim.
+	Include []string
+
+	// SkipDirs lists the names of directories, at any depth, whose files
+	// are copied without rewriting. Nil means DefaultSkipDirs;
+	// an empty, non-nil list rewrites every directory. A skipped vendor
+	// directory that holds copies of modules within the source module,
+	// as for a replace directive naming a sibling module, still has those
+	// copies moved and rewritten, along with its modules.txt, so that it
+	// stays consistent with go.mod.
+	SkipDirs []string
+
+	// MaxFileSize is the size in bytes above which a file is copied
+	// without being read into memory for rewriting. Zero means no limit.
+	MaxFileSize int64
+
+	// Strict makes unreadable files and directories, and Go files
+	// that do not parse, an error instead of being copied without
+	// rewriting.
+	Strict bool
+
+	// RewriteStrings also rewrites Go string literals whose value
+	// is exactly the source module path.
+	RewriteStrings bool
+
+	// RewriteComments also rewrites the module path in the comments of
+	// Go files, such as generated-code headers; it is always rewritten
+	// in //go:generate directives.
+	RewriteComments bool
+
+	// RenameUses renames the uses of the primary package in the files that
+	// import it when its name changes, instead of importing it under its old
+	// name, and likewise for the module root packages of Renames. Files that
+	// do not parse, or in which the new name is already taken in a scope
+	// holding one of the uses or anywhere in the file's package, are still
+	// given the import alias.
+	RenameUses bool
+
+	// RewriteCodegen also rewrites the module path in code generator
+	// configuration: sqlc.yaml, sqlc.yml, sqlc.json, and entc.go.
+	RewriteCodegen bool
+
+	// RewriteExt lists file extensions, without the leading dot, and
+	// file names, such as "md" and "Dockerfile", of other text files
+	// in which to rewrite the module path. Binary files are left alone.
+	RewriteExt []string
+
+	// Replace lists further strings to replace, in order, in every text
+	// file, once the module path is rewritten.
+	Replace []Replacement
+
+	// GoVersion, if non-empty, is the Go version, such as 1.22, to set
+	// in the go directive of go.mod, and in its toolchain line if it has one.
+	GoVersion string
+
+	// Jobs is the number of files to rewrite in parallel.
+	// Zero means runtime.GOMAXPROCS(0).
+	Jobs int
+
+	// Logf, if non-nil, is called to describe each step as it is taken,
+	// such as running git or rewriting a file. It may be called from
+	// several goroutines at once.
+	Logf func(format string, args ...any)
+
+	// Warnf, if non-nil, is called to report problems that do not stop
+	// the rewrite, such as a file too large to rewrite. It may be called
+	// from several goroutines at once.
+	Warnf func(format string, args ...any)
+
+	// pathRenames lists the directories RenamePaths renamed, with their
+	// slash-separated paths relative to the module root.
+	pathRenames []Rename
+}
+
+// check reports an error if opts are invalid for rewriting srcMod.
+func (opts *RewriteOptions) check(srcMod string) error {
+	if v := opts.GoVersion; v != "" && !modfile.GoVersionRE.MatchString(v) {
+		return fmt.Errorf("invalid Go version %q", v)
+	}
+	return checkRenames(srcMod, opts.Renames)
+}
+
+// DefaultSkipDirs lists the directories that are not rewritten by default:
+// vendored copies of other modules, test data, which by convention
+// is left alone by Go tools, and JavaScript dependencies.
+var DefaultSkipDirs = []string{"vendor", "testdata", "node_modules"}
+
+// skipDir reports whether the directory with the given name is not
+// to be rewritten.
+func (opts *RewriteOptions) skipDir(name string) bool {
+	dirs := opts.SkipDirs
+	if dirs == nil {
+		dirs = DefaultSkipDirs
+	}
+	for _, dir := range dirs {
+		if name == dir {
+			return true
+		}
+	}
+	return false
+}
+
+func (opts *RewriteOptions) logf(format string, args ...any) {
+	if opts.Logf != nil {
+		opts.Logf(format, args...)
+	}
+}
+
+func (opts *RewriteOptions) warnf(format string, args ...any) {
+	if opts.Warnf != nil {
+		opts.Warnf(format, args...)
+	}
+}
+
+// Options controls [Clone].
+type Options struct {
+	RewriteOptions
+
+	// SrcRepo is the module path of the template repository,
+	// such as github.com/example/hello, or a local directory holding
+	// the template, such as ./templates/hello or file:///templates/hello.
+	// A local template is copied rather than cloned, and its module path
+	// is read from its go.mod file.
+	SrcRepo string
+
+	// Version names the tag, branch, or commit, or the pull request head
+	// of the form pull/N/head, to check out. Empty means the default branch.
+	Version string
+
+	// DstMod is the module path of the new module.
+	// Empty means the module path of the template.
+	DstMod string
+
+	// Dir is the directory in which to create the new module.
+	// It must not exist or be an empty directory, or one holding nothing
+	// but a .git directory, as a fresh clone of an empty repository does,
+	// which is kept, and GitInit is then ignored.
+	// Empty means the final path element of DstMod,
+	// in the current directory.
+	Dir string
+
+	// Subdir names a directory of SrcRepo, in slash-separated form, that holds
+	// the template, as when one repository holds several templates. Only that
+	// directory becomes the new module, and its go.mod gives the template's
+	// module path. If DstMod is empty, the new module keeps that path.
+	Subdir string
+
+	// HTTPS clones SrcRepo over HTTPS instead of SSH.
+	// For a repository on a host other than GitHub, GitLab, or Bitbucket,
+	// Clone first looks for a go-import meta tag, as "go get" does, to find
+	// the repository's URL, falling back to the module path if there is none.
+	HTTPS bool
+
+	// Token, if not empty, is an access token with which to clone SrcRepo
+	// over HTTPS, as for a private template; it implies HTTPS. It is sent to
+	// git in an Authorization header, scoped to the repository's host, and
+	// never appears in command lines or in the new module. Without it, git's
+	// own credential helpers and ~/.netrc apply, and GIT_SSH_COMMAND and the
+	// SSH agent for SSH clones, as usual.
+	Token string
+
+	// NoFallback disables retrying over HTTPS when cloning over SSH
+	// fails to authenticate.
+	NoFallback bool
+
+	// Proxy downloads the template through the Go module proxy, using
+	// "go mod download", instead of cloning it with git. It then needs no
+	// git or SSH keys, and it honors GOPROXY, GONOSUMDB, and the other go
+	// command settings. The template's Version must be a module version
+	// or query, such as v1.2.3 or a branch name; empty means latest.
+	// With Offline, only the module cache is used, and CacheDir is not.
+	Proxy bool
+
+	// FullClone clones the template's whole history. Otherwise only the
+	// requested commit is fetched, since the history is discarded anyway.
+	FullClone bool
+
+	// Submodules checks out the template's git submodules, recursively,
+	// at the commits it pins, even in a cached clone. Whether or not it is
+	// set, the submodules become plain directories in the new module,
+	// unless KeepGit is set, and Result and the RecordFile list their
+	// commits; those not checked out are left out, with a warning.
+	Submodules bool
+
+	// RequireCommit, if set, is the hash of the commit, or a prefix of it
+	// of at least seven hex digits, the template must be at once Version
+	// is checked out, so that a tag moved to other code is noticed.
+	RequireCommit string
+
+	// AllowedTemplates, if not empty, makes Clone fail unless SrcRepo,
+	// and each overlay's, matches one of these glob patterns, as an
+	// organization's [Config] requires. A pattern is matched against the
+	// repository path, or the absolute slash-separated path of a local
+	// template, with each element matched as by [path.Match] and an element
+	// ** matching any number of elements, so that github.com/example/*
+	// matches each repository of the example organization.
+	AllowedTemplates []string
+
+	// VerifySignature requires the tag Version names, or if it is not a
+	// tag, the commit checked out, to be signed by a key git trusts, as
+	// "git verify-tag" and "git verify-commit" check.
+	VerifySignature bool
+
+	// RequireSumDB, with Proxy, fails unless the go command checks the
+	// template against the checksum database, which GOSUMDB=off or a
+	// GONOSUMDB or GOPRIVATE pattern matching it would prevent, and the
+	// template's files in the module cache still have the hash checked.
+	RequireSumDB bool
+
+	// Progress, if non-nil, receives git's report of its progress
+	// as it clones the template, which for a large template
+	// can take some time.
+	Progress io.Writer
+
+	// CacheDir is the directory caching cloned templates.
+	// Empty disables the cache.
+	CacheDir string
+
+	// Offline uses only the copy of the template in CacheDir
+	// instead of cloning it over the network.
+	Offline bool
+
+	// Exclude lists further patterns, in the .gitignore syntax of the
+	// template's IgnoreFile, of files and directories not to copy into the
+	// new module. They follow the IgnoreFile's own, and so override them.
+	Exclude []string
+
+	// Vars holds the template variables that decide which files
+	// named in the template's .gonew/manifest.yaml are kept,
+	// and that Subst substitutes for placeholders. A variable the
+	// manifest declares, but Vars does not set, takes its default.
+	Vars map[string]string
+
+	// DefaultVars overrides the defaults the manifest gives the variables
+	// it declares, as an organization's [Config] does for, say, Org, so
+	// that they apply, and Ask offers them, wherever Vars does not set
+	// the variables. It does not set variables the manifest does not declare.
+	DefaultVars map[string]string
+
+	// Features selects the optional features the template's manifest
+	// declares to include: the files that belong to them, and the blocks
+	// of text between lines with the comments gonew:feature name and
+	// gonew:end. The blocks of other features are removed, as are the
+	// comments marking every block; a block whose comment reads
+	// gonew:feature !name is instead kept only without the feature.
+	// If Features is nil, the features marked as default are included.
+	Features []string
+
+	// Ask, if not nil, is called for each variable the manifest declares
+	// but Vars does not set, with its name, description, and default,
+	// to ask the user for its value instead of using the default.
+	Ask func(name, description, def string) (string, error)
+
+	// RenamePaths renames the files and directories whose names contain
+	// the last element of the template's module path, as a whole word,
+	// to contain that of DstMod instead, so that cmd/hello becomes
+	// cmd/myprog, and rewrites the imports of the directories it moves.
+	RenamePaths bool
+
+	// Subst replaces each placeholder of the form {{.Name}} in the text
+	// files of the new module with the value of the variable Name, after
+	// the module path is rewritten. Besides Vars, the variables ModulePath
+	// (the new module path), ProjectName (its last element, without any
+	// major version suffix), and Year (the current year) are filled in
+	// automatically, unless Vars sets them. A placeholder naming any other
+	// variable is an error, unless AllowMissingVars is set, in which case
+	// it is left as is.
+	Subst            bool
+	AllowMissingVars bool
+
+	// KeepGit keeps the template's .git directory in the new module,
+	// so that it can later pull changes from the template. The history
+	// kept is only that of the clone, so KeepGit is usually combined with
+	// FullClone. GitInit is ignored when KeepGit is set, but GitCommit
+	// then commits the changes made to the template on top of its history.
+	KeepGit bool
+
+	// RenameOrigin, with KeepGit, renames the template's origin remote
+	// to "template", leaving the name origin free for the new module.
+	RenameOrigin bool
+
+	// Baseline, if set, names a directory, which must not exist, into which
+	// Clone copies the template's files just before rewriting them, once
+	// its manifest is applied and its paths renamed, so that the new
+	// module can be compared with it file by file, as for a dry run. It
+	// works for every kind of template, with or without a git history.
+	Baseline string
+
+	// GitInit initializes a new git repository in the new module,
+	// and GitCommit additionally commits every file in it, with the
+	// message "Initialized from <template>@<version>".
+	GitInit   bool
+	GitCommit bool
+
+	// SetOrigin, with GitInit, sets the new repository's origin remote
+	// to the repository URL inferred from DstMod, over SSH or, if HTTPS
+	// is set, HTTPS. Push, with GitCommit and SetOrigin, then pushes the
+	// initial commit there, for a remote that already exists and is empty.
+	// A failed push is only a warning, since the new module is complete.
+	SetOrigin bool
+	Push      bool
+
+	// Record writes a RecordFile describing where the module came from.
+	Record bool
+
+	// Gitignore writes a standard Go .gitignore into the new module
+	// if the template has none. GitignoreMerge also appends the
+	// standard patterns missing from an existing .gitignore.
+	Gitignore      bool
+	GitignoreMerge bool
+
+	// Author and Org name the new module's owner, replacing the template's
+	// copyright holder, in the copyright lines of its license and of the
+	// headers of its files, with Org, if set, or else Author, and naming
+	// them in its CODEOWNERS, AUTHORS, and CONTRIBUTORS files. License,
+	// one of mit, apache2, or none, replaces the template's license files
+	// with a LICENSE file for that license, or removes them, and updates
+	// the license named in the headers to match. Year, if not zero, is
+	// the year to give the copyright lines; otherwise it is this year.
+	Author, Org string
+	License     string
+	Year        int
+
+	// Tidy runs "go mod tidy" in the new module once it is rewritten,
+	// and in each module nested in it, the innermost first, so that their
+	// go.sum files are up to date, and Format then runs "gofmt -w ." there.
+	// Without Tidy, the go.sum files keep the template's checksums, less
+	// those of the modules renamed along with the template's own.
+	Tidy   bool
+	Format bool
+
+	// Verify runs "go build ./..." and "go vet ./..." in the new module,
+	// once it is otherwise complete, to check that it compiles.
+	// If either fails, Clone returns a *VerifyError and leaves the
+	// new module in place for inspection.
+	Verify bool
+
+	// Exec lists further commands, each a program name followed by its
+	// arguments, to run in the new module after Tidy, such as
+	// {"gofmt", "-w", "."}. A command that fails causes Clone to fail.
+	Exec [][]string
+
+	// RunHooks runs the post-generation commands listed in the template's
+	// manifest, after Tidy and before Exec. Since they come from the
+	// template, they are not run by default; Clone warns about them instead.
+	RunHooks bool
+
+	// Keep moves a partially created module into place when Clone fails,
+	// for debugging, instead of removing it.
+	Keep bool
+
+	// Force makes Clone create the module in a Dir that is not empty,
+	// replacing the files there that the template also has and leaving
+	// the others alone, and makes [Add] replace the files of the existing
+	// module that the template also has, instead of failing.
+	Force bool
+
+	// AllowExisting is like Force, but keeps the files already in Dir,
+	// or in the module [Add] adds to, writing only the template's files
+	// that do not exist yet. Result.Kept lists the files it kept.
+	AllowExisting bool
+
+	// ModTime, if non-zero, is the modification time to give every file.
+	// Otherwise TouchModTime uses the commit time of the template.
+	ModTime      time.Time
+	TouchModTime bool
+
+	// Overlays lists further templates to apply, in order, on top of
+	// SrcRepo, as [Add] would with Force set, once it is rewritten: a file
+	// of an overlay replaces that of the base template or of an earlier
+	// overlay, and the requirements of their go.mod files are merged.
+	// Vars and Features apply to every template, a feature needing only
+	// one of them to declare it, and their manifests' hooks run, with
+	// RunHooks, after the base template's. Result and the RecordFile
+	// list the overlays applied.
+	Overlays []Overlay
+
+	// composing, if not nil, collects the hooks and features of an
+	// overlay being applied, instead of running or checking them.
+	composing *composition
+
+	// inspect, if not nil, is called with the template as fetched, once
+	// any subdirectory is hoisted, and its manifest, as for [Lint].
+	inspect func(dir string, m *manifest) error
+}
+
+// A Result describes the outcome of a rewrite.
+type Result struct {
+	Dir       string        `json:"dir"`       // absolute path of the module root
+	Rewritten []string      `json:"rewritten"` // files changed, slash-separated and relative to Dir, in sorted order
+	Skipped   []SkippedFile `json:"skipped"`   // files copied without rewriting, in sorted order
+
+	// The remaining fields are set only by Clone.
+	Template     string            `json:"template"`             // module path of the template, with any //subdir
+	Version      string            `json:"version,omitempty"`    // template version requested, if any
+	Commit       string            `json:"commit,omitempty"`     // commit the template was cloned at, if known
+	Hash         string            `json:"hash"`                 // hash of the template's files, in go.sum's "h1:" form
+	Submodules   []Submodule       `json:"submodules,omitempty"` // git submodules of the template, with their pinned commits
+	Module       string            `json:"module"`               // module path of the new module
+	Files        []string          `json:"files"`                // every file created, slash-separated and relative to Dir, in sorted order
+	Renames      []Rename          `json:"renames"`              // import paths rewritten besides the module path
+	Replacements []Replacement     `json:"replacements"`         // strings replaced
+	Vars         map[string]string `json:"vars"`                 // template variables, as set, answered, or defaulted
+	Features     []string          `json:"features"`             // optional features included, in sorted order
+	Kept         []string          `json:"kept,omitempty"`       // files of the template not written, since they existed, in sorted order
+	Overlays     []Overlay         `json:"overlays,omitempty"`   // templates applied on top of Template, in order
+}
+
+func newResult(dir string, rewritten map[string]bool, skipped *skipReport) *Result {
+	r := &Result{Dir: dir, Skipped: skipped.sorted()}
+	for name := range rewritten {
+		r.Rewritten = append(r.Rewritten, name)
+	}
+	sort.Strings(r.Rewritten)
+	return r
+}
+
+// Clone creates a new module in opts.Dir from the template opts.SrcRepo,
+// changing its module path to opts.DstMod. It builds the module in a
+// staging directory and moves it to opts.Dir only once it is complete,
+// so if Clone fails, opts.Dir is left as it was, unless opts.Keep is set.
+//
+// The files of the new module keep the modes of the template's. Symbolic
+// links pointing outside the template are left out, and each directory
+// empty in the template gets a [KeepFile].
+func Clone(opts Options) (*Result, error) {
+	return CloneContext(context.Background(), opts)
+}
+
+// CloneContext is like Clone, but stops, failing with ctx.Err(),
+// once ctx is done: it kills any git, go, or hook command it is
+// running, and it cleans up as for any other failure.
+func CloneContext(ctx context.Context, opts Options) (res *Result, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	srcMod := opts.SrcRepo
+	if srcMod == "" {
+		return nil, errors.New("no template repository")
+	}
+	if err := checkAllowed(opts.SrcRepo, opts.AllowedTemplates); err != nil {
+		return nil, err
+	}
+	local := IsLocal(opts.SrcRepo)
+	if local {
+		srcMod, err = LocalModulePath(opts.SrcRepo)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.Push && !(opts.GitInit && opts.GitCommit && opts.SetOrigin) {
+		return nil, errors.New("pushing requires GitInit, GitCommit, and SetOrigin")
+	}
+	if opts.Force && opts.AllowExisting {
+		return nil, errors.New("cannot set both Force and AllowExisting")
+	}
+	license, err := checkLicense(opts.License)
+	if err != nil {
+		return nil, err
+	}
+	if license == "mit" && opts.Author == "" && opts.Org == "" {
+		return nil, errors.New("an MIT license needs an Author or Org to name as the copyright holder")
+	}
+	if opts.RequireCommit != "" {
+		if err := checkRequireCommit(opts.RequireCommit); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Proxy && (opts.RequireCommit != "" || opts.VerifySignature) {
+		return nil, errors.New("a template downloaded through the module proxy has no commit to check; use RequireSumDB")
+	}
+	if opts.RequireSumDB && !opts.Proxy {
+		return nil, errors.New("RequireSumDB requires Proxy")
+	}
+	if opts.Subdir != "" {
+		switch {
+		case !fs.ValidPath(opts.Subdir) || opts.Subdir == ".":
+			return nil, fmt.Errorf("invalid template subdirectory %q", opts.Subdir)
+		case local:
+			return nil, errors.New("a local template cannot have a subdirectory; name the subdirectory itself")
+		case opts.Proxy:
+			return nil, errors.New("a template downloaded through the module proxy cannot have a subdirectory; name its module instead")
+		case opts.KeepGit:
+			return nil, errors.New("cannot keep the git history of a template in a subdirectory")
+		}
+	}
+	dstMod := opts.DstMod
+	if dstMod == "" {
+		dstMod = srcMod
+		if opts.Subdir != "" {
+			dstMod = srcMod + "/" + opts.Subdir
+		}
+	}
+	if err := opts.check(srcMod); err != nil {
+		return nil, err
+	}
+	dir := opts.Dir
+	if dir == "" {
+		dir = path.Base(dstMod)
+	}
+	final, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	merge, err := checkDest(final, opts.Force || opts.AllowExisting)
+	if err != nil {
+		return nil, err
+	}
+	if merge {
+		opts.logf("%s is not empty; merging the new module into it", final)
+	}
+
+	// Build the module in a staging directory on the same file system as
+	// its final one, beside it or, if it already exists, inside it, and
+	// move it into place only once it is complete, so that a failure never
+	// leaves a half-rewritten module behind. Any parent directories created
+	// for it are removed again on failure.
+	_, statErr := os.Stat(final)
+	existed := statErr == nil
+	created := ""
+	for d := filepath.Dir(final); ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil || filepath.Dir(d) == d {
+			break
+		}
+		created = d
+	}
+	if err := os.MkdirAll(filepath.Dir(final), 0777); err != nil {
+		return nil, err
+	}
+	stageParent := filepath.Dir(final)
+	if existed {
+		stageParent = final
+	}
+	stage, err := os.MkdirTemp(stageParent, ".gonew-")
+	if err != nil {
+		return nil, err
+	}
+	dst := filepath.Join(stage, filepath.Base(final))
+	if err := os.Mkdir(dst, 0777); err != nil {
+		os.RemoveAll(stage)
+		return nil, err
+	}
+	moved := false
+	defer func() {
+		if err != nil && !moved {
+			if opts.Keep {
+				if _, perr := publish(dst, final, existed, merge, opts.Force); perr == nil {
+					moved = true
+				}
+			}
+			var verr *VerifyError
+			if !errors.As(err, &verr) {
+				err = &stagedError{err: err, stage: dst, final: final}
+			}
+		}
+		os.RemoveAll(stage)
+		if err != nil && !moved && created != "" {
+			os.RemoveAll(created)
+		}
+	}()
+	opts.logf("building the new module in %s", dst)
+	opts.Logf = unstage(opts.Logf, dst, final)
+	opts.Warnf = unstage(opts.Warnf, dst, final)
+
+	switch {
+	case local && isArchive(opts.SrcRepo):
+		if opts.Version != "" {
+			return nil, fmt.Errorf("%s@%s: an archive has no versions", opts.SrcRepo, opts.Version)
+		}
+		opts.logf("extracting %s to %s", opts.SrcRepo, dst)
+		if err := extractArchive(localDir(opts.SrcRepo), dst); err != nil {
+			return nil, err
+		}
+	case local:
+		opts.logf("copying %s to %s", opts.SrcRepo, dst)
+		if err := copyLocal(opts.SrcRepo, dst); err != nil {
+			return nil, err
+		}
+		if opts.Version != "" {
+			opts.logf("checking out %s", opts.Version)
+			if err := checkoutVersion(ctx, dst, opts.Version, nil); err != nil {
+				return nil, fmt.Errorf("%s@%s: %v", opts.SrcRepo, opts.Version, err)
+			}
+		}
+		if opts.Submodules && headCommit(dst) != "" {
+			if err := updateSubmodules(ctx, dst, opts.FullClone, nil, opts.logf); err != nil {
+				return nil, err
+			}
+		}
+	case opts.Proxy:
+		pm, err := downloadModule(ctx, srcMod, opts.Version, opts.Offline, opts.logf)
+		if err != nil {
+			return nil, err
+		}
+		opts.Version = pm.Version
+		if opts.RequireSumDB {
+			opts.logf("checking that %s@%s was verified against the checksum database", srcMod, pm.Version)
+			if err := checkSumDB(ctx, srcMod, pm); err != nil {
+				return nil, err
+			}
+		}
+		if opts.TouchModTime && opts.ModTime.IsZero() {
+			// There is no commit to take the time from.
+			if opts.ModTime, err = pm.time(); err != nil {
+				return nil, err
+			}
+		}
+		opts.logf("copying %s from the module cache to %s", srcMod, dst)
+		if err := copyTree(pm.Dir, dst); err != nil {
+			return nil, err
+		}
+	case opts.Offline:
+		opts.logf("copying %s from the template cache to %s", srcMod, dst)
+		if err := loadCache(opts.CacheDir, srcMod, opts.Version, dst); err != nil {
+			return nil, err
+		}
+	default:
+		urls, err := findRepo(ctx, srcMod, opts.logf)
+		if err != nil {
+			return nil, err
+		}
+		https := opts.HTTPS || opts.Token != ""
+		giturl := urls.ssh
+		var env []string
+		if https {
+			giturl = urls.https
+			env = tokenEnv(giturl, opts.Token)
+		}
+		// A cached clone of the commit the version names in the repository
+		// now saves cloning again, as in a workshop creating the same
+		// module over and over; a full clone is never cached.
+		if cached := opts.cachedClone(ctx, srcMod, giturl, env); cached {
+			opts.logf("copying %s from the template cache to %s", srcMod, dst)
+			if err := loadCache(opts.CacheDir, srcMod, opts.Version, dst); err != nil {
+				return nil, err
+			}
+			if opts.Submodules {
+				if err := updateSubmodules(ctx, dst, opts.FullClone, env, opts.logf); err != nil {
+					return nil, err
+				}
+			}
+			break
+		}
+		err = cloneRepo(ctx, giturl, dst, opts.Version, opts.FullClone, env, opts.Progress, opts.logf)
+		if err != nil && !https && !opts.NoFallback && sshAuthFailed(err) {
+			// A public template can still be cloned anonymously over
+			// HTTPS. Start again from a clean destination, and keep git
+			// from prompting for credentials the user never meant to give.
+			opts.warnf("cloning over SSH failed; retrying over HTTPS")
+			emptyDir(dst)
+			if err2 := cloneRepo(ctx, urls.https, dst, opts.Version, opts.FullClone, []string{"GIT_TERMINAL_PROMPT=0"}, opts.Progress, opts.logf); err2 != nil {
+				return nil, fmt.Errorf("%v\nretrying over HTTPS: %v", err, err2)
+			}
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if opts.Version != "" {
+			opts.logf("checking out %s", opts.Version)
+			if err := checkoutVersion(ctx, dst, opts.Version, env); err != nil {
+				return nil, fmt.Errorf("%s@%s: %v", srcMod, opts.Version, err)
+			}
+		}
+		if opts.Submodules {
+			if err := updateSubmodules(ctx, dst, opts.FullClone, env, opts.logf); err != nil {
+				return nil, err
+			}
+		}
+		if opts.CacheDir != "" && !opts.FullClone {
+			if err := saveCache(opts.CacheDir, srcMod, opts.Version, dst); err != nil {
+				opts.warnf("caching template: %v", err)
+			}
+		}
+	}
+
+	commit := headCommit(dst)
+	if err := opts.checkSource(ctx, dst, commit); err != nil {
+		return nil, err
+	}
+	subs, err := submodules(ctx, dst)
+	if err != nil {
+		return nil, err
+	}
+	if len(subs) > 0 && !opts.KeepGit {
+		empty, err := flattenSubmodules(dst, subs)
+		if err != nil {
+			return nil, err
+		}
+		if len(empty) > 0 {
+			opts.warnf("template submodules %s are not checked out; leaving them out", strings.Join(empty, ", "))
+		}
+	}
+	hash, err := templateHash(dst, srcMod, opts.Version)
+	if err != nil {
+		return nil, err
+	}
+	modTime := opts.ModTime
+	if modTime.IsZero() && opts.TouchModTime {
+		modTime, err = commitTime(dst)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	template := srcMod
+	if opts.Subdir != "" {
+		template = srcMod + "//" + opts.Subdir
+		opts.logf("using subdirectory %s as the template", opts.Subdir)
+		if err := hoistSubdir(dst, opts.Subdir); err != nil {
+			return nil, err
+		}
+		// The template's own go.mod gives its module path.
+		data, err := os.ReadFile(filepath.Join(dst, "go.mod"))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", template, err)
+		}
+		if srcMod = modfile.ModulePath(data); srcMod == "" {
+			return nil, fmt.Errorf("%s: go.mod has no module statement", template)
+		}
+		if opts.DstMod == "" {
+			dstMod = srcMod
+		}
+		if err := opts.check(srcMod); err != nil {
+			return nil, err
+		}
+	}
+
+	links, err := removeUnsafeLinks(dst)
+	if err != nil {
+		return nil, err
+	}
+	if len(links) > 0 {
+		opts.warnf("leaving out symbolic links %s, which point outside the template", strings.Join(links, ", "))
+	}
+	empty, err := emptyDirs(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := readManifest(dst)
+	if err != nil {
+		return nil, err
+	}
+	if opts.inspect != nil {
+		if err := opts.inspect(dst, m); err != nil {
+			return nil, err
+		}
+	}
+	vars, err := m.vars(opts.Vars, opts.DefaultVars, opts.Ask)
+	if err != nil {
+		return nil, err
+	}
+	comp := opts.composing
+	if comp == nil && len(opts.Overlays) > 0 {
+		comp = &composition{features: make(map[string]bool)}
+	}
+	features, err := m.features(opts.Features, comp != nil)
+	if err != nil {
+		return nil, err
+	}
+	if comp != nil {
+		comp.addFeatures(features)
+	}
+	if m != nil {
+		if err := applyConditions(dst, m, vars); err != nil {
+			return nil, err
+		}
+		if err := removeFeatureFiles(dst, m, features); err != nil {
+			return nil, err
+		}
+	}
+	if err := removeManifest(dst); err != nil {
+		return nil, err
+	}
+	if err := applyIgnore(dst, opts.Exclude); err != nil {
+		return nil, err
+	}
+
+	if err := checkModule(dst, srcMod, opts.warnf); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if opts.RenamePaths {
+		renames, err := renamePaths(dst, srcMod, dstMod, &opts.RewriteOptions)
+		if err != nil {
+			return nil, err
+		}
+		opts.Renames = append(opts.Renames[:len(opts.Renames):len(opts.Renames)], renames...)
+		for _, r := range renames {
+			opts.pathRenames = append(opts.pathRenames, Rename{Old: strings.TrimPrefix(r.Old, srcMod+"/"), New: strings.TrimPrefix(r.New, dstMod+"/")})
+		}
+	}
+
+	if opts.Baseline != "" {
+		if err := copyTree(dst, opts.Baseline); err != nil {
+			return nil, err
+		}
+		if err := os.RemoveAll(filepath.Join(opts.Baseline, ".git")); err != nil {
+			return nil, err
+		}
+	}
+
+	rewritten, skipped, gitdir, err := rewriteTree(ctx, dst, srcMod, dstMod, &opts.RewriteOptions, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(features) > 0 {
+		opts.logf("applying optional features")
+		if err := applyFeatureBlocks(dst, features, &opts.RewriteOptions, rewritten); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Subst || m != nil && len(m.Render) > 0 {
+		var only []string // nil means all files
+		if !opts.Subst {
+			only = m.Render
+		}
+		opts.logf("substituting template placeholders")
+		if err := substituteTree(dst, substVars(dstMod, vars), only, opts.AllowMissingVars, &opts.RewriteOptions, rewritten); err != nil {
+			return nil, err
+		}
+	}
+
+	replace := opts.Replace
+	if m != nil {
+		r, err := m.replacements(substVars(dstMod, vars))
+		if err != nil {
+			return nil, err
+		}
+		replace = append(replace, r...)
+	}
+	if len(replace) > 0 {
+		opts.logf("replacing strings")
+		if err := replaceTree(dst, replace, &opts.RewriteOptions, rewritten); err != nil {
+			return nil, err
+		}
+	}
+
+	if own := (ownership{opts.Author, opts.Org, license, opts.Year}); own.set() {
+		if own.year == 0 {
+			own.year = time.Now().Year()
+		}
+		opts.logf("rewriting copyright and ownership")
+		if err := rewriteOwnership(dst, own, &opts.RewriteOptions, rewritten); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var overlays []Overlay
+	if len(opts.Overlays) > 0 {
+		overlays, err = applyOverlays(ctx, dst, &opts, comp, vars, rewritten)
+		if err != nil {
+			return nil, err
+		}
+		if err := comp.checkFeatures(opts.Features); err != nil {
+			return nil, err
+		}
+		features = comp.features
+	}
+
+	// Remove .git directory
+	if gitdir != "" && !opts.KeepGit {
+		opts.logf("removing %s", gitdir)
+		if err := os.RemoveAll(gitdir); err != nil {
+			return nil, err
+		}
+	}
+	if gitdir != "" && opts.KeepGit && opts.RenameOrigin {
+		opts.logf("renaming remote origin to template")
+		if err := renameOrigin(dst); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Record {
+		r := record{
+			Template:   template,
+			Proxy:      opts.Proxy,
+			Version:    opts.Version,
+			Commit:     commit,
+			Hash:       hash,
+			Submodules: subs,
+			Time:       time.Now().UTC().Truncate(time.Second),
+			Module:     dstMod,
+			Vars:       vars,
+			Features:   features,
+			Overlays:   overlays,
+			Gonew:      gonewVersion(),
+		}
+		if local {
+			if r.Source, err = filepath.Abs(localDir(opts.SrcRepo)); err != nil {
+				return nil, err
+			}
+		}
+		if err := writeRecord(dst, r); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Gitignore || opts.GitignoreMerge {
+		if err := writeGitignore(dst, opts.GitignoreMerge); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Tidy {
+		mods, err := moduleDirs(dst)
+		if err != nil {
+			return nil, err
+		}
+		for _, rel := range mods {
+			if rel == "." {
+				opts.logf("running go mod tidy")
+			} else {
+				opts.logf("running go mod tidy in %s", rel)
+			}
+			if err := runHook(ctx, filepath.Join(dst, filepath.FromSlash(rel)), []string{"go", "mod", "tidy"}, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if opts.Format {
+		opts.logf("running gofmt -w .")
+		if err := runHook(ctx, dst, []string{"gofmt", "-w", "."}, nil); err != nil {
+			return nil, err
+		}
+	}
+	var hooks []string
+	if m != nil {
+		hooks = m.Hooks
+	}
+	switch {
+	case opts.composing != nil:
+		// The module the overlay is applied to runs them.
+		opts.composing.hooks = append(opts.composing.hooks, hooks...)
+		hooks = nil
+	case comp != nil:
+		hooks = append(hooks[:len(hooks):len(hooks)], comp.hooks...)
+	}
+	if len(hooks) > 0 {
+		if opts.RunHooks {
+			// Say what is about to run even when not logging every step,
+			// since the commands come from the template, not the user.
+			opts.warnf("running the template's post-generation commands: %s", strings.Join(hooks, "; "))
+			env := hookEnv(os.Environ())
+			for _, h := range hooks {
+				args := strings.Fields(h)
+				opts.logf("running %s", strings.Join(args, " "))
+				if err := runHook(ctx, dst, args, env); err != nil {
+					return nil, err
+				}
+			}
+		} else {
+			opts.warnf("not running the template's post-generation commands: %s", strings.Join(hooks, "; "))
+		}
+	}
+	for _, args := range opts.Exec {
+		opts.logf("running %s", strings.Join(args, " "))
+		if err := runHook(ctx, dst, args, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := keepDirs(dst, empty, opts.logf); err != nil {
+		return nil, err
+	}
+	if !modTime.IsZero() {
+		if err := touchTree(dst, modTime); err != nil {
+			return nil, err
+		}
+	}
+
+	message := ""
+	if opts.GitCommit {
+		message = "Initialized from " + template
+		if opts.Version != "" {
+			message += "@" + opts.Version
+		}
+	}
+	if opts.KeepGit && opts.GitCommit && gitdir != "" {
+		opts.logf("committing the new module on top of the template's history")
+		if err := commitAll(ctx, dst, message); err != nil {
+			return nil, err
+		}
+	}
+	hasGit := false
+	if merge {
+		if _, err := os.Lstat(filepath.Join(final, ".git")); err == nil {
+			hasGit = true
+		}
+	}
+	if hasGit && (opts.GitInit || opts.KeepGit) {
+		opts.logf("%s already has a .git directory; leaving it as it is", final)
+	}
+	if opts.GitInit && !opts.KeepGit && !hasGit {
+		opts.logf("initializing a git repository in %s", dst)
+		origin := ""
+		if opts.SetOrigin {
+			origin = sshURL(dstMod)
+			if opts.HTTPS {
+				origin = httpsURL(dstMod)
+			}
+		}
+		if err := initRepo(ctx, dst, message, origin); err != nil {
+			return nil, err
+		}
+	}
+
+	files, err := listFiles(dst)
+	if err != nil {
+		return nil, err
+	}
+	opts.logf("moving the new module to %s", final)
+	kept, err := publish(dst, final, existed, merge, opts.Force)
+	if err != nil {
+		return nil, err
+	}
+	moved = true
+	dst = final
+	for _, name := range kept {
+		opts.logf("kept existing %s", name)
+	}
+
+	if opts.GitInit && !opts.KeepGit && !hasGit && opts.Push {
+		// The module is complete, so a failed push is no reason
+		// to remove it; the user can push again by hand.
+		opts.logf("pushing the new module")
+		if err := pushRepo(ctx, dst); err != nil {
+			opts.warnf("%v", err)
+		}
+	}
+
+	if opts.Verify {
+		for _, args := range [][]string{{"go", "build", "./..."}, {"go", "vet", "./..."}} {
+			opts.logf("running %s", strings.Join(args, " "))
+			if err := runHook(ctx, dst, args, nil); err != nil {
+				return nil, &VerifyError{Dir: dst, Err: err}
+			}
+		}
+	}
+
+	opts.logf("created module %s in %s", dstMod, dst)
+	res = newResult(dst, rewritten, skipped)
+	res.Template = template
+	res.Version = opts.Version
+	res.Commit = commit
+	res.Hash = hash
+	res.Submodules = subs
+	res.Module = dstMod
+	res.Renames = opts.Renames
+	res.Replacements = replace
+	res.Vars = vars
+	res.Kept = kept
+	res.Overlays = overlays
+	res.Files = omitKept(files, kept)
+	for name, on := range features {
+		if on {
+			res.Features = append(res.Features, name)
+		}
+	}
+	sort.Strings(res.Features)
+	return res, nil
+}
+
+// listFiles returns the slash-separated names of the files in the tree
+// rooted at root, relative to it, in sorted order, except for those in
+// the .git directory.
+func listFiles(root string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// publish moves the module built in stage to its final directory.
+// If merge is set, final is not empty, and publish merges the module
+// into it with mergeTree, returning the files it kept; otherwise final
+// must be empty if it exists.
+func publish(stage, final string, existed, merge, replace bool) ([]string, error) {
+	if merge {
+		var kept []string
+		err := mergeTree(stage, final, "", replace, &kept)
+		sort.Strings(kept)
+		return kept, err
+	}
+	if !existed {
+		return nil, os.Rename(stage, final)
+	}
+	// The final directory may be the current one, say, and it holds
+	// the staging directory, so move the contents rather than replace it.
+	entries, err := os.ReadDir(stage)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if err := os.Rename(filepath.Join(stage, e.Name()), filepath.Join(final, e.Name())); err != nil {
+			emptyDir(final)
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// mergeTree moves the files in the directory src into the directory dst,
+// descending into the directories both have. A file or directory that dst
+// already has is replaced if replace is set and otherwise left in place
+// and appended, by its slash-separated path rel relative to the module
+// root, to kept; a .git directory in dst is always left in place. Since
+// dst held files of its own, a failure leaves what was moved in place.
+func mergeTree(src, dst, rel string, replace bool, kept *[]string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		from, to := filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())
+		name := path.Join(rel, e.Name())
+		info, err := os.Lstat(to)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			// Move it below.
+		case err != nil:
+			return err
+		case e.IsDir() && info.IsDir():
+			if rel == "" && e.Name() == ".git" {
+				*kept = append(*kept, name)
+				continue
+			}
+			if err := mergeTree(from, to, name, replace, kept); err != nil {
+				return err
+			}
+			continue
+		case rel == "" && e.Name() == ".git", !replace:
+			*kept = append(*kept, name)
+			continue
+		default:
+			if err := os.RemoveAll(to); err != nil {
+				return err
+			}
+		}
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// omitKept returns the names in files other than those in kept,
+// or in the directories it lists.
+func omitKept(files, kept []string) []string {
+	var list []string
+Files:
+	for _, name := range files {
+		for _, k := range kept {
+			if name == k || strings.HasPrefix(name, k+"/") {
+				continue Files
+			}
+		}
+		list = append(list, name)
+	}
+	return list
+}
+
+// A stagedError is an error from building a module in its staging
+// directory, which it reports by the module's final directory instead,
+// since the staging one is gone.
+type stagedError struct {
+	err          error
+	stage, final string
+}
+
+func (e *stagedError) Error() string {
+	return strings.ReplaceAll(e.err.Error(), e.stage, e.final)
+}
+
+func (e *stagedError) Unwrap() error { return e.err }
+
+// unstage returns a logging function like f, but reporting the files in
+// the staging directory stage by their final directory, as stagedError does.
+func unstage(f func(string, ...any), stage, final string) func(string, ...any) {
+	if f == nil {
+		return nil
+	}
+	return func(format string, args ...any) {
+		f("%s", strings.ReplaceAll(fmt.Sprintf(format, args...), stage, final))
+	}
+}
+
+// cachedClone reports whether the template cache holds an up-to-date
+// clone of srcMod at opts.Version: the commit that version names in the
+// repository at giturl, asked with env added to git's environment,
+// or the version itself, if it is a full commit hash.
+func (opts *Options) cachedClone(ctx context.Context, srcMod, giturl string, env []string) bool {
+	if opts.CacheDir == "" || opts.FullClone {
+		return false
+	}
+	cached := cachedCommit(opts.CacheDir, srcMod, opts.Version)
+	if cached == "" {
+		return false
+	}
+	if cached == opts.Version {
+		return true
+	}
+	opts.logf("checking whether the cached clone of %s is up to date", srcMod)
+	commit, err := remoteCommit(ctx, giturl, opts.Version, env)
+	if err != nil {
+		opts.logf("%v", err)
+		return false
+	}
+	return commit == cached
+}
+
+// A VerifyError reports that the new module in Dir, which is left in
+// place, failed to build or vet when checked by Options.Verify.
+type VerifyError struct {
+	Dir string
+	Err error
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("verifying %s: %v", e.Dir, e.Err)
+}
+
+func (e *VerifyError) Unwrap() error { return e.Err }
+
+// Rehome changes the module path of the existing module in dir to dstMod,
+// rewriting it in place the same way [Clone] rewrites a template.
+// The old module path is read from dir/go.mod, and dir's .git directory,
+// if any, is left alone.
+func Rehome(dir, dstMod string, opts RewriteOptions) (*Result, error) {
+	return RehomeContext(context.Background(), dir, dstMod, opts)
+}
+
+// RehomeContext is like Rehome, but stops, failing with ctx.Err(), once
+// ctx is done. The files already rewritten by then stay rewritten, and
+// the others are left as they were; none is left half-written.
+func RehomeContext(ctx context.Context, dir, dstMod string, opts RewriteOptions) (*Result, error) {
+	gomod := filepath.Join(dir, "go.mod")
+	data, err := os.ReadFile(gomod)
+	if err != nil {
+		return nil, err
+	}
+	srcMod := modfile.ModulePath(data)
+	if srcMod == "" {
+		return nil, fmt.Errorf("%s: missing module statement", gomod)
+	}
+	if err := opts.check(srcMod); err != nil {
+		return nil, err
+	}
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	rewritten, skipped, _, err := rewriteTree(ctx, root, srcMod, dstMod, &opts, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Replace) > 0 {
+		if err := replaceTree(root, opts.Replace, &opts, rewritten); err != nil {
+			return nil, err
+		}
+	}
+	return newResult(root, rewritten, skipped), nil
+}
+
+// RenameImports rewrites the import specs in the Go files under dir that
+// import oldPath or a package below it to import the corresponding path
+// below newPath instead. It renames no packages and changes no other files.
+// The Renames, Replace, RewriteStrings, RewriteComments, RewriteCodegen,
+// RewriteExt, and GoVersion options do not apply.
+func RenameImports(dir, oldPath, newPath string, opts RewriteOptions) (*Result, error) {
+	for _, p := range []string{oldPath, newPath} {
+		if err := module.CheckImportPath(p); err != nil {
+			return nil, err
+		}
+	}
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	opts.Renames = nil
+	opts.Replace = nil
+	rewritten, skipped, _, err := rewriteTree(context.Background(), root, oldPath, newPath, &opts, true)
+	if err != nil {
+		return nil, err
+	}
+	return newResult(root, rewritten, skipped), nil
+}
+
+// runHook runs the command args in dir, with the environment env, or if
+// env is nil gonew's own, reporting its output in the error if it fails.
+func runHook(ctx context.Context, dir string, args, env []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Env = env
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("post-processing: %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return nil
+}
+
+// moduleDirs returns the slash-separated directories, relative to root,
+// of the modules in the tree rooted there, those nested deepest first and
+// root itself, as ".", last. Like the go command, it ignores directories
+// named vendor or testdata or whose names begin with . or _.
+func moduleDirs(root string) ([]string, error) {
+	var dirs []string
+	err := filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if name := d.Name(); file != root && (name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() != "go.mod" {
+			return nil
+		}
+		rel, err := filepath.Rel(root, filepath.Dir(file))
+		if err != nil {
+			return err
+		}
+		dirs = append(dirs, filepath.ToSlash(rel))
+		return nil
+	})
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return depth(dirs[i]) > depth(dirs[j])
+	})
+	return dirs, err
+}
+
+// hookEnv returns the part of the environment env that a template's
+// post-generation commands get: what finding and running programs and
+// the go command need, but none of gonew's own settings, such as
+// GONEW_TOKEN, nor anything else, such as credentials for other services,
+// that a template has no business seeing.
+func hookEnv(env []string) []string {
+	keep := []string{}
+	for _, kv := range env {
+		k, _, _ := strings.Cut(kv, "=")
+		// The go command's variables, such as GOFLAGS and GO111MODULE,
+		// have no underscore after GO, unlike GONEW_TOKEN and, say,
+		// GOOGLE_APPLICATION_CREDENTIALS.
+		goVar := strings.HasPrefix(k, "GO") && !strings.Contains(k, "_")
+		if goVar || hookEnvVars[strings.ToUpper(k)] || strings.HasPrefix(k, "CGO_") || strings.HasPrefix(k, "LC_") {
+			keep = append(keep, kv)
+		}
+	}
+	return keep
+}
+
+// hookEnvVars lists the environment variables, besides those for the go
+// command and the locale, that hookEnv passes on.
+var hookEnvVars = map[string]bool{
+	"PATH": true, "HOME": true, "USER": true, "LOGNAME": true, "SHELL": true,
+	"LANG": true, "TERM": true, "TMPDIR": true, "TEMP": true, "TMP": true,
+	"XDG_CACHE_HOME": true, "XDG_CONFIG_HOME": true,
+	"SYSTEMROOT": true, "COMSPEC": true, "PATHEXT": true, "USERPROFILE": true,
+	"LOCALAPPDATA": true, "APPDATA": true, "PROGRAMFILES": true, "WINDIR": true,
+	"CC": true, "CXX": true, "PKG_CONFIG_PATH": true,
+}
+
+// hoistSubdir replaces the contents of dir with those of its
+// subdirectory sub, which is given in slash-separated form.
+func hoistSubdir(dir, sub string) error {
+	info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(sub)))
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("template has no directory %s", sub)
+	}
+	// Move everything aside, beside dir so that renaming works,
+	// then move the subdirectory's contents back.
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), "."+filepath.Base(dir)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.Rename(filepath.Join(dir, e.Name()), filepath.Join(tmp, e.Name())); err != nil {
+			return err
+		}
+	}
+	src := filepath.Join(tmp, filepath.FromSlash(sub))
+	entries, err = os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.Rename(filepath.Join(src, e.Name()), filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emptyDir removes everything in dir, leaving dir itself in place.
+func emptyDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkModule reports an error if the template in dir has no go.mod file,
+// at its root or, as in a workspace, in any subdirectory, since the clone
+// would then not be a Go module at all. If the root go.mod declares a
+// module path other than srcMod, none of the imports in the template
+// will match srcMod, so checkModule warns that they will not be rewritten.
+func checkModule(dir, srcMod string, warnf func(string, ...any)) error {
+	data, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err == nil {
+		if mod := modfile.ModulePath(data); mod != srcMod {
+			warnf("template go.mod declares module %s, not %s; its imports will not be rewritten", mod, srcMod)
+		}
+		return nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	found := false
+	err = filepath.WalkDir(dir, func(src string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && d.Name() == "go.mod" {
+			found = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("source repo has no go.mod at %s", dir)
+	}
+	return nil
+}
+
+// checkDest reports an error if dir exists and is anything other than
+// a directory, or a directory that is not empty, unless merge is set or
+// it holds only a .git directory. It reports whether dir is a directory
+// that is not empty.
+func checkDest(dir string, merge bool) (bool, error) {
+	info, err := os.Stat(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !info.IsDir() {
+		return false, fmt.Errorf("destination %s exists and is not a directory", dir)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	if len(entries) == 0 {
+		return false, nil
+	}
+	if len(entries) == 1 && entries[0].Name() == ".git" {
+		return true, nil // a fresh clone, to merge into to keep its .git
+	}
+	if !merge {
+		e := &NotEmptyError{Dir: dir}
+		for _, d := range entries {
+			e.Entries = append(e.Entries, d.Name())
+		}
+		return false, e
+	}
+	return true, nil
+}
+
+// A NotEmptyError reports that [Clone] did not create a module in Dir
+// because Dir is not empty, and neither Options.Force nor
+// Options.AllowExisting is set.
+type NotEmptyError struct {
+	Dir     string
+	Entries []string // names of the files and directories in Dir, in sorted order
+}
+
+func (e *NotEmptyError) Error() string {
+	const max = 3
+	list := strings.Join(e.Entries, ", ")
+	if len(e.Entries) > max {
+		list = fmt.Sprintf("%s, and %d more", strings.Join(e.Entries[:max], ", "), len(e.Entries)-max)
+	}
+	return fmt.Sprintf("destination %s is not empty: it has %s", e.Dir, list)
+}
+
+// touchTree sets the access and modification times of every file and
+// directory in the tree rooted at root to t. Symbolic links are left alone,
+// since os.Chtimes would follow them.
+func touchTree(root string, t time.Time) error {
+	return filepath.WalkDir(root, func(src string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		return os.Chtimes(src, t, t)
+	})
+}
@@ -0,0 +1,288 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTree writes files, a map from slash-separated paths relative to
+// root to their contents, into the directory root.
+func writeTree(t testing.TB, root string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		file := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkTree checks that the files in root named by want, as for
+// writeTree, have the given contents.
+func checkTree(t *testing.T, root string, want map[string]string) {
+	t.Helper()
+	for name, data := range want {
+		got, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(got) != data {
+			t.Errorf("%s:\ngot  %q\nwant %q", name, got, data)
+		}
+	}
+}
+
+// cloneLocal runs Clone with opts, whose SrcRepo is a local template,
+// into a new temporary directory, and returns the new module's directory.
+func cloneLocal(t *testing.T, opts Options) string {
+	t.Helper()
+	if opts.Dir == "" {
+		opts.Dir = filepath.Join(t.TempDir(), "out")
+	}
+	if _, err := CloneContext(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	return opts.Dir
+}
+
+// gitRepo makes a git repository in dir with one commit for each of
+// commits, in order, each writing the files given, as for writeTree,
+// on the branch main. It returns the hashes of the commits.
+func gitRepo(t *testing.T, dir string, commits ...map[string]string) []string {
+	t.Helper()
+	git(t, dir, "init", "-q", "-b", "main")
+	var hashes []string
+	for i, files := range commits {
+		writeTree(t, dir, files)
+		git(t, dir, "add", "-A")
+		git(t, dir, "commit", "-q", "-m", fmt.Sprintf("commit %d", i))
+		hashes = append(hashes, strings.TrimSpace(git(t, dir, "rev-parse", "HEAD")))
+	}
+	return hashes
+}
+
+// git runs git with args in dir, without the user's configuration,
+// failing the test if it fails, and returns its output.
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_CONFIG_GLOBAL="+os.DevNull, "GIT_CONFIG_NOSYSTEM=1",
+		"GIT_AUTHOR_NAME=Gonew Test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=Gonew Test", "GIT_COMMITTER_EMAIL=test@example.com")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, stderrOf(err))
+	}
+	return string(out)
+}
+
+// stderrOf returns the standard error of the failed command err is from.
+func stderrOf(err error) []byte {
+	if ee, ok := err.(*exec.ExitError); ok {
+		return ee.Stderr
+	}
+	return nil
+}
+
+// checkModTimes checks that every file and directory in root
+// was last modified at want.
+func checkModTimes(t *testing.T, root string, want time.Time) {
+	t.Helper()
+	err := filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.ModTime().Equal(want) {
+			t.Errorf("%s: modified at %v, want %v", file, info.ModTime(), want)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModTime(t *testing.T) {
+	tmpl := t.TempDir()
+	writeTree(t, tmpl, map[string]string{
+		"go.mod":            "module github.com/example/hello\n",
+		"hello.go":          "package hello\n",
+		"cmd/hello/main.go": "package main\n",
+	})
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	dir := cloneLocal(t, Options{SrcRepo: tmpl, DstMod: "example.com/hi", ModTime: mtime})
+	checkModTimes(t, dir, mtime)
+}
+
+func TestTouchModTime(t *testing.T) {
+	tmpl := t.TempDir()
+	gitRepo(t, tmpl, map[string]string{
+		"go.mod":   "module github.com/example/hello\n",
+		"hello.go": "package hello\n",
+	})
+	sec, err := strconv.ParseInt(strings.TrimSpace(git(t, tmpl, "log", "-1", "--format=%ct")), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := cloneLocal(t, Options{SrcRepo: tmpl, DstMod: "example.com/hi", TouchModTime: true})
+	checkModTimes(t, dir, time.Unix(sec, 0))
+}
+
+func TestRehome(t *testing.T) {
+	dir := t.TempDir()
+	gitRepo(t, dir, map[string]string{
+		"go.mod":            "module github.com/example/hello\n\ngo 1.23\n",
+		"hello.go":          "package hello\n\nimport _ \"github.com/example/hello/internal/db\"\n",
+		"internal/db/db.go": "package db\n",
+		"cmd/hello/main.go": "package main\n\nimport \"github.com/example/hello\"\n\nvar _ = hello.X\n",
+	})
+	head := git(t, dir, "rev-parse", "HEAD")
+	res, err := RehomeContext(context.Background(), dir, "example.com/myprog", RewriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTree(t, dir, map[string]string{
+		"go.mod":            "module example.com/myprog\n\ngo 1.23\n",
+		"hello.go":          "package myprog\n\nimport _ \"example.com/myprog/internal/db\"\n",
+		"internal/db/db.go": "package db\n",
+		"cmd/hello/main.go": "package main\n\nimport hello \"example.com/myprog\"\n\nvar _ = hello.X\n",
+	})
+	if want := []string{"cmd/hello/main.go", "go.mod", "hello.go"}; !slices.Equal(res.Rewritten, want) {
+		t.Errorf("Rewritten = %v, want %v", res.Rewritten, want)
+	}
+	// The history is kept, with the rewrite left for the user to commit.
+	if got := git(t, dir, "rev-parse", "HEAD"); got != head {
+		t.Errorf("HEAD = %s, want %s", got, head)
+	}
+	if got := git(t, dir, "status", "--porcelain"); got != " M cmd/hello/main.go\n M go.mod\n M hello.go\n" {
+		t.Errorf("git status:\n%s\nwant the three rewritten files modified", got)
+	}
+
+	if _, err := RehomeContext(context.Background(), t.TempDir(), "example.com/x", RewriteOptions{}); err == nil {
+		t.Error("rehoming a directory without a go.mod succeeded")
+	}
+}
+
+func TestRenameImports(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"go.mod": "module example.com/app\n\nrequire github.com/a/b v1.0.0\n",
+		"main.go": `package main
+
+import (
+	"github.com/a/b"
+	"github.com/a/b/sub/pkg"
+	"github.com/a/bc"
+	"github.com/a"
+)
+`,
+		"b.go":      "package b\n\nimport _ \"github.com/a/b\"\n",
+		"README.md": "github.com/a/b\n",
+	})
+	res, err := RenameImports(dir, "github.com/a/b", "x.com/c", RewriteOptions{RewriteExt: []string{"md"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTree(t, dir, map[string]string{
+		"go.mod": "module example.com/app\n\nrequire github.com/a/b v1.0.0\n",
+		"main.go": `package main
+
+import (
+	b "x.com/c"
+	"x.com/c/sub/pkg"
+	"github.com/a/bc"
+	"github.com/a"
+)
+`,
+		"b.go":      "package b\n\nimport _ \"x.com/c\"\n",
+		"README.md": "github.com/a/b\n",
+	})
+	if want := []string{"b.go", "main.go"}; !slices.Equal(res.Rewritten, want) {
+		t.Errorf("Rewritten = %v, want %v", res.Rewritten, want)
+	}
+}
+
+func TestCheckDest(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"full/a":          "",
+		"full/b":          "",
+		"full/c":          "",
+		"full/d":          "",
+		"clone/.git/HEAD": "ref: refs/heads/main\n",
+		"file":            "",
+	})
+	if err := os.Mkdir(filepath.Join(root, "empty"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		dir         string
+		merge, want bool
+		err         string
+	}{
+		{dir: "missing"},
+		{dir: "empty"},
+		{dir: "clone", want: true},
+		{dir: "full", err: "is not empty: it has a, b, c, and 1 more"},
+		{dir: "full", merge: true, want: true},
+		{dir: "file", err: "exists and is not a directory"},
+		{dir: "file", merge: true, err: "exists and is not a directory"},
+	}
+	for _, tt := range tests {
+		got, err := checkDest(filepath.Join(root, tt.dir), tt.merge)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("checkDest(%s, %v) = %v, %v, want error %q", tt.dir, tt.merge, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("checkDest(%s, %v) = %v, %v, want %v", tt.dir, tt.merge, got, err, tt.want)
+		}
+	}
+
+	// Clone leaves a destination that is not empty as it was.
+	tmpl := t.TempDir()
+	writeTree(t, tmpl, map[string]string{"go.mod": "module github.com/example/hello\n"})
+	_, err := CloneContext(context.Background(), Options{SrcRepo: tmpl, DstMod: "example.com/hi", Dir: filepath.Join(root, "full")})
+	var nerr *NotEmptyError
+	if !errors.As(err, &nerr) || !slices.Equal(nerr.Entries, []string{"a", "b", "c", "d"}) {
+		t.Errorf("cloning into a directory that is not empty: %v, want a *NotEmptyError", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(root, "full")); len(entries) != 4 {
+		t.Errorf("after failing, the destination has %d entries, want 4", len(entries))
+	}
+}
+
+func TestForceAllowExisting(t *testing.T) {
+	tmpl := t.TempDir()
+	writeTree(t, tmpl, map[string]string{"go.mod": "module github.com/example/hello\n"})
+	opts := Options{SrcRepo: tmpl, DstMod: "example.com/x", Dir: t.TempDir(), Force: true, AllowExisting: true}
+	_, err := CloneContext(context.Background(), opts)
+	if err == nil || err.Error() != "cannot set both Force and AllowExisting" {
+		t.Errorf("Clone with Force and AllowExisting: %v, want cannot set both Force and AllowExisting", err)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFile is the name of the file, in the root of a template, listing
+// in .gitignore syntax the files never to copy into a new module, such as
+// the template's own CI configuration. It is itself removed.
+const IgnoreFile = ".gonewignore"
+
+// An ignorePattern is one line of an IgnoreFile, or an Options.Exclude entry.
+type ignorePattern struct {
+	glob    string // for matchGlob
+	negate  bool   // the line began with !, re-including what it matches
+	dirOnly bool   // the line ended in /, matching only directories
+}
+
+// parseIgnore parses patterns in .gitignore syntax, one per line.
+// Blank lines and lines beginning with # are ignored; a leading backslash
+// escapes a # or !. A pattern with a slash other than at its end is
+// relative to the root; any other pattern matches at any depth.
+func parseIgnore(data []byte) []ignorePattern {
+	var patterns []ignorePattern
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var p ignorePattern
+		if p.negate = strings.HasPrefix(line, "!"); p.negate {
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, `\`)
+		if p.dirOnly = strings.HasSuffix(line, "/"); p.dirOnly {
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		if strings.Contains(line, "/") {
+			line = strings.TrimPrefix(line, "/")
+		} else {
+			line = "**/" + line
+		}
+		if strings.HasSuffix(line, "/**") {
+			// Everything inside, but not the directory itself,
+			// so that a later pattern can re-include some of it.
+			line += "/*"
+		}
+		p.glob = line
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// ignored reports whether patterns exclude the file or directory rel,
+// slash-separated and relative to the root. As in .gitignore,
+// the last matching pattern decides.
+func ignored(patterns []ignorePattern, rel string, dir bool) bool {
+	ignore := false
+	for _, p := range patterns {
+		if p.dirOnly && !dir {
+			continue
+		}
+		if matchGlob(p.glob, rel) {
+			ignore = !p.negate
+		}
+	}
+	return ignore
+}
+
+// applyIgnore removes from the tree rooted at root its IgnoreFile, and every
+// file and directory matched by the patterns in it or in exclude, which
+// come after and so take precedence. As in git, a file in an excluded
+// directory cannot be re-included by a negated pattern.
+func applyIgnore(root string, exclude []string) error {
+	file := filepath.Join(root, IgnoreFile)
+	data, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	patterns := parseIgnore(data)
+	patterns = append(patterns, parseIgnore([]byte(strings.Join(exclude, "\n")))...)
+	if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if len(patterns) == 0 {
+		return nil
+	}
+	return removeMatching(root, func(rel string, dir bool) bool {
+		return ignored(patterns, rel, dir)
+	})
+}
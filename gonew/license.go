@@ -0,0 +1,300 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+//go:embed license-mit.txt
+var mitLicense string
+
+//go:embed license-apache2.txt
+var apacheLicense string
+
+// licenseFiles lists the names of the files, in the root of a template,
+// that may hold its license.
+var licenseFiles = []string{"LICENSE", "LICENSE.txt", "LICENSE.md", "LICENCE", "COPYING"}
+
+// codeownersDirs lists the directories, relative to the root of
+// a template, in which GitHub and GitLab look for a CODEOWNERS file.
+var codeownersDirs = []string{".", ".github", ".gitlab", "docs"}
+
+// headerLines is the number of lines at the top of a file that
+// rewriteOwnership treats as its header.
+const headerLines = 30
+
+// An ownership holds the Options fields saying who owns the new module.
+type ownership struct {
+	author, org string
+	license     string // "", or a name checkLicense accepts
+	year        int
+}
+
+// set reports whether o asks for any rewriting at all.
+func (o ownership) set() bool {
+	return o.author != "" || o.org != "" || o.license != "" || o.year != 0
+}
+
+// holder returns the copyright holder o names, the organization if there
+// is one, or else the author. It is "" if o names neither.
+func (o ownership) holder() string {
+	if o.org != "" {
+		return o.org
+	}
+	return o.author
+}
+
+// checkLicense returns the canonical name of the license named name,
+// which may be "", for no change, none, mit, or apache2, in any case,
+// or the SPDX identifiers MIT and Apache-2.0.
+func checkLicense(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return "", nil
+	case "none":
+		return "none", nil
+	case "mit":
+		return "mit", nil
+	case "apache2", "apache-2.0":
+		return "apache2", nil
+	}
+	return "", fmt.Errorf("unknown license %q: want mit, apache2, or none", name)
+}
+
+// spdx returns the SPDX identifier of the license, or "" for none.
+func (o ownership) spdx() string {
+	switch o.license {
+	case "mit":
+		return "MIT"
+	case "apache2":
+		return "Apache-2.0"
+	}
+	return ""
+}
+
+var (
+	// copyrightRE matches a copyright line, after any comment marker,
+	// capturing the marker, any (c), the years, the holder, any rights
+	// statement, and any comment terminator.
+	copyrightRE = regexp.MustCompile(`^(\s*(?:(?://|#|\*|/\*|--|;|<!--)\s*)?)Copyright((?:\s+(?:\([cC]\)|©))?)\s+(\d{4}(?:\s*[-–,]\s*\d{4})*),?\s+(.*?)(\s*\.?\s*All [Rr]ights [Rr]eserved\.?)?(\s*(?:\*/|-->))?\s*$`)
+
+	spdxRE     = regexp.MustCompile(`SPDX-License-Identifier:\s*\S+`)
+	governedRE = regexp.MustCompile(`governed by an? [A-Za-z0-9.]+-style`)
+)
+
+// rewriteOwnership makes the module rooted at root belong to the owner o
+// describes instead of the template's author, adding the files it changes to
+// rewritten. With o.license set, it replaces the template's license files
+// with a LICENSE file for that license, or with none at all, and updates
+// the SPDX-License-Identifier lines and the "governed by a BSD-style
+// license" sentences in the headers of the other files to match. It gives
+// the copyright lines, in the license files and in those headers, the new
+// holder and year, leaving alone, if the template's license names its
+// copyright holder, the lines naming anyone else, such as the authors of
+// third-party code. And it lists just the new owner in CODEOWNERS,
+// AUTHORS, and CONTRIBUTORS files.
+func rewriteOwnership(root string, o ownership, opts *RewriteOptions, rewritten map[string]bool) error {
+	var old string // the template's copyright holder, if known
+	var existing []string
+	for _, name := range licenseFiles {
+		data, err := os.ReadFile(filepath.Join(root, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		existing = append(existing, name)
+		if old == "" {
+			for _, line := range strings.Split(string(data), "\n") {
+				if m := copyrightRE.FindStringSubmatch(strings.TrimSuffix(line, "\r")); m != nil {
+					old = strings.TrimSpace(m[4])
+					break
+				}
+			}
+		}
+	}
+	if old != "" {
+		opts.logf("the template's copyright holder is %s", old)
+	}
+	if o.license != "" {
+		for _, name := range existing {
+			opts.logf("removing %s", name)
+			if err := os.Remove(filepath.Join(root, name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	owner := codeOwner(o)
+	err := walkText(root, nil, opts, rewritten, func(rel string, data []byte) []byte {
+		dir, base := path.Split(rel)
+		dir = strings.TrimSuffix(dir, "/")
+		switch {
+		case dir == "" && slices.Contains(licenseFiles, base):
+			return rewriteLines(data, -1, func(line string) string {
+				return rewriteCopyright(line, o, old, true)
+			})
+		case base == "CODEOWNERS" && slices.Contains(codeownersDirs, path.Clean(dir+"/.")):
+			if owner == "" {
+				if o.holder() != "" {
+					opts.warnf("%s: leaving the owners alone: naming one takes an Org, or an Author that is a @handle or an email address", rel)
+				}
+				return data
+			}
+			return rewriteCodeowners(data, owner)
+		case dir == "" && (base == "AUTHORS" || base == "CONTRIBUTORS"):
+			name := o.holder()
+			if base == "CONTRIBUTORS" && o.author != "" {
+				name = o.author
+			}
+			if name == "" {
+				return data
+			}
+			return rewriteEntries(data, name)
+		}
+		return rewriteLines(data, headerLines, func(line string) string {
+			line = rewriteCopyright(line, o, old, false)
+			if spdx := o.spdx(); spdx != "" {
+				line = spdxRE.ReplaceAllLiteralString(line, "SPDX-License-Identifier: "+spdx)
+				style := map[string]string{"mit": "an MIT-style", "apache2": "an Apache-style"}[o.license]
+				line = governedRE.ReplaceAllLiteralString(line, "governed by "+style)
+			}
+			return line
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	var text string
+	switch o.license {
+	case "mit":
+		text = strings.NewReplacer("[year]", strconv.Itoa(o.year), "[fullname]", o.holder()).Replace(mitLicense)
+	case "apache2":
+		text = apacheLicense
+	default:
+		return nil
+	}
+	opts.logf("writing LICENSE")
+	if err := os.WriteFile(filepath.Join(root, "LICENSE"), []byte(text), 0666); err != nil {
+		return err
+	}
+	rewritten["LICENSE"] = true
+	return nil
+}
+
+// rewriteLines returns data with each of its first n lines, or all of
+// them if n < 0, replaced by what edit returns for it, without its
+// line ending.
+func rewriteLines(data []byte, n int, edit func(string) string) []byte {
+	var buf bytes.Buffer
+	for i := 0; len(data) > 0; i++ {
+		if n >= 0 && i >= n {
+			buf.Write(data)
+			break
+		}
+		line, rest, nl := bytes.Cut(data, []byte("\n"))
+		data = rest
+		cr := bytes.HasSuffix(line, []byte("\r"))
+		buf.WriteString(edit(string(bytes.TrimSuffix(line, []byte("\r")))))
+		if cr {
+			buf.WriteByte('\r')
+		}
+		if nl {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+// rewriteCopyright returns line with the year and holder of the copyright
+// notice it holds, if any, changed as o says. Only a notice naming old,
+// if old is not "", is changed, and unless bare is set, only one in a
+// comment.
+func rewriteCopyright(line string, o ownership, old string, bare bool) string {
+	m := copyrightRE.FindStringSubmatch(line)
+	if m == nil {
+		return line
+	}
+	prefix, c, holder, rights, end := m[1], m[2], strings.TrimSpace(m[4]), m[5], m[6]
+	if !bare && strings.TrimSpace(prefix) == "" {
+		return line
+	}
+	if old != "" && !strings.EqualFold(holder, old) {
+		return line
+	}
+	if h := o.holder(); h != "" {
+		holder = h
+	}
+	if strings.HasSuffix(holder, ".") {
+		rights = strings.Replace(rights, ".", "", 1)
+		if rights != "" && !strings.HasPrefix(rights, " ") {
+			rights = " " + rights
+		}
+	}
+	return fmt.Sprintf("%sCopyright%s %d %s%s%s", prefix, c, o.year, holder, rights, end)
+}
+
+// codeOwner returns the CODEOWNERS owner for o: its organization, as a
+// @handle, or else its author, if that is a @handle or an email address.
+// It returns "" if o gives no owner in either form.
+func codeOwner(o ownership) string {
+	if o.org != "" && !strings.ContainsAny(o.org, " \t") {
+		return "@" + strings.TrimPrefix(o.org, "@")
+	}
+	if a := o.author; a != "" && !strings.ContainsAny(a, " \t") && strings.Contains(a, "@") {
+		return a
+	}
+	return ""
+}
+
+// rewriteCodeowners returns the CODEOWNERS file data with every rule
+// giving owner as the only owner of its files.
+func rewriteCodeowners(data []byte, owner string) []byte {
+	return rewriteLines(data, -1, func(line string) string {
+		t := strings.TrimSpace(line)
+		if t == "" || strings.HasPrefix(t, "#") || strings.HasPrefix(t, "[") || strings.HasPrefix(t, "^[") {
+			return line
+		}
+		return strings.Fields(t)[0] + " " + owner
+	})
+}
+
+// rewriteEntries returns the AUTHORS or CONTRIBUTORS file data with its
+// comments and blank lines kept but its entries replaced by name.
+func rewriteEntries(data []byte, name string) []byte {
+	var buf bytes.Buffer
+	done := false
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		t := strings.TrimSpace(line)
+		switch {
+		case t == "" || strings.HasPrefix(t, "#"):
+			buf.WriteString(line)
+		case !done:
+			buf.WriteString(name + "\n")
+			done = true
+		}
+	}
+	if !done {
+		if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(name + "\n")
+	}
+	return buf.Bytes()
+}
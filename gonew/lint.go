@@ -0,0 +1,324 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/modfile"
+)
+
+// LintModule is the module path [Lint] gives its trial module
+// when Options.DstMod is empty.
+const LintModule = "example.com/gonew/lint"
+
+// A LintProblem is a mistake [Lint] found in a template.
+type LintProblem struct {
+	File    string `json:"file,omitempty"` // slash-separated and relative to the template root, if the problem is in one file
+	Line    int    `json:"line,omitempty"` // line of File, if known
+	Message string `json:"message"`
+}
+
+func (p LintProblem) String() string {
+	switch {
+	case p.File == "":
+		return p.Message
+	case p.Line == 0:
+		return p.File + ": " + p.Message
+	}
+	return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+}
+
+// Lint checks the template opts.SrcRepo, fetched as [Clone] would fetch it,
+// for the mistakes its author would want to hear about before publishing
+// it: a go.mod or Go file that does not parse, a variable its manifest
+// declares but nothing uses, or a placeholder for one it does not declare,
+// an absolute path that exists only on the author's machine, a leftover
+// git repository or a file that looks like a secret, such as a private
+// key or an access token. It then creates a trial module from the template
+// in a temporary directory, with module path opts.DstMod, by default
+// LintModule, and the variables' defaults, and builds and vets it, as for
+// opts.Verify. A Go file holding placeholders is left for the trial module
+// to show whether it parses once they are substituted.
+//
+// Lint returns the problems it found, sorted by file, and an error only if
+// it could not check the template at all. The Options fields that only
+// make sense when keeping the new module, such as Dir, GitInit, Record, and
+// Exec, are ignored, and hooks are not run; Tidy runs "go mod tidy" in the
+// trial module before building it, for a template without a go.sum.
+func Lint(ctx context.Context, opts Options) ([]LintProblem, error) {
+	if opts.DstMod == "" {
+		opts.DstMod = LintModule
+	}
+	opts.Record = false
+	opts.Keep = false
+	opts.KeepGit, opts.RenameOrigin = false, false
+	opts.GitInit, opts.GitCommit, opts.SetOrigin, opts.Push = false, false, false, false
+	opts.Gitignore, opts.GitignoreMerge = false, false
+	opts.Format, opts.RunHooks = false, false
+	opts.Verify = true
+	opts.Exec = nil
+	opts.Ask = nil
+	opts.Force, opts.AllowExisting = false, false
+	opts.ModTime, opts.TouchModTime = time.Time{}, false
+
+	tmp, err := os.MkdirTemp("", "gonew-lint-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	opts.Dir = filepath.Join(tmp, path.Base(opts.DstMod))
+
+	var problems []LintProblem
+	inspected := false
+	opts.inspect = func(dir string, m *manifest) error {
+		inspected = true
+		var err error
+		problems, err = lintTree(dir, m, &opts)
+		return err
+	}
+	_, err = CloneContext(ctx, opts)
+	var verr *VerifyError
+	switch {
+	case errors.As(err, &verr):
+		problems = append(problems, LintProblem{Message: fmt.Sprintf("trial module %s does not build: %s", opts.DstMod, strings.TrimSpace(verr.Err.Error()))})
+	case err != nil && inspected && ctx.Err() == nil:
+		problems = append(problems, LintProblem{Message: fmt.Sprintf("creating trial module %s: %v", opts.DstMod, err)})
+	case err != nil:
+		return nil, err
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		p, q := problems[i], problems[j]
+		if p.File != q.File {
+			return p.File < q.File
+		}
+		return p.Line < q.Line
+	})
+	return problems, nil
+}
+
+var (
+	// homePathRE matches an absolute path within a user's home directory.
+	homePathRE = regexp.MustCompile(`(?:^|[^A-Za-z0-9_.~/-])((?:/home|/Users)/[A-Za-z0-9_.-]+/|[A-Za-z]:\\Users\\[A-Za-z0-9_. -]+\\)`)
+
+	// secretRE matches the forms of common credentials.
+	secretRE = regexp.MustCompile(`-----BEGIN (?:[A-Z]+ )*PRIVATE KEY-----|\bAKIA[0-9A-Z]{16}\b|\bgh[pousr]_[A-Za-z0-9]{36,}|\bglpat-[A-Za-z0-9_-]{20,}|\bxox[abprs]-[A-Za-z0-9-]{10,}`)
+)
+
+// secretFiles lists the names of files that usually hold credentials,
+// and secretExts the extensions of those that usually hold keys.
+var (
+	secretFiles = []string{".env", ".netrc", ".pgpass", ".npmrc", ".pypirc", "credentials.json", "id_rsa", "id_dsa", "id_ecdsa", "id_ed25519"}
+	secretExts  = []string{"pem", "key", "p12", "pfx", "jks", "keystore"}
+)
+
+// lintTree returns the problems with the template fetched into dir, whose
+// manifest, which may be nil, is m.
+func lintTree(dir string, m *manifest, opts *Options) ([]LintProblem, error) {
+	var problems []LintProblem
+	report := func(file string, line int, format string, args ...any) {
+		problems = append(problems, LintProblem{File: file, Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+
+	used := make(map[string]bool)    // variables used by placeholders or conditions
+	unknown := make(map[string]bool) // file and name of each placeholder reported as naming no variable
+	declared := make(map[string]bool)
+	for name := range substVars("", nil) {
+		declared[name] = true
+	}
+	var render []string
+	if m != nil {
+		for _, v := range m.Vars {
+			declared[v.Name] = true
+		}
+		for _, f := range m.Files {
+			for name := range f.When {
+				used[name] = true
+			}
+		}
+		for _, r := range m.Replace {
+			for _, sm := range placeholderRE.FindAllStringSubmatch(r.New, -1) {
+				used[sm[1]] = true
+			}
+		}
+		render = m.Render
+	}
+
+	fset := token.NewFileSet()
+	err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.Name() == ".git" {
+			if rel != ".git" {
+				report(rel, 0, "leftover git repository; remove it, or make it a submodule")
+			}
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if file != dir && opts.skipDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			target, err := os.Readlink(file)
+			if err != nil {
+				return err
+			}
+			switch {
+			case filepath.IsAbs(target):
+				report(rel, 0, "symbolic link to the absolute path %s", target)
+			case !filepath.IsLocal(filepath.Join(filepath.Dir(filepath.FromSlash(rel)), target)):
+				report(rel, 0, "symbolic link to %s, outside the template", target)
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if isSecretFile(d.Name()) {
+			report(rel, 0, "file usually holding credentials; remove it and add it to .gitignore")
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if opts.MaxFileSize > 0 && info.Size() > opts.MaxFileSize {
+			return nil
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if bytes.IndexByte(data, 0) >= 0 {
+			return nil // binary
+		}
+
+		placeholders := placeholderRE.FindAllSubmatchIndex(data, -1)
+		rendered := opts.Subst || matchAny(render, rel)
+		for _, sm := range placeholders {
+			name := string(data[sm[2]:sm[3]])
+			used[name] = true
+			if rendered && !declared[name] && !unknown[rel+"\x00"+name] {
+				unknown[rel+"\x00"+name] = true
+				report(rel, lineOf(data, sm[0]), "placeholder {{.%s}} names no variable the manifest declares", name)
+			}
+		}
+		if d.Name() != "go.mod" { // whose replace directives are checked below
+			for _, sm := range homePathRE.FindAllSubmatchIndex(data, -1) {
+				report(rel, lineOf(data, sm[2]), "absolute path %s exists only on its author's machine", data[sm[2]:sm[3]])
+			}
+		}
+		for _, sm := range secretRE.FindAllIndex(data, -1) {
+			report(rel, lineOf(data, sm[0]), "what looks like a private key or access token")
+		}
+
+		switch {
+		case d.Name() == "go.mod":
+			f, err := modfile.Parse(rel, data, nil)
+			if err != nil {
+				report(rel, 0, "does not parse: %v", err)
+				return nil
+			}
+			for _, r := range f.Replace {
+				if r.New.Version != "" {
+					continue
+				}
+				target := filepath.FromSlash(r.New.Path)
+				switch {
+				case filepath.IsAbs(target):
+					report(rel, r.Syntax.Start.Line, "replace directive points to the absolute path %s", r.New.Path)
+				case !filepath.IsLocal(filepath.Join(filepath.Dir(filepath.FromSlash(rel)), target)):
+					report(rel, r.Syntax.Start.Line, "replace directive points to %s, outside the template", r.New.Path)
+				}
+			}
+		case strings.HasSuffix(d.Name(), ".go") && len(placeholders) == 0 && !inTestdata(rel):
+			if _, err := parser.ParseFile(fset, rel, data, parser.SkipObjectResolution); err != nil {
+				line := 0
+				var list scanner.ErrorList
+				if errors.As(err, &list) && len(list) > 0 {
+					line, err = list[0].Pos.Line, errors.New(list[0].Msg)
+				}
+				report(rel, line, "does not parse: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if m != nil {
+		file := manifestName(dir)
+		for _, v := range m.Vars {
+			if !used[v.Name] {
+				report(file, 0, "variable %s is declared but no placeholder or condition uses it", v.Name)
+			}
+		}
+	}
+	return problems, nil
+}
+
+// isSecretFile reports whether a file named name usually holds
+// credentials, leaving out examples such as .env.example.
+func isSecretFile(name string) bool {
+	if slices.Contains(secretFiles, name) {
+		return true
+	}
+	if rest, ok := strings.CutPrefix(name, ".env."); ok {
+		switch rest {
+		case "example", "sample", "template", "dist":
+			return false
+		}
+		return true
+	}
+	return matchExt(name, secretExts)
+}
+
+// inTestdata reports whether the slash-separated path rel lies in
+// a testdata directory, which the go command ignores.
+func inTestdata(rel string) bool {
+	return slices.Contains(strings.Split(path.Dir(rel), "/"), "testdata")
+}
+
+// lineOf returns the line number of the byte offset off in data.
+func lineOf(data []byte, off int) int {
+	return bytes.Count(data[:off], []byte("\n")) + 1
+}
+
+// manifestName returns the slash-separated path of the manifest of the
+// template in dir, relative to it.
+func manifestName(dir string) string {
+	for _, name := range manifestFiles {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return filepath.ToSlash(name)
+		}
+	}
+	return ""
+}
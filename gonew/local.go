@@ -0,0 +1,63 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// IsLocal reports whether src names a template in a local directory,
+// or in a local zip file or tarball, rather than a repository: a path
+// beginning with "." or "/", or a file:// URL.
+func IsLocal(src string) bool {
+	return strings.HasPrefix(src, ".") || strings.HasPrefix(src, "/") ||
+		strings.HasPrefix(src, "file://") || filepath.IsAbs(src)
+}
+
+// localDir returns the directory named by the local template src.
+// The path of a file:// URL for a Windows drive, as in file:///C:/templates,
+// begins with a slash before its volume name, which is dropped.
+func localDir(src string) string {
+	p, ok := strings.CutPrefix(src, "file://")
+	if ok && strings.HasPrefix(p, "/") && filepath.VolumeName(filepath.FromSlash(p[1:])) != "" {
+		p = p[1:]
+	}
+	return filepath.FromSlash(p)
+}
+
+// LocalModulePath returns the module path declared by the go.mod file
+// of the local template src.
+func LocalModulePath(src string) (string, error) {
+	if isArchive(src) {
+		return archiveModulePath(localDir(src))
+	}
+	gomod := filepath.Join(localDir(src), "go.mod")
+	data, err := os.ReadFile(gomod)
+	if err != nil {
+		return "", err
+	}
+	mod := modfile.ModulePath(data)
+	if mod == "" {
+		return "", fmt.Errorf("%s: missing module statement", gomod)
+	}
+	return mod, nil
+}
+
+// copyLocal copies the local template src into dst.
+func copyLocal(src, dst string) error {
+	dir, err := filepath.Abs(localDir(src))
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(dir, dst); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("destination %s is inside template %s", dst, dir)
+	}
+	return copyTree(dir, dst)
+}
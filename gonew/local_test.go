@@ -0,0 +1,89 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fileURL returns the file:// URL of the absolute path dir.
+func fileURL(dir string) string {
+	p := filepath.ToSlash(dir)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p // a Windows drive, as in file:///C:/templates
+	}
+	return "file://" + p
+}
+
+func TestIsLocal(t *testing.T) {
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{"./hello", true},
+		{"../templates/hello", true},
+		{"/srv/templates/hello", true},
+		{"file:///srv/templates/hello", true},
+		{"github.com/example/hello", false},
+		{"example.com/hello", false},
+	}
+	if runtime.GOOS == "windows" {
+		tests = append(tests, struct {
+			src  string
+			want bool
+		}{`C:\templates\hello`, true})
+	}
+	for _, tt := range tests {
+		if got := IsLocal(tt.src); got != tt.want {
+			t.Errorf("IsLocal(%q) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestLocalDir(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{"./hello", filepath.FromSlash("./hello")},
+		{"file:///srv/templates/hello", filepath.FromSlash("/srv/templates/hello")},
+		{"file://./hello", filepath.FromSlash("./hello")},
+	}
+	if runtime.GOOS == "windows" {
+		tests = append(tests, struct{ src, want string }{"file:///C:/templates/hello", `C:\templates\hello`})
+	} else {
+		// There are no volume names to drop.
+		tests = append(tests, struct{ src, want string }{"file:///C:/templates/hello", "/C:/templates/hello"})
+	}
+	for _, tt := range tests {
+		if got := localDir(tt.src); got != tt.want {
+			t.Errorf("localDir(%q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestCloneFileURL(t *testing.T) {
+	tmpl := t.TempDir()
+	writeTree(t, tmpl, map[string]string{
+		"go.mod":               "module github.com/example/hello\n",
+		"hello.go":             "package hello\n",
+		"cmd/hello/main.go":    "package main\n\nimport _ \"github.com/example/hello\"\n",
+		"testdata/xgo.mod":     "module github.com/example/hello\n",
+		"testdata/in.gotmpl":   "package hello\n",
+		"api/v1/api.go":        "package api\n\nimport _ \"github.com/example/hello/internal\"\n",
+		"internal/internal.go": "package internal\n",
+	})
+	dir := cloneLocal(t, Options{SrcRepo: fileURL(tmpl), DstMod: "example.com/myprog"})
+	checkTree(t, dir, map[string]string{
+		"go.mod":             "module example.com/myprog\n",
+		"hello.go":           "package myprog\n",
+		"cmd/hello/main.go":  "package main\n\nimport _ \"example.com/myprog\"\n",
+		"testdata/xgo.mod":   "module github.com/example/hello\n",
+		"testdata/in.gotmpl": "package hello\n",
+		"api/v1/api.go":      "package api\n\nimport _ \"example.com/myprog/internal\"\n",
+	})
+}
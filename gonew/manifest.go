@@ -0,0 +1,305 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// manifestDir is the directory, in the root of a template, holding
+// gonew's metadata about the template. It is removed from the new module.
+const manifestDir = ".gonew"
+
+// manifestFiles lists the places, relative to the root of a template,
+// where its manifest may be. A template may have only one of them.
+// Both are removed from the new module.
+var manifestFiles = []string{
+	filepath.Join(manifestDir, "manifest.yaml"),
+	"gonew.yaml",
+}
+
+// A manifest is the parsed form of a template's .gonew/manifest.yaml
+// or gonew.yaml. Description says in a line what the template is for,
+// as gonew list shows; Vars declares the template variables, with their
+// defaults; Files keeps files only under conditions on those variables;
+// Exclude lists paths, which may be globs, never copied into the new
+// module; Render lists the files, again possibly globs, whose placeholders
+// are substituted even without Options.Subst; Replace lists strings to
+// replace, as with RewriteOptions.Replace, the new strings being able to
+// use placeholders for the variables; Hooks, which may also be spelled
+// post_new, lists commands, split into words at spaces, to run in the
+// new module once it is generated, if Options.RunHooks allows it; and Features declares the
+// optional parts of the template that Options.Features selects.
+type manifest struct {
+	Description string            `yaml:"description"`
+	Vars        []manifestVar     `yaml:"vars"`
+	Files       []manifestFile    `yaml:"files"`
+	Exclude     []string          `yaml:"exclude"`
+	Render      []string          `yaml:"render"`
+	Replace     []manifestReplace `yaml:"replace"`
+	Hooks       []string          `yaml:"hooks"`
+	PostNew     []string          `yaml:"post_new"`
+	Features    []manifestFeature `yaml:"features"`
+}
+
+// A manifestReplace is the manifest form of a Replacement.
+type manifestReplace struct {
+	Old   string `yaml:"old"`
+	New   string `yaml:"new"`
+	Words bool   `yaml:"words"`
+	Cases bool   `yaml:"cases"`
+}
+
+// A manifestVar declares a template variable. Default is its value when
+// Options.Vars does not set it; Description explains it to the user.
+type manifestVar struct {
+	Name        string `yaml:"name"`
+	Default     string `yaml:"default"`
+	Description string `yaml:"description"`
+}
+
+// A manifestFile makes the file or directory at Path, or the files matching
+// Path if it is a glob, part of the new module only when every variable in
+// When has the given value. An entry without conditions is always included.
+type manifestFile struct {
+	Path string            `yaml:"path"`
+	When map[string]string `yaml:"when"`
+}
+
+// readManifest reads the manifest of the template in dir.
+// It returns nil, nil if the template has no manifest.
+func readManifest(dir string) (*manifest, error) {
+	var file string
+	var data []byte
+	for _, name := range manifestFiles {
+		d, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if file != "" {
+			return nil, fmt.Errorf("template has both %s and %s", file, filepath.Join(dir, name))
+		}
+		file, data = filepath.Join(dir, name), d
+	}
+	if file == "" {
+		return nil, nil
+	}
+	m := new(manifest)
+	if err := yaml.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	paths := append([]string(nil), m.Exclude...)
+	paths = append(paths, m.Render...)
+	for _, f := range m.Files {
+		paths = append(paths, f.Path)
+	}
+	for _, f := range m.Features {
+		paths = append(paths, f.Files...)
+	}
+	for _, p := range paths {
+		if p == "" || filepath.IsAbs(p) || strings.HasPrefix(filepath.ToSlash(filepath.Clean(p)), "../") {
+			return nil, fmt.Errorf("%s: invalid file path %q", file, p)
+		}
+	}
+	if len(m.PostNew) > 0 {
+		if len(m.Hooks) > 0 {
+			return nil, fmt.Errorf("%s: both hooks and post_new", file)
+		}
+		m.Hooks, m.PostNew = m.PostNew, nil
+	}
+	for _, h := range m.Hooks {
+		if strings.TrimSpace(h) == "" {
+			return nil, fmt.Errorf("%s: empty post-generation command", file)
+		}
+	}
+	for _, r := range m.Replace {
+		if r.Old == "" {
+			return nil, fmt.Errorf("%s: replacement without an old string", file)
+		}
+	}
+	features := make(map[string]bool)
+	for _, f := range m.Features {
+		if !featureNameRE.MatchString(f.Name) {
+			return nil, fmt.Errorf("%s: invalid feature name %q", file, f.Name)
+		}
+		if features[f.Name] {
+			return nil, fmt.Errorf("%s: feature %s declared twice", file, f.Name)
+		}
+		features[f.Name] = true
+	}
+	seen := make(map[string]bool)
+	for _, v := range m.Vars {
+		if v.Name == "" {
+			return nil, fmt.Errorf("%s: variable without a name", file)
+		}
+		if seen[v.Name] {
+			return nil, fmt.Errorf("%s: variable %s declared twice", file, v.Name)
+		}
+		seen[v.Name] = true
+	}
+	return m, nil
+}
+
+// removeManifest removes the manifest, and the rest of the
+// .gonew directory, from the template in dir.
+func removeManifest(dir string) error {
+	if err := os.RemoveAll(filepath.Join(dir, manifestDir)); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, "gonew.yaml")); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// vars returns the template variables: those set in vars, and for
+// each other variable declared in m, the answer from ask, if not nil,
+// or else its default, which defaults overrides. M may be nil.
+func (m *manifest) vars(vars, defaults map[string]string, ask func(name, description, def string) (string, error)) (map[string]string, error) {
+	all := make(map[string]string)
+	if m != nil {
+		for _, v := range m.Vars {
+			if _, ok := vars[v.Name]; ok {
+				continue
+			}
+			def := v.Default
+			if d, ok := defaults[v.Name]; ok {
+				def = d
+			}
+			all[v.Name] = def
+			if ask != nil {
+				val, err := ask(v.Name, v.Description, def)
+				if err != nil {
+					return nil, err
+				}
+				all[v.Name] = val
+			}
+		}
+	}
+	for k, v := range vars {
+		all[k] = v
+	}
+	return all, nil
+}
+
+// replacements returns the replacements m lists, with the placeholders
+// in their new strings replaced by the values of vars.
+func (m *manifest) replacements(vars map[string]string) ([]Replacement, error) {
+	var list []Replacement
+	for _, r := range m.Replace {
+		var err error
+		new := placeholderRE.ReplaceAllStringFunc(r.New, func(p string) string {
+			name := placeholderRE.FindStringSubmatch(p)[1]
+			v, ok := vars[name]
+			if !ok && err == nil {
+				err = fmt.Errorf("manifest: replacement for %q: unknown template variable %s", r.Old, name)
+			}
+			return v
+		})
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, Replacement{Old: r.Old, New: new, Words: r.Words, Cases: r.Cases})
+	}
+	return list, nil
+}
+
+// met reports whether every condition of f holds for vars.
+func (f *manifestFile) met(vars map[string]string) bool {
+	for k, v := range f.When {
+		if vars[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// applyConditions removes from the tree rooted at root every file and
+// directory that m excludes, or names in an entry whose conditions do
+// not hold for vars.
+func applyConditions(root string, m *manifest, vars map[string]string) error {
+	drop := append([]string(nil), m.Exclude...)
+	for _, f := range m.Files {
+		if !f.met(vars) {
+			drop = append(drop, f.Path)
+		}
+	}
+	return removePaths(root, drop)
+}
+
+// removePaths removes from the tree rooted at root the files and
+// directories named by paths, which may be globs.
+func removePaths(root string, paths []string) error {
+	var drop []string
+	for _, p := range paths {
+		drop = append(drop, strings.TrimSuffix(filepath.ToSlash(filepath.Clean(p)), "/"))
+	}
+	if len(drop) == 0 {
+		return nil
+	}
+	return removeMatching(root, func(rel string, dir bool) bool {
+		for _, p := range drop {
+			if rel == p || matchGlob(p, rel) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// removeMatching removes from the tree rooted at root, except for any .git
+// directory, every file and directory whose slash-separated path relative
+// to root satisfies match, and then the directories that leaves empty.
+func removeMatching(root string, match func(rel string, dir bool) bool) error {
+	var removed []string
+	err := filepath.WalkDir(root, func(src string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if src == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, src)
+		if err != nil {
+			return err
+		}
+		if !match(filepath.ToSlash(rel), d.IsDir()) {
+			return nil
+		}
+		if err := os.RemoveAll(src); err != nil {
+			return err
+		}
+		removed = append(removed, src)
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Remove directories left empty, but never root itself.
+	for _, src := range removed {
+		for dir := filepath.Dir(src); dir != root; dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break // not empty
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConditionalFiles(t *testing.T) {
+	tmpl := t.TempDir()
+	writeTree(t, tmpl, map[string]string{
+		"go.mod": "module github.com/example/hello\n",
+		".gonew/manifest.yaml": `vars:
+  - name: Database
+    default: sqlite
+  - name: Router
+    default: chi
+files:
+  - path: db/postgres
+    when: {Database: postgres}
+  - path: db/sqlite
+    when: {Database: sqlite}
+  - path: "router_*.go"
+    when: {Router: none}
+  - path: docs/postgres-chi.md
+    when: {Database: postgres, Router: chi}
+  - path: README.md
+`,
+		"README.md":            "hello\n",
+		"db/postgres/pg.go":    "package postgres\n",
+		"db/sqlite/lite.go":    "package sqlite\n",
+		"router_chi.go":        "package hello\n",
+		"router_mux.go":        "package hello\n",
+		"docs/postgres-chi.md": "both\n",
+	})
+	tests := []struct {
+		name      string
+		vars      map[string]string
+		have, not []string
+	}{
+		{
+			name: "defaults",
+			have: []string{"README.md", "db/sqlite/lite.go"},
+			not:  []string{"db/postgres", "docs/postgres-chi.md", "router_chi.go", "router_mux.go", ".gonew"},
+		},
+		{
+			name: "postgres",
+			vars: map[string]string{"Database": "postgres"},
+			have: []string{"README.md", "db/postgres/pg.go", "docs/postgres-chi.md"},
+			not:  []string{"db/sqlite", "router_chi.go"},
+		},
+		{
+			name: "no router",
+			vars: map[string]string{"Database": "postgres", "Router": "none"},
+			have: []string{"db/postgres/pg.go", "router_chi.go", "router_mux.go"},
+			not:  []string{"docs/postgres-chi.md", "db/sqlite"},
+		},
+		{
+			name: "neither database",
+			vars: map[string]string{"Database": "mysql", "Router": "mux"},
+			have: []string{"README.md"},
+			not:  []string{"db/postgres", "db/sqlite", "router_chi.go", "router_mux.go", "docs/postgres-chi.md"},
+		},
+	}
+	for _, tt := range tests {
+		dir := cloneLocal(t, Options{SrcRepo: tmpl, DstMod: "example.com/hi", Vars: tt.vars})
+		for _, name := range tt.have {
+			if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+		}
+		for _, name := range tt.not {
+			if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); !os.IsNotExist(err) {
+				t.Errorf("%s: %s included: Stat = %v, want not exist", tt.name, name, err)
+			}
+		}
+	}
+}
+
+func TestReadManifestInvalid(t *testing.T) {
+	tests := []struct {
+		name, data, err string
+	}{
+		{"bad yaml", "files: [", "gonew.yaml: "},
+		{"absolute path", "files:\n  - path: /etc/passwd\n", `invalid file path "/etc/passwd"`},
+		{"parent path", "exclude: [../x]\n", `invalid file path "../x"`},
+		{"unnamed var", "vars:\n  - default: x\n", "variable without a name"},
+		{"var twice", "vars:\n  - name: A\n  - name: A\n", "variable A declared twice"},
+		{"hooks twice", "hooks: [a]\npost_new: [b]\n", "both hooks and post_new"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		writeTree(t, dir, map[string]string{"gonew.yaml": tt.data})
+		_, err := readManifest(dir)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: readManifest: %v, want error %q", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// CheckModulePath reports whether p is a valid module path for a new
+// module, as [module.CheckPath] does, adding to its error a hint at the
+// path meant when p has the form of a repository URL instead, such as
+// https://github.com/you/app or git@github.com:you/app.git, or lacks
+// the host a module path begins with.
+func CheckModulePath(p string) error {
+	err := module.CheckPath(p)
+	if err == nil {
+		return nil
+	}
+	if mod, uerr := ModulePathFromURL(p); uerr == nil && mod != p && module.CheckPath(mod) == nil {
+		return fmt.Errorf("%v; for the repository %s, use %s", err, p, mod)
+	}
+	if first, _, _ := strings.Cut(p, "/"); p != "" && !strings.Contains(first, ".") {
+		return fmt.Errorf("%v; a module path begins with the host of its repository, as in github.com/you/%s", err, p)
+	}
+	return err
+}
+
+// ModulePathFromURL returns the module path of the repository cloned
+// from the git URL u, such as github.com/you/app for
+// https://github.com/you/app.git, git@github.com:you/app.git, or
+// ssh://git@github.com/you/app. Any port of u is left out.
+func ModulePathFromURL(u string) (string, error) {
+	var host, p string
+	if pu, err := url.Parse(u); err == nil && pu.Scheme != "" && pu.Host != "" {
+		switch pu.Scheme {
+		case "https", "http", "ssh", "git", "git+ssh", "ssh+git":
+		default:
+			return "", fmt.Errorf("%s: cannot derive a module path from a %s URL", u, pu.Scheme)
+		}
+		host, p = pu.Hostname(), pu.Path
+	} else if at, rest, ok := strings.Cut(u, ":"); ok && !strings.Contains(at, "/") && rest != "" {
+		// The scp-like form git accepts, [user@]host:path.
+		_, host, _ = strings.Cut(at, "@")
+		if host == "" {
+			host = at
+		}
+		p = rest
+	} else {
+		return "", fmt.Errorf("%s: not a repository URL", u)
+	}
+	p = strings.TrimSuffix(strings.Trim(p, "/"), ".git")
+	if host == "" || p == "" {
+		return "", fmt.Errorf("%s: not a repository URL", u)
+	}
+	return strings.ToLower(host) + "/" + p, nil
+}
+
+// InferModulePath returns the module path of the repository that the git
+// repository containing dir, such as a fresh clone of an empty repository,
+// has as its origin remote.
+func InferModulePath(ctx context.Context, dir string) (string, error) {
+	var stderr bytes.Buffer
+	// The URL as configured, not as rewritten by any url.<base>.insteadOf.
+	cmd := exec.CommandContext(ctx, "git", "-C", dir, "config", "--get", "remote.origin.url")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%s", msg)
+		} else if ee, ok := err.(*exec.ExitError); ok && ee.ExitCode() == 1 {
+			err = fmt.Errorf("no origin remote")
+		}
+		return "", fmt.Errorf("finding the origin remote of %s: %v", dir, err)
+	}
+	mod, err := ModulePathFromURL(strings.TrimSpace(string(out)))
+	if err != nil {
+		return "", fmt.Errorf("origin remote of %s: %v", dir, err)
+	}
+	if err := module.CheckPath(mod); err != nil {
+		return "", fmt.Errorf("origin remote of %s: %v", dir, err)
+	}
+	return mod, nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// An Overlay is a template applied on top of another, as for adding
+// observability or deployment files to a base service skeleton.
+// SrcRepo, Subdir, and Version are as for the Options fields of those
+// names; Commit and Hash, set only in a Result, are as for its fields.
+type Overlay struct {
+	SrcRepo string `json:"template"`
+	Subdir  string `json:"subdir,omitempty"`
+	Version string `json:"version,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+	Hash    string `json:"hash,omitempty"`
+}
+
+// String returns the overlay in the form gonew's command line takes it.
+func (o Overlay) String() string {
+	s := o.SrcRepo
+	if o.Subdir != "" {
+		s += "//" + o.Subdir
+	}
+	return describeVersion(s, o.Version)
+}
+
+// A composition collects, while overlays are applied, what the templates
+// making up the new module have in common.
+type composition struct {
+	hooks    []string        // post-generation commands of the overlays, in order
+	features map[string]bool // features any of the templates declare, and whether each is included
+}
+
+// addFeatures records the features of one of the templates.
+func (c *composition) addFeatures(on map[string]bool) {
+	for name, v := range on {
+		c.features[name] = c.features[name] || v
+	}
+}
+
+// checkFeatures reports an error if selected names a feature none of
+// the templates declares.
+func (c *composition) checkFeatures(selected []string) error {
+	for _, name := range selected {
+		if _, ok := c.features[name]; ok {
+			continue
+		}
+		if len(c.features) == 0 {
+			return fmt.Errorf("unknown feature %s: the templates have no optional features", name)
+		}
+		var names []string
+		for n := range c.features {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown feature %s: the templates have %s", name, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// applyOverlays applies opts.Overlays, in order, to the module being built
+// in dst, as [Add] would with Force set, so that a file of an overlay
+// replaces that of the base template or of an earlier overlay, and the
+// requirements of their go.mod files are merged. The Options of the base
+// template apply to each overlay as well, except RequireCommit, which
+// names a commit of the base. It adds the files each changes to rewritten
+// and the variables it sets to vars, and returns what was applied.
+func applyOverlays(ctx context.Context, dst string, opts *Options, comp *composition, vars map[string]string, rewritten map[string]bool) ([]Overlay, error) {
+	var applied []Overlay
+	for _, ov := range opts.Overlays {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		o := *opts
+		o.SrcRepo, o.Subdir, o.Version = ov.SrcRepo, ov.Subdir, ov.Version
+		o.RequireCommit = ""
+		o.Overlays = nil
+		o.composing = comp
+		o.Vars = vars
+		o.Force, o.AllowExisting = true, false
+		if IsLocal(o.SrcRepo) {
+			abs, err := filepath.Abs(localDir(o.SrcRepo))
+			if err != nil {
+				return nil, err
+			}
+			o.SrcRepo = abs
+		}
+		opts.logf("applying overlay %s", ov)
+		res, err := Add(ctx, dst, o)
+		if err != nil {
+			return nil, fmt.Errorf("overlay %s: %v", ov, err)
+		}
+		for _, name := range res.Rewritten {
+			rewritten[name] = true
+		}
+		for _, name := range res.Files {
+			if name == "go.mod" || name == "go.sum" {
+				rewritten[name] = true
+			}
+		}
+		for k, v := range res.Vars {
+			vars[k] = v
+		}
+		applied = append(applied, Overlay{SrcRepo: o.SrcRepo, Subdir: o.Subdir, Version: res.Version, Commit: res.Commit, Hash: res.Hash})
+	}
+	return applied, nil
+}
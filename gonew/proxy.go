@@ -0,0 +1,74 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// A proxyModule is the part of the output of "go mod download -json"
+// that gonew uses.
+type proxyModule struct {
+	Version string // resolved version
+	Info    string // path to the .info file, holding the version's time
+	Dir     string // extracted module in the module cache
+	Sum     string // checksum of the module zip, as in go.sum
+	Error   string
+}
+
+// downloadModule downloads version vers of the module mod, or its latest
+// version if vers is empty, using "go mod download". The go command fetches
+// it through GOPROXY, checks it against the checksum database as configured
+// by GONOSUMDB and friends, and stores it in the module cache. If offline is
+// set, only the module cache is consulted.
+func downloadModule(ctx context.Context, mod, vers string, offline bool, logf func(string, ...any)) (*proxyModule, error) {
+	if vers == "" {
+		vers = "latest"
+	}
+	args := []string{"mod", "download", "-json", mod + "@" + vers}
+	logf("running go %s", strings.Join(args, " "))
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if offline {
+		cmd.Env = append(os.Environ(), "GOPROXY=off")
+	}
+	err := cmd.Run()
+	var m proxyModule
+	if jerr := json.Unmarshal(stdout.Bytes(), &m); jerr != nil {
+		if err == nil {
+			err = jerr
+		}
+		return nil, fmt.Errorf("go mod download %s@%s: %v\n%s", mod, vers, err, stderr.Bytes())
+	}
+	if m.Error != "" {
+		return nil, fmt.Errorf("go mod download %s@%s: %s", mod, vers, m.Error)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("go mod download %s@%s: %v\n%s", mod, vers, err, stderr.Bytes())
+	}
+	return &m, nil
+}
+
+// time returns the time of the downloaded version, as recorded by the proxy.
+func (m *proxyModule) time() (time.Time, error) {
+	data, err := os.ReadFile(m.Info)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("reading version time: %v", err)
+	}
+	var info struct{ Time time.Time }
+	if err := json.Unmarshal(data, &info); err != nil {
+		return time.Time{}, fmt.Errorf("reading version time: %s: %v", m.Info, err)
+	}
+	return info.Time, nil
+}
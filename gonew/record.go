@@ -0,0 +1,142 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// RecordFile is the name of the file, in the root of the new module,
+// in which -record notes where the module came from.
+const RecordFile = ".gonew.json"
+
+// A record describes the template a module was created from.
+type record struct {
+	Template   string            `json:"template"`             // module path of the template, with any //subdir
+	Source     string            `json:"source,omitempty"`     // local template directory or archive, if not cloned
+	Proxy      bool              `json:"proxy,omitempty"`      // template was downloaded through the module proxy
+	Version    string            `json:"version,omitempty"`    // version requested on the command line
+	Commit     string            `json:"commit,omitempty"`     // commit the template was cloned at
+	Hash       string            `json:"hash,omitempty"`       // hash of the template's files, as templateHash computes it
+	Submodules []Submodule       `json:"submodules,omitempty"` // git submodules of the template, with their pinned commits
+	Time       time.Time         `json:"time"`                 // when the module was created, or last updated, from the template
+	Module     string            `json:"module"`               // module path of the new module
+	Vars       map[string]string `json:"vars,omitempty"`       // template variables, as set or defaulted
+	Features   map[string]bool   `json:"features,omitempty"`   // whether each optional feature was included
+	Overlays   []Overlay         `json:"overlays,omitempty"`   // templates applied on top of Template, in order
+	Gonew      string            `json:"gonew"`                // version of gonew that created the module
+}
+
+// headCommit returns the commit hash of HEAD in the git repository at dir,
+// or "" if it cannot be determined. A dir that is not itself the root of
+// a repository, such as a copy of a template without its .git directory,
+// has no commit, even inside another repository.
+func headCommit(dir string) string {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		return ""
+	}
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// templateHash returns a hash of the files of the template in dir, other
+// than those in its .git directory, in the "h1:" form of a go.sum line,
+// with the files named as they would be in the module zip of mod at vers.
+// For a template downloaded through the module proxy, it is the hash
+// go.sum and the checksum database list for that version of the module.
+func templateHash(dir, mod, vers string) (string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(src string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, src)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+	prefix := mod + "@" + vers + "/"
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = prefix + f
+	}
+	return dirhash.Hash1(names, func(name string) (io.ReadCloser, error) {
+		return os.Open(filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(name, prefix))))
+	})
+}
+
+// modulePath is the path of the module providing this package.
+const modulePath = "github.com/cody0704/gonew"
+
+// gonewVersion returns the version of gonew built into the running binary,
+// which is the main module for the gonew command but a dependency for
+// other programs using this package.
+func gonewVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+	if info.Main.Path == modulePath && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath && dep.Version != "" {
+			return dep.Version
+		}
+	}
+	return "(devel)"
+}
+
+// readRecord reads the record file in dir.
+func readRecord(dir string) (*record, error) {
+	data, err := os.ReadFile(filepath.Join(dir, RecordFile))
+	if err != nil {
+		return nil, err
+	}
+	r := new(record)
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Join(dir, RecordFile), err)
+	}
+	if r.Template == "" || r.Module == "" {
+		return nil, fmt.Errorf("%s: missing template or module", filepath.Join(dir, RecordFile))
+	}
+	return r, nil
+}
+
+// writeRecord writes r to the record file in dir.
+func writeRecord(dir string, r record) error {
+	data, err := json.MarshalIndent(r, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, RecordFile), append(data, '\n'), 0666)
+}
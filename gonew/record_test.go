@@ -0,0 +1,71 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecord(t *testing.T) {
+	tmpl := t.TempDir()
+	hashes := gitRepo(t, tmpl, map[string]string{
+		"go.mod":   "module github.com/example/hello\n",
+		"hello.go": "package hello\n",
+	})
+	git(t, tmpl, "tag", "v1.0.0")
+	before := time.Now().Add(-time.Second)
+	dir := cloneLocal(t, Options{
+		SrcRepo: tmpl,
+		Version: "v1.0.0",
+		DstMod:  "example.com/hi",
+		Record:  true,
+	})
+	r, err := readRecord(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Template != "github.com/example/hello" || r.Source != tmpl || r.Version != "v1.0.0" || r.Commit != hashes[0] || r.Module != "example.com/hi" {
+		t.Errorf("record = %+v, want template github.com/example/hello from %s at v1.0.0, commit %s, module example.com/hi", r, tmpl, hashes[0])
+	}
+	if !strings.HasPrefix(r.Hash, "h1:") {
+		t.Errorf("record hash = %q, want h1:...", r.Hash)
+	}
+	if r.Gonew == "" {
+		t.Error("record names no gonew version")
+	}
+	if r.Time.Before(before) || r.Time.After(time.Now()) {
+		t.Errorf("record time = %v, want about now", r.Time)
+	}
+
+	// The record names the template's module path, which rehoming
+	// the module must leave alone.
+	if _, err := RehomeContext(context.Background(), dir, "example.com/other", RewriteOptions{RewriteExt: []string{"json"}}); err != nil {
+		t.Fatal(err)
+	}
+	r2, err := readRecord(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r2.Template != "github.com/example/hello" || r2.Module != "example.com/hi" {
+		t.Errorf("after rehoming, record = %+v, want it unchanged", r2)
+	}
+}
+
+func TestReadRecordInvalid(t *testing.T) {
+	for _, data := range []string{"", "{", `{"template": "github.com/example/hello"}`, `{"module": "example.com/hi"}`} {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, RecordFile), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readRecord(dir); err == nil {
+			t.Errorf("readRecord(%q) succeeded, want an error", data)
+		}
+	}
+}
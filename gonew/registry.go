@@ -0,0 +1,132 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A Registry maps short names to templates, so that a team can write
+// grpc-service for a template instead of the path of its repository.
+// It is read from a YAML file of the form
+//
+//	templates:
+//	  - name: grpc-service
+//	    repo: github.com/example/grpc-template
+//	    version: v1.2.0
+//	    description: A gRPC service with health checks and metrics
+//	  - name: cli
+//	    repo: github.com/example/templates
+//	    subdir: cli
+//
+// in which each template's version, subdir, and description are optional.
+type Registry struct {
+	Templates []RegistryTemplate `yaml:"templates"`
+}
+
+// A RegistryTemplate is one template in a [Registry].
+type RegistryTemplate struct {
+	Name        string `yaml:"name"`        // short name, without any dot, slash, or @
+	Repo        string `yaml:"repo"`        // as in Options.SrcRepo
+	Subdir      string `yaml:"subdir"`      // as in Options.Subdir
+	Version     string `yaml:"version"`     // as in Options.Version
+	Description string `yaml:"description"` // one line for a listing
+}
+
+// ReadRegistry reads the registry at location, which is either a file
+// or an http or https URL.
+func ReadRegistry(ctx context.Context, location string) (*Registry, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://") {
+		data, err = fetchRegistry(ctx, location)
+	} else {
+		data, err = os.ReadFile(location)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := new(Registry)
+	if err := yaml.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("%s: %v", location, err)
+	}
+	seen := make(map[string]bool)
+	for _, t := range r.Templates {
+		switch {
+		case t.Name == "":
+			return nil, fmt.Errorf("%s: template without a name", location)
+		case strings.ContainsAny(t.Name, "./@"):
+			// Such a name could be mistaken for a module path or version.
+			return nil, fmt.Errorf("%s: invalid template name %q: contains a dot, slash, or @", location, t.Name)
+		case t.Repo == "":
+			return nil, fmt.Errorf("%s: template %s without a repo", location, t.Name)
+		case seen[t.Name]:
+			return nil, fmt.Errorf("%s: template %s listed twice", location, t.Name)
+		}
+		seen[t.Name] = true
+	}
+	return r, nil
+}
+
+// fetchRegistry returns the content of the registry at the URL u.
+func fetchRegistry(ctx context.Context, u string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching registry: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching registry %s: %s", u, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// Lookup returns the template with the given short name.
+func (r *Registry) Lookup(name string) (RegistryTemplate, bool) {
+	if r != nil {
+		for _, t := range r.Templates {
+			if t.Name == name {
+				return t, true
+			}
+		}
+	}
+	return RegistryTemplate{}, false
+}
+
+// Describe returns the description of the template: that in its manifest,
+// if the template is local or its clone is in the cache directory cache,
+// and otherwise that in the registry.
+func (t RegistryTemplate) Describe(cache string) string {
+	dir := ""
+	if IsLocal(t.Repo) {
+		dir = localDir(t.Repo)
+	} else if cache != "" {
+		if entry, err := cacheEntry(cache, t.Repo, t.Version); err == nil {
+			dir = entry
+		}
+	}
+	if dir != "" {
+		m, err := readManifest(filepath.Join(dir, filepath.FromSlash(t.Subdir)))
+		if err == nil && m != nil && m.Description != "" {
+			return m.Description
+		}
+	}
+	return t.Description
+}
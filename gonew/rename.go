@@ -0,0 +1,122 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"fmt"
+	"go/token"
+	"os"
+	"path"
+	"strings"
+	"unicode"
+
+	"golang.org/x/mod/module"
+)
+
+// A Rename rewrites import paths at or below Old to the
+// corresponding path at or below New.
+type Rename struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// ReadRenames reads a file of "oldpath newpath" pairs, one per line.
+// Blank lines and lines beginning with # are ignored. Each path must be a
+// valid module path, no old path may be given twice, and no two old paths
+// may be renamed to the same new path.
+func ReadRenames(file string) ([]Rename, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var renames []Rename
+	olds := make(map[string]int) // old path -> line number
+	news := make(map[string]int) // new path -> line number
+	for i, line := range strings.Split(string(data), "\n") {
+		lineno := i + 1
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) != 2 {
+			return nil, fmt.Errorf("%s:%d: want \"oldpath newpath\"", file, lineno)
+		}
+		for _, p := range f {
+			if err := module.CheckPath(p); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", file, lineno, err)
+			}
+		}
+		old, new := f[0], f[1]
+		if prev, ok := olds[old]; ok {
+			return nil, fmt.Errorf("%s:%d: %s already renamed at line %d", file, lineno, old, prev)
+		}
+		if prev, ok := news[new]; ok {
+			return nil, fmt.Errorf("%s:%d: %s already the target of the rename at line %d", file, lineno, new, prev)
+		}
+		olds[old] = lineno
+		news[new] = lineno
+		renames = append(renames, Rename{old, new})
+	}
+	return renames, nil
+}
+
+// checkRenames reports an error if one of renames renames srcMod itself,
+// whose new path is the destination module path instead.
+func checkRenames(srcMod string, renames []Rename) error {
+	for _, r := range renames {
+		if r.Old == srcMod {
+			return fmt.Errorf("rename of %s: it is the source module; give its new path as the destination module", r.Old)
+		}
+	}
+	return nil
+}
+
+// mapImportPath applies the rename whose old path is the longest match
+// for p, either equal to p or a prefix of it ending at a slash.
+// It returns the rewritten path and the rename used,
+// or ok == false if no rename applies.
+func mapImportPath(p string, renames []Rename) (newPath string, r Rename, ok bool) {
+	for _, x := range renames {
+		if (p == x.Old || strings.HasPrefix(p, x.Old+"/")) && len(x.Old) > len(r.Old) {
+			r, ok = x, true
+		}
+	}
+	if !ok {
+		return "", Rename{}, false
+	}
+	return r.New + strings.TrimPrefix(p, r.Old), r, true
+}
+
+// guessPackageName returns the conventional package name for the
+// import path p: its last element, skipping a major version suffix
+// such as /v2 and trimming a gopkg.in-style .vN suffix.
+func guessPackageName(p string) string {
+	elem := path.Base(p)
+	if len(elem) > 1 && elem[0] == 'v' && strings.Trim(elem[1:], "0123456789") == "" && path.Dir(p) != "." {
+		elem = path.Base(path.Dir(p))
+	}
+	if i := strings.LastIndex(elem, ".v"); i > 0 && strings.Trim(elem[i+2:], "0123456789") == "" {
+		elem = elem[:i]
+	}
+	return elem
+}
+
+// packageName returns the name gonew gives the primary package of
+// module mod: guessPackageName(mod) without the characters that cannot
+// appear in an identifier, so that example.com/my-prog gives myprog.
+// It returns "" if that still leaves no valid package name.
+func packageName(mod string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, guessPackageName(mod))
+	if !token.IsIdentifier(name) {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadRenames(t *testing.T) {
+	tests := []struct {
+		name, data string
+		want       []Rename
+		err        string
+	}{
+		{
+			name: "pairs",
+			data: "# upstreams\ngithub.com/a/log example.com/log\n\n  github.com/b/db   example.com/db  \n",
+			want: []Rename{{"github.com/a/log", "example.com/log"}, {"github.com/b/db", "example.com/db"}},
+		},
+		{name: "empty", data: "\n# nothing\n"},
+		{name: "one field", data: "github.com/a/log\n", err: `renames.txt:1: want "oldpath newpath"`},
+		{name: "three fields", data: "a.com/x b.com/x c.com/x\n", err: `renames.txt:1: want "oldpath newpath"`},
+		{name: "invalid path", data: "# c\ngithub.com/a/log Example.com/-x\n", err: "renames.txt:2: "},
+		{name: "old twice", data: "a.com/x b.com/x\na.com/x c.com/x\n", err: "renames.txt:2: a.com/x already renamed at line 1"},
+		{name: "new twice", data: "a.com/x c.com/x\nb.com/x c.com/x\n", err: "renames.txt:2: c.com/x already the target of the rename at line 1"},
+	}
+	for _, tt := range tests {
+		file := filepath.Join(t.TempDir(), "renames.txt")
+		if err := os.WriteFile(file, []byte(tt.data), 0666); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadRenames(file)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: ReadRenames = %v, %v, want error %q", tt.name, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("%s: ReadRenames = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestMapImportPath(t *testing.T) {
+	renames := []Rename{{"github.com/a/log", "example.com/log"}, {"github.com/a/log/v2", "example.com/log2"}}
+	tests := []struct {
+		path, want string
+		ok         bool
+	}{
+		{"github.com/a/log", "example.com/log", true},
+		{"github.com/a/log/level", "example.com/log/level", true},
+		{"github.com/a/log/v2", "example.com/log2", true},
+		{"github.com/a/log/v2/level", "example.com/log2/level", true},
+		{"github.com/a/logger", "", false},
+		{"github.com/a", "", false},
+	}
+	for _, tt := range tests {
+		got, _, ok := mapImportPath(tt.path, renames)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("mapImportPath(%q) = %q, %v, want %q, %v", tt.path, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCloneRenameFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "renames.txt")
+	if err := os.WriteFile(file, []byte("github.com/a/log example.com/log\ngithub.com/b/db example.com/store\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	renames, err := ReadRenames(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := t.TempDir()
+	writeTree(t, tmpl, map[string]string{
+		"go.mod": "module github.com/example/hello\n",
+		"main.go": `package main
+
+import (
+	"github.com/a/log/level"
+	"github.com/b/db"
+	"github.com/c/other"
+	"github.com/example/hello/api"
+)
+`,
+	})
+	dir := cloneLocal(t, Options{SrcRepo: tmpl, DstMod: "example.com/hi", RewriteOptions: RewriteOptions{Renames: renames}})
+	checkTree(t, dir, map[string]string{
+		"main.go": `package main
+
+import (
+	"example.com/log/level"
+	db "example.com/store"
+	"github.com/c/other"
+	"example.com/hi/api"
+)
+`,
+	})
+}
+
+func TestCheckRenames(t *testing.T) {
+	if err := checkRenames("github.com/example/hello", []Rename{{"github.com/a/log", "example.com/log"}}); err != nil {
+		t.Error(err)
+	}
+	err := checkRenames("github.com/example/hello", []Rename{{"github.com/example/hello", "example.com/hi"}})
+	if err == nil || !strings.Contains(err.Error(), "it is the source module") {
+		t.Errorf("renaming the source module: %v, want an error", err)
+	}
+}
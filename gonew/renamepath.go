@@ -0,0 +1,108 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// renameName returns name with each occurrence of old, as a whole word
+// delimited by anything but a letter or digit, replaced by new,
+// as in hello_test.go becoming myprog_test.go.
+func renameName(name, old, new string) string {
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+	var b strings.Builder
+	last := 0
+	for i := 0; i+len(old) <= len(name); {
+		j := strings.Index(name[i:], old)
+		if j < 0 {
+			break
+		}
+		i += j
+		end := i + len(old)
+		before, _ := utf8.DecodeLastRuneInString(name[:i])
+		after, _ := utf8.DecodeRuneInString(name[end:])
+		if (i == 0 || !isWord(before)) && (end == len(name) || !isWord(after)) {
+			b.WriteString(name[last:i])
+			b.WriteString(new)
+			last = end
+		}
+		i = end
+	}
+	b.WriteString(name[last:])
+	return b.String()
+}
+
+// renamePaths renames the files and directories in the tree rooted at root
+// whose names contain the name of the project srcMod, its last path element,
+// to contain that of dstMod instead, as in cmd/hello becoming cmd/myprog.
+// The .git directory and the directories opts.SkipDirs names are left
+// alone. It returns the renames of the import paths of the directories
+// it moved, which the Go files importing them need.
+func renamePaths(root, srcMod, dstMod string, opts *RewriteOptions) ([]Rename, error) {
+	old, new := guessPackageName(srcMod), guessPackageName(dstMod)
+	if old == new {
+		return nil, nil
+	}
+	var rels []string // in walk order, so each directory before its contents
+	err := filepath.WalkDir(root, func(src string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if src == root {
+			return nil
+		}
+		if d.IsDir() && (d.Name() == ".git" || opts.skipDir(d.Name())) {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(root, src)
+		if err != nil {
+			return err
+		}
+		rels = append(rels, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Rename the deepest entries first, so that each rename happens in a
+	// directory that still has its old name.
+	var renames []Rename
+	for i := len(rels) - 1; i >= 0; i-- {
+		rel := rels[i]
+		dir, elem := path.Split(rel)
+		newElem := renameName(elem, old, new)
+		if newElem == elem {
+			continue
+		}
+		src := filepath.Join(root, filepath.FromSlash(rel))
+		dst := filepath.Join(root, filepath.FromSlash(dir+newElem))
+		if _, err := os.Lstat(dst); err == nil {
+			return nil, fmt.Errorf("renaming %s to %s: %s already exists", rel, dir+newElem, dir+newElem)
+		}
+		opts.logf("renaming %s to %s", rel, dir+newElem)
+		if err := os.Rename(src, dst); err != nil {
+			return nil, err
+		}
+		if info, err := os.Lstat(dst); err == nil && info.IsDir() {
+			var newRel []string
+			for _, e := range strings.Split(rel, "/") {
+				newRel = append(newRel, renameName(e, old, new))
+			}
+			renames = append(renames, Rename{Old: srcMod + "/" + rel, New: dstMod + "/" + strings.Join(newRel, "/")})
+		}
+	}
+	sort.Slice(renames, func(i, j int) bool { return renames[i].Old < renames[j].Old })
+	return renames, nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// A Replacement replaces the string Old with New in the text files
+// of a module, as for the name of an application, its binary, or its
+// Docker image, which the module path alone does not give.
+type Replacement struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+
+	// Words replaces Old only where it is a whole word,
+	// not part of a longer identifier.
+	Words bool `json:"words,omitempty"`
+
+	// Cases also replaces the other case variants of Old with the same
+	// variants of New: for Old MyApp and New YourTool, myApp with yourTool,
+	// myapp with yourtool, MYAPP with YOURTOOL, my_app with your_tool,
+	// MY_APP with YOUR_TOOL, and my-app with your-tool. The words of Old
+	// and New are found at changes of case and at _, -, ., and spaces.
+	Cases bool `json:"cases,omitempty"`
+}
+
+// A replacer applies a Replacement.
+type replacer struct {
+	re  *regexp.Regexp
+	new map[string]string // replacement for each match of re
+}
+
+func newReplacer(r Replacement) (*replacer, error) {
+	if r.Old == "" {
+		return nil, errors.New("empty string to replace")
+	}
+	pairs := [][2]string{{r.Old, r.New}}
+	if r.Cases {
+		pairs = append(pairs, caseVariants(r.Old, r.New)...)
+	}
+	rp := &replacer{new: make(map[string]string)}
+	var olds []string
+	for _, p := range pairs {
+		if _, ok := rp.new[p[0]]; ok || p[0] == "" {
+			continue // the variant given first wins
+		}
+		rp.new[p[0]] = p[1]
+		olds = append(olds, regexp.QuoteMeta(p[0]))
+	}
+	// Prefer the longest match, as for my_app over my.
+	sort.SliceStable(olds, func(i, j int) bool { return len(olds[i]) > len(olds[j]) })
+	expr := "(?:" + strings.Join(olds, "|") + ")"
+	if r.Words {
+		expr = `\b` + expr + `\b`
+	}
+	var err error
+	if rp.re, err = regexp.Compile(expr); err != nil {
+		return nil, fmt.Errorf("replacing %q: %v", r.Old, err)
+	}
+	return rp, nil
+}
+
+func (rp *replacer) replace(data []byte) []byte {
+	return rp.re.ReplaceAllFunc(data, func(m []byte) []byte {
+		return []byte(rp.new[string(m)])
+	})
+}
+
+// caseVariants returns the pairs of case variants of old and new
+// described by Replacement.Cases.
+func caseVariants(old, new string) [][2]string {
+	ow, nw := splitWords(old), splitWords(new)
+	var pairs [][2]string
+	for _, join := range []func([]string) string{
+		joinCamel(true), joinCamel(false),
+		joinCase(strings.ToLower, ""), joinCase(strings.ToUpper, ""),
+		joinCase(strings.ToLower, "_"), joinCase(strings.ToUpper, "_"),
+		joinCase(strings.ToLower, "-"),
+	} {
+		pairs = append(pairs, [2]string{join(ow), join(nw)})
+	}
+	return pairs
+}
+
+// splitWords splits s into words at the separators _, -, ., and spaces, and
+// at changes of case, as in MyHTTPServer, which has the words My, HTTP, and Server.
+func splitWords(s string) []string {
+	var words []string
+	r := []rune(s)
+	start := 0
+	for i := 0; i <= len(r); i++ {
+		switch {
+		case i == len(r) || strings.ContainsRune("_-. ", r[i]):
+			if i > start {
+				words = append(words, string(r[start:i]))
+			}
+			start = i + 1
+		case i > start && unicode.IsUpper(r[i]) &&
+			(unicode.IsLower(r[i-1]) || unicode.IsDigit(r[i-1]) ||
+				i+1 < len(r) && unicode.IsUpper(r[i-1]) && unicode.IsLower(r[i+1])):
+			words = append(words, string(r[start:i]))
+			start = i
+		}
+	}
+	return words
+}
+
+// joinCamel returns a function joining words in PascalCase,
+// or in camelCase if upper is false.
+func joinCamel(upper bool) func([]string) string {
+	return func(words []string) string {
+		var b strings.Builder
+		for i, w := range words {
+			w = strings.ToLower(w)
+			if i > 0 || upper {
+				r := []rune(w)
+				r[0] = unicode.ToUpper(r[0])
+				w = string(r)
+			}
+			b.WriteString(w)
+		}
+		return b.String()
+	}
+}
+
+// joinCase returns a function joining words, each converted by
+// toCase, with sep.
+func joinCase(toCase func(string) string, sep string) func([]string) string {
+	return func(words []string) string {
+		return toCase(strings.Join(words, sep))
+	}
+}
+
+// replaceTree applies the replacements, in order, to the text files
+// of the tree rooted at root, adding the files it changes to rewritten.
+func replaceTree(root string, replacements []Replacement, opts *RewriteOptions, rewritten map[string]bool) error {
+	var rps []*replacer
+	for _, r := range replacements {
+		rp, err := newReplacer(r)
+		if err != nil {
+			return err
+		}
+		rps = append(rps, rp)
+	}
+	return walkText(root, nil, opts, rewritten, func(rel string, data []byte) []byte {
+		for _, rp := range rps {
+			data = rp.replace(data)
+		}
+		return data
+	})
+}
@@ -0,0 +1,1048 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build/constraint"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cody0704/gonew/internal/edit"
+	"golang.org/x/mod/modfile"
+)
+
+// rewriteTree rewrites the module rooted at root to replace srcMod with
+// dstMod, along with the renames in opts. It returns the set of files whose
+// content changed, as slash-separated paths relative to root; a report of
+// the files it skipped; and the path of the .git directory in root,
+// if there is one, which it leaves untouched.
+//
+// If importsOnly is set, rewriteTree rewrites only the import paths in Go
+// files: it renames no packages, and it leaves go.mod and all other files alone.
+// Once ctx is done, it starts rewriting no more files and fails with ctx.Err().
+func rewriteTree(ctx context.Context, root, srcMod, dstMod string, opts *RewriteOptions, importsOnly bool) (rewritten map[string]bool, skipped *skipReport, gitdir string, err error) {
+	primary := findPrimary(root, srcMod, opts)
+	if importsOnly {
+		// No package is renamed, but an importer of srcMod itself
+		// still needs an alias if the conventional name changes.
+		primary = primaryPackage{dir: ".", name: guessPackageName(srcMod)}
+	}
+
+	rewritten = make(map[string]bool)
+	skipped = new(skipReport)
+	var (
+		mu   sync.Mutex // guards rewritten, skipped, and errs once rewriting starts
+		errs []error
+	)
+	rewrite := func(src, rel string, d fs.DirEntry, fix func([]byte) ([]byte, error)) error {
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if opts.MaxFileSize > 0 && info.Size() > opts.MaxFileSize {
+			opts.warnf("%s: %d bytes exceeds the size limit of %d bytes; copying without rewriting", filepath.ToSlash(rel), info.Size(), opts.MaxFileSize)
+			mu.Lock()
+			skipped.add(rel, SkipTooLarge)
+			mu.Unlock()
+			return errSkipFile
+		}
+		data, err := os.ReadFile(src)
+		if err != nil {
+			if errors.Is(err, fs.ErrPermission) && !opts.Strict {
+				opts.warnf("%v; copying without rewriting", err)
+				mu.Lock()
+				skipped.add(rel, SkipUnreadable)
+				mu.Unlock()
+				return errSkipFile
+			}
+			return err
+		}
+		new, err := fix(data)
+		if perr := (*parseError)(nil); errors.As(err, &perr) && !opts.Strict {
+			opts.warnf("%v; copying without rewriting", perr.err)
+			mu.Lock()
+			skipped.add(rel, SkipParseError)
+			mu.Unlock()
+			return errSkipFile
+		}
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(new, data) {
+			return nil
+		}
+		// Keep the original mode, so that an executable stays executable.
+		if err := writeFile(src, new, info.Mode().Perm()); err != nil {
+			return err
+		}
+		opts.logf("rewrote %s", filepath.ToSlash(rel))
+		mu.Lock()
+		rewritten[filepath.ToSlash(rel)] = true
+		mu.Unlock()
+		return nil
+	}
+
+	// Change project go module name to dstMod.
+	// The walk only collects the files to rewrite, so that they can be
+	// rewritten in parallel once it is done, as each is independent.
+	var jobs []rewriteJob
+	var decls map[pkgKey]map[string]bool // set once the walk is done
+	vendors := make(map[string]string)   // vendor directory to rewrite in part, and the path of its copy of srcMod
+	walkErr := filepath.WalkDir(root, func(src string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if d != nil && d.IsDir() && src != root && !opts.Strict {
+				// An unreadable directory is copied as is, like any other
+				// file gonew does not rewrite.
+				rel, _ := filepath.Rel(root, src)
+				opts.warnf("%v; skipping directory", err)
+				skipped.add(rel, SkipUnreadable)
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				gitdir = src
+				return filepath.SkipDir
+			}
+			if src != root && opts.skipDir(d.Name()) {
+				rel, _ := filepath.Rel(root, src)
+				skipped.add(rel, SkipExcludedDir)
+				if d.Name() == "vendor" && !importsOnly {
+					own, err := fixVendor(src, srcMod, dstMod, opts)
+					if err != nil {
+						return err
+					}
+					if own != "" {
+						vendors[src] = own
+						return nil
+					}
+				}
+				return filepath.SkipDir
+			}
+			if !inVendor(vendors, src, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !inVendor(vendors, src, false) {
+			return nil
+		}
+
+		if d.Name() == RecordFile && filepath.Dir(src) == root {
+			// A record left by an earlier run describes the template itself;
+			// it is replaced, never rewritten.
+			return nil
+		}
+
+		isGo := strings.HasSuffix(d.Name(), ".go")
+		isMod := d.Name() == "go.mod" && !importsOnly
+		isWork := d.Name() == "go.work" && !importsOnly
+		isSum := (d.Name() == "go.sum" || d.Name() == "go.work.sum") && srcMod != dstMod && !importsOnly
+		isCodegen := opts.RewriteCodegen && isCodegenConfig(d.Name()) && !importsOnly
+		isVendorList := d.Name() == "modules.txt" && vendors[filepath.Dir(src)] != ""
+		isText := !isGo && !isMod && !isWork && !isSum && !isCodegen && (isVendorList || matchExt(d.Name(), opts.RewriteExt)) && !importsOnly
+		if !isGo && !isMod && !isWork && !isSum && !isCodegen && !isText {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, src)
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			// Writing through the link could modify a file outside the module.
+			skipped.add(rel, SkipSymlink)
+			return nil
+		}
+		if len(opts.Include) > 0 && !matchAny(opts.Include, filepath.ToSlash(rel)) {
+			skipped.add(rel, SkipNotIncluded)
+			return nil
+		}
+
+		// check *.go files
+		// fix go file
+		// Whether a file belongs to the primary package depends on its
+		// directory relative to root: src itself is an absolute path.
+		isPrimary := primary.name != "" && path.Dir(filepath.ToSlash(rel)) == primary.dir && !importsOnly
+		var fixes []func([]byte) ([]byte, error)
+		if isGo {
+			fixes = append(fixes, func(data []byte) ([]byte, error) {
+				return fixGo(data, src, srcMod, dstMod, primary, isPrimary, decls, opts)
+			})
+		}
+		if isGo && opts.RewriteStrings && !importsOnly {
+			fixes = append(fixes, func(data []byte) ([]byte, error) {
+				return fixGoStrings(data, src, srcMod, dstMod), nil
+			})
+		}
+		if isGo && !importsOnly {
+			fixes = append(fixes, func(data []byte) ([]byte, error) {
+				return fixGoComments(data, src, srcMod, dstMod, opts.RewriteComments), nil
+			})
+		}
+		if isMod {
+			isRoot := filepath.Dir(src) == root
+			fixes = append(fixes, func(data []byte) ([]byte, error) {
+				return fixGoMod(data, src, srcMod, dstMod, opts.GoVersion, isRoot)
+			})
+		}
+		if isWork {
+			fixes = append(fixes, func(data []byte) ([]byte, error) {
+				return fixGoWork(data, src, srcMod, dstMod, opts.GoVersion)
+			})
+		}
+		if isSum {
+			fixes = append(fixes, func(data []byte) ([]byte, error) {
+				return fixGoSum(data, srcMod), nil
+			})
+		}
+		if isCodegen {
+			fixes = append(fixes, func(data []byte) ([]byte, error) {
+				return rewriteText(data, srcMod, dstMod, opts), nil
+			})
+		}
+		if isText {
+			fixes = append(fixes, func(data []byte) ([]byte, error) {
+				if bytes.IndexByte(data, 0) >= 0 {
+					return data, nil // binary
+				}
+				return rewriteText(data, srcMod, dstMod, opts), nil
+			})
+		}
+		jobs = append(jobs, rewriteJob{src, rel, d, fixes})
+		return nil
+	})
+	if walkErr != nil {
+		return nil, nil, "", walkErr
+	}
+	if opts.RenameUses && !importsOnly {
+		// Whether the new name of an imported package is free in a file
+		// depends on the names declared in the rest of its package,
+		// which must be read before any of its files is rewritten.
+		decls = packageDecls(jobs, opts.Jobs)
+	}
+
+	// The first file that cannot be rewritten stops the others from starting;
+	// the errors of any already in progress are reported too.
+	n := workers(opts.Jobs)
+	work := make(chan rewriteJob)
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range work {
+				done := true
+				for _, fix := range job.fixes {
+					err := rewrite(job.src, job.rel, job.d, fix)
+					if err == errSkipFile {
+						done = false
+						break // the file was skipped, so its other fixes would be too
+					}
+					if err != nil {
+						mu.Lock()
+						errs = append(errs, err)
+						mu.Unlock()
+						failed.Store(true)
+						done = false
+						break
+					}
+				}
+				mu.Lock()
+				unchanged := done && !rewritten[filepath.ToSlash(job.rel)]
+				mu.Unlock()
+				if unchanged {
+					opts.logf("checked %s: unchanged", filepath.ToSlash(job.rel))
+				}
+			}
+		}()
+	}
+	for _, job := range jobs {
+		if failed.Load() || ctx.Err() != nil {
+			break
+		}
+		work <- job
+	}
+	close(work)
+	wg.Wait()
+
+	if len(errs) == 0 && ctx.Err() != nil {
+		return nil, nil, "", ctx.Err()
+	}
+	return rewritten, skipped, gitdir, errors.Join(errs...)
+}
+
+// workers returns the number of files to work on in parallel
+// for RewriteOptions.Jobs set to jobs.
+func workers(jobs int) int {
+	if jobs <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return jobs
+}
+
+// writeFile replaces the contents of the file name with data, giving it
+// mode perm. It writes a temporary file beside it and renames that into
+// place, so that the file is never left half-written, as it otherwise
+// could be by an interrupted rewrite of a module in place.
+func writeFile(name string, data []byte, perm fs.FileMode) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// fixVendor prepares the vendor directory dir, which is otherwise not
+// rewritten, for a change of module path from srcMod to dstMod. If its
+// modules.txt lists modules within srcMod, it moves their copies to the
+// directory of the same modules within dstMod, and returns the
+// slash-separated path, relative to dir, that holds them, which rewriteTree
+// is to rewrite, along with modules.txt. Otherwise it returns "".
+func fixVendor(dir, srcMod, dstMod string, opts *RewriteOptions) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "modules.txt"))
+	if err != nil {
+		return "", nil // not a module vendor directory
+	}
+	found := false
+	for _, line := range strings.Split(string(data), "\n") {
+		if p, ok := strings.CutPrefix(line, "# "); ok && (strings.HasPrefix(p, srcMod+"/") || strings.HasPrefix(p, srcMod+" ")) {
+			found = true
+		}
+	}
+	if !found {
+		return "", nil
+	}
+	old := filepath.Join(dir, filepath.FromSlash(srcMod))
+	new := filepath.Join(dir, filepath.FromSlash(dstMod))
+	if _, err := os.Stat(old); err == nil && old != new {
+		opts.logf("moving vendored copies of %s to %s", srcMod, dstMod)
+		// Go by way of a temporary name, in case one path is within the other.
+		tmp, err := os.MkdirTemp(dir, ".gonew-")
+		if err != nil {
+			return "", err
+		}
+		if err := os.Rename(old, filepath.Join(tmp, "m")); err != nil {
+			return "", err
+		}
+		for d := filepath.Dir(old); d != dir; d = filepath.Dir(d) {
+			if os.Remove(d) != nil {
+				break // not empty
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(new), 0777); err != nil {
+			return "", err
+		}
+		if err := os.Rename(filepath.Join(tmp, "m"), new); err != nil {
+			return "", err
+		}
+		if err := os.Remove(tmp); err != nil {
+			return "", err
+		}
+	}
+	return dstMod, nil
+}
+
+// inVendor reports whether the file or directory at src is to be rewritten,
+// given the vendor directories being rewritten in part: an entry outside
+// them is, and one inside one of them is only if it is its modules.txt or
+// lies on the way to or within its copy of the source module.
+func inVendor(vendors map[string]string, src string, dir bool) bool {
+	for vdir, own := range vendors {
+		rel, err := filepath.Rel(vdir, src)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		return rel == "." || rel == "modules.txt" || rel == own || strings.HasPrefix(rel, own+"/") ||
+			dir && strings.HasPrefix(own, rel+"/")
+	}
+	return true
+}
+
+// A parseError reports a Go file that does not parse, even as far as its
+// imports. Unless RewriteOptions.Strict is set, the file is copied as is.
+type parseError struct {
+	err error
+}
+
+func (e *parseError) Error() string {
+	return fmt.Sprintf("parsing source module:\n%s", e.err)
+}
+
+// errSkipFile stops the rewriting of a file that has been skipped.
+var errSkipFile = errors.New("skip file")
+
+// A rewriteJob is a file for rewriteTree to rewrite, along with the
+// rewrites to apply to it in order.
+type rewriteJob struct {
+	src, rel string
+	d        fs.DirEntry
+	fixes    []func([]byte) ([]byte, error)
+}
+
+// A primaryPackage identifies the package that takes on the name of the
+// destination module.
+type primaryPackage struct {
+	dir  string // slash-separated directory relative to the module root
+	name string // package name in the source module; "" if there is none
+}
+
+// findPrimary locates the primary package of the module rooted at root.
+// The primary package is the shallowest non-main package whose name matches
+// the conventional package name for srcMod, preferring the root directory
+// itself; for github.com/example/hello/v2, that is a package hello.
+// If there is no such package but the root directory holds exactly one
+// non-main package, that package is primary; so is the only one of the
+// files without a //go:build line, if one guarded by a build tag, such as
+// a tools.go file in package tools, is in a package of its own.
+// Files are considered whatever their build constraints, since the
+// template may be generating a module for any GOOS and GOARCH.
+func findPrimary(root, srcMod string, opts *RewriteOptions) primaryPackage {
+	srcName := guessPackageName(srcMod)
+	names := make(map[string]map[string]bool) // dir -> package names
+	untagged := make(map[string]bool)         // package names in the root directory's files without //go:build lines
+	filepath.WalkDir(root, func(src string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" || src != root && opts.skipDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(d.Name(), ".go") {
+			return nil
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), src, nil, parser.PackageClauseOnly|parser.ParseComments)
+		if err != nil {
+			return nil
+		}
+		name := f.Name.Name
+		if name == "main" || strings.HasSuffix(name, "_test") {
+			return nil
+		}
+		rel, err := filepath.Rel(root, filepath.Dir(src))
+		if err != nil {
+			return nil
+		}
+		dir := filepath.ToSlash(rel)
+		if names[dir] == nil {
+			names[dir] = make(map[string]bool)
+		}
+		names[dir][name] = true
+		if dir == "." && !hasBuildConstraint(f) {
+			untagged[name] = true
+		}
+		return nil
+	})
+
+	var best string
+	found := false
+	for dir, pkgs := range names {
+		if !pkgs[srcName] {
+			continue
+		}
+		if !found || depth(dir) < depth(best) || depth(dir) == depth(best) && dir < best {
+			best, found = dir, true
+		}
+	}
+	if found {
+		return primaryPackage{dir: best, name: srcName}
+	}
+	for _, pkgs := range []map[string]bool{names["."], untagged} {
+		if len(pkgs) == 1 {
+			for name := range pkgs {
+				return primaryPackage{dir: ".", name: name}
+			}
+		}
+	}
+	return primaryPackage{}
+}
+
+// hasBuildConstraint reports whether the file f, parsed with its comments,
+// has a //go:build or // +build line before its package clause.
+func hasBuildConstraint(f *ast.File) bool {
+	for _, g := range f.Comments {
+		if g.Pos() >= f.Package {
+			break
+		}
+		for _, c := range g.List {
+			if constraint.IsGoBuild(c.Text) || constraint.IsPlusBuild(c.Text) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// depth returns the number of elements in the slash-separated directory dir.
+func depth(dir string) int {
+	if dir == "." {
+		return 0
+	}
+	return strings.Count(dir, "/") + 1
+}
+
+// fixGo rewrites the Go source in data to replace srcMod with dstMod.
+// isPrimary indicates whether the file belongs to the primary package,
+// in which case we also rename the package to match dstMod.
+// Build constraints are deliberately ignored: a file such as foo_windows.go
+// is rewritten the same way on every host, so a template instantiates fully
+// no matter which platform gonew runs on.
+// Import paths matching one of opts.Renames are rewritten as well,
+// with the longest matching prefix taking precedence.
+func fixGo(data []byte, file string, srcMod, dstMod string, primary primaryPackage, isPrimary bool, decls map[pkgKey]map[string]bool, opts *RewriteOptions) ([]byte, error) {
+	// Parse only through the imports. Templates sometimes contain files
+	// whose bodies hold placeholder syntax that does not parse, and those
+	// files must still have their imports rewritten. Any rewrite that needs
+	// the full syntax tree must fall back to imports-only rewriting when the
+	// full parse fails.
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, data, parser.ImportsOnly)
+	if err != nil {
+		return nil, &parseError{err}
+	}
+
+	buf := edit.NewBuffer(data)
+	at := func(p token.Pos) int {
+		return fset.File(p).Offset(p)
+	}
+
+	dstName := packageName(dstMod)
+	if isPrimary {
+		if name := f.Name.Name; name == primary.name || name == primary.name+"_test" {
+			if dstName == "" {
+				opts.logf("%s: leaving package %s alone: %s gives no valid package name", file, name, dstMod)
+			} else {
+				dname := dstName + strings.TrimPrefix(name, primary.name)
+				buf.Replace(at(f.Name.Pos()), at(f.Name.End()), dname)
+				opts.logf("%s: renaming package %s to %s", file, name, dname)
+			}
+		}
+	}
+	if dstName == "" {
+		// The primary package keeps its name, so its importers need no alias.
+		dstName = primary.name
+	}
+
+	primaryPath := srcMod
+	if primary.dir != "." {
+		primaryPath = srcMod + "/" + primary.dir
+	}
+	declared := decls[pkgKey{filepath.Dir(file), f.Name.Name}]
+	renames := append([]Rename{{srcMod, dstMod}}, opts.Renames...)
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+
+		// Change import path to begin with the new module path
+		newPath, r, ok := mapImportPath(path, renames)
+		if !ok {
+			continue
+		}
+
+		// spec.Path spans exactly the quoted import path, so replacing it
+		// leaves any comment on the import line untouched. Any package rename
+		// goes into the same replacement rather than a separate insertion at
+		// the same offset, so the edits can never overlap.
+		text := strconv.Quote(newPath)
+		if r.Old == srcMod && primary.name != "" && path == primaryPath && primary.name != dstName && spec.Name == nil {
+			// Add package rename because source code uses original name.
+			// The renaming looks strange, but template authors are unlikely to
+			// create a template where the primary package is imported by packages
+			// in subdirectories, and the renaming at least keeps the code working.
+			// With RenameUses, rename the uses of the package identifier in the
+			// file instead, unless that would collide with another name.
+			if offs, ok := packageUses(file, data, path, primary.name, dstName, declared); opts.RenameUses && ok {
+				renameUses(buf, offs, primary.name, dstName)
+				opts.logf("%s: renaming uses of package %s to %s", file, primary.name, dstName)
+			} else {
+				text = primary.name + " " + text
+			}
+		} else if r.Old != srcMod && path == r.Old && spec.Name == nil {
+			// Likewise for a renamed module whose last element changes.
+			if old, new := guessPackageName(r.Old), guessPackageName(r.New); old != new && token.IsIdentifier(old) {
+				if offs, ok := packageUses(file, data, path, old, new, declared); opts.RenameUses && ok {
+					renameUses(buf, offs, old, new)
+					opts.logf("%s: renaming uses of package %s to %s", file, old, new)
+				} else {
+					text = old + " " + text
+				}
+			}
+		}
+		buf.Replace(at(spec.Path.Pos()), at(spec.Path.End()), text)
+		opts.logf("%s: rewriting import %s to %s", file, spec.Path.Value, text)
+	}
+	return buf.Bytes(), nil
+}
+
+// renameUses replaces the identifier old at each of offs in buf with new.
+func renameUses(buf *edit.Buffer, offs []int, old, new string) {
+	for _, off := range offs {
+		buf.Replace(off, off+len(old), new)
+	}
+}
+
+// packageUses returns the offsets in the Go source data of the identifiers
+// referring to the package imported from importPath, without a name, as old,
+// so that they can be renamed to new. It reports ok == false if they cannot
+// be: because the file does not parse in full, or has a dot import, or
+// because new is not a valid identifier, is the name of another import or of
+// something in declared, the names declared at package level in the file's
+// package, or would then refer to the package where it means something else:
+// a variable, say, in scope at one of the uses, or a predeclared identifier
+// used elsewhere in the file. Scopes are resolved with go/types, checking the
+// file alone; the imported packages are stubs, since they are not at hand.
+func packageUses(file string, data []byte, importPath, old, new string, declared map[string]bool) (offs []int, ok bool) {
+	if !token.IsIdentifier(new) || declared == nil || declared[new] {
+		return nil, false
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, data, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, false
+	}
+	var imp *ast.ImportSpec
+	for _, spec := range f.Imports {
+		p, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return nil, false
+		}
+		name := guessPackageName(p)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if name == new || name == "." {
+			return nil, false
+		}
+		if p == importPath && spec.Name == nil {
+			imp = spec
+		}
+	}
+	if imp == nil {
+		return nil, false
+	}
+
+	conf := types.Config{
+		Importer:    stubImporter{importPath, old},
+		FakeImportC: true,
+		Error:       func(error) {}, // the rest of the package is missing
+	}
+	info := &types.Info{
+		Uses:      make(map[*ast.Ident]types.Object),
+		Implicits: make(map[ast.Node]types.Object),
+		Scopes:    make(map[ast.Node]*types.Scope),
+	}
+	pkg, _ := conf.Check(f.Name.Name, fset, []*ast.File{f}, info)
+	pkgName, _ := info.Implicits[imp].(*types.PkgName)
+	if pkg == nil || pkgName == nil {
+		return nil, false
+	}
+	for id, obj := range info.Uses {
+		switch {
+		case obj == pkgName:
+			scope := pkg.Scope().Innermost(id.Pos())
+			if scope == nil {
+				return nil, false
+			}
+			if _, other := scope.LookupParent(new, id.Pos()); other != nil && other.Parent() != pkg.Scope() {
+				return nil, false
+			}
+			offs = append(offs, fset.Position(id.Pos()).Offset)
+		case id.Name == new && obj.Parent() == types.Universe:
+			return nil, false
+		}
+	}
+	sort.Ints(offs)
+	return offs, true
+}
+
+// A stubImporter imports every package as an empty one named after its
+// path, except for the one at path, which it names name, so that go/types
+// can resolve the names in a file without the packages it imports.
+type stubImporter struct {
+	path, name string
+}
+
+func (s stubImporter) Import(p string) (*types.Package, error) {
+	name := guessPackageName(p)
+	if p == s.path {
+		name = s.name
+	}
+	pkg := types.NewPackage(p, name)
+	pkg.MarkComplete()
+	return pkg, nil
+}
+
+// A pkgKey identifies a package by its directory and name, since a
+// directory may also hold an external test package.
+type pkgKey struct {
+	dir, name string
+}
+
+// packageDecls returns the names declared at package level in each
+// package that the Go files among jobs make up, parsing up to n of them,
+// as for RewriteOptions.Jobs, in parallel. A package one of whose files
+// does not parse is given a nil set, since its names are unknown.
+func packageDecls(jobs []rewriteJob, n int) map[pkgKey]map[string]bool {
+	var (
+		mu     sync.Mutex // guards decls and broken
+		decls  = make(map[pkgKey]map[string]bool)
+		broken []pkgKey
+		wg     sync.WaitGroup
+	)
+	work := make(chan string)
+	for range workers(n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for src := range work {
+				fset := token.NewFileSet()
+				f, err := parser.ParseFile(fset, src, nil, parser.SkipObjectResolution)
+				if f == nil || f.Name == nil {
+					continue // not even a package clause
+				}
+				key := pkgKey{filepath.Dir(src), f.Name.Name}
+				names := topLevelNames(f)
+				mu.Lock()
+				if err != nil {
+					broken = append(broken, key)
+				} else if decls[key] == nil {
+					decls[key] = names
+				} else {
+					for name := range names {
+						decls[key][name] = true
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, job := range jobs {
+		if strings.HasSuffix(job.src, ".go") {
+			work <- job.src
+		}
+	}
+	close(work)
+	wg.Wait()
+	for _, key := range broken {
+		decls[key] = nil
+	}
+	return decls
+}
+
+// topLevelNames returns the names f declares at package level,
+// other than those of methods.
+func topLevelNames(f *ast.File) map[string]bool {
+	names := make(map[string]bool)
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil {
+				names[d.Name.Name] = true
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					names[spec.Name.Name] = true
+				case *ast.ValueSpec:
+					for _, id := range spec.Names {
+						names[id.Name] = true
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+// fixGoStrings rewrites each string literal in the Go source in data whose
+// value is exactly srcMod to have the value dstMod instead, so that code
+// identifying itself by module path, such as const Module = "example.com/m",
+// stays correct. Literals that merely contain srcMod are left alone.
+// fixGoStrings works on tokens rather than a syntax tree,
+// so it handles files whose bodies do not parse.
+func fixGoStrings(data []byte, file, srcMod, dstMod string) []byte {
+	fset := token.NewFileSet()
+	tf := fset.AddFile(file, -1, len(data))
+	var s scanner.Scanner
+	s.Init(tf, data, nil, 0)
+	buf := edit.NewBuffer(data)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok != token.STRING {
+			continue
+		}
+		if v, err := strconv.Unquote(lit); err != nil || v != srcMod {
+			continue
+		}
+		text := strconv.Quote(dstMod)
+		if strings.HasPrefix(lit, "`") {
+			text = "`" + dstMod + "`"
+		}
+		off := tf.Offset(pos)
+		buf.Replace(off, off+len(lit), text)
+	}
+	return buf.Bytes()
+}
+
+// fixGoComments rewrites the module path srcMod to dstMod in the
+// //go:generate directives in the Go source in data, such as
+// //go:generate go run example.com/m/tools/gen, whose commands would
+// otherwise run the template's code, or fail to build. If all is set,
+// it rewrites every other comment as well, such as the
+// "Code generated by example.com/m/tools/gen. DO NOT EDIT." header
+// and doc comments naming packages. Like fixGoStrings,
+// it works on tokens, so it handles files whose bodies do not parse.
+func fixGoComments(data []byte, file, srcMod, dstMod string, all bool) []byte {
+	fset := token.NewFileSet()
+	tf := fset.AddFile(file, -1, len(data))
+	var s scanner.Scanner
+	s.Init(tf, data, nil, scanner.ScanComments)
+	buf := edit.NewBuffer(data)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok != token.COMMENT || !all && !strings.HasPrefix(lit, "//go:generate ") {
+			continue
+		}
+		if text := replaceModPath([]byte(lit), srcMod, dstMod); !bytes.Equal(text, []byte(lit)) {
+			off := tf.Offset(pos)
+			buf.Replace(off, off+len(lit), string(text))
+		}
+	}
+	return buf.Bytes()
+}
+
+// fixGoMod rewrites the go.mod content in data to replace srcMod with dstMod
+// in the module path, and in the paths of require, replace, exclude, and
+// tool directives that name srcMod or a module or package within it;
+// retract directives name only versions, so they need no change.
+// The go.mod of a nested module,
+// one that is not in the root directory, is given the path within dstMod
+// corresponding to its path within srcMod, as in a multi-module workspace;
+// if its path is not within srcMod, it is left alone. It also drops any requirement on
+// dstMod itself, which the rename would otherwise turn into an invalid
+// self-requirement. If goVersion is not empty, fixGoMod sets the go
+// directive, and the toolchain line if there is one, to that version.
+// If there is nothing to change, fixGoMod returns data unchanged;
+// if the module statement cannot be rewritten, it returns an error
+// rather than leave the original module path in place.
+func fixGoMod(data []byte, file, srcMod, dstMod, goVersion string, isRoot bool) ([]byte, error) {
+	// ParseLax ignores replace directives, so prefer a full parse,
+	// falling back for go.mod files using directives it does not know.
+	f, err := modfile.Parse(file, data, nil)
+	if err != nil {
+		f, err = modfile.ParseLax(file, data, nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing source module:\n%s", err)
+	}
+	if f.Module == nil {
+		return nil, fmt.Errorf("%s: missing module statement", file)
+	}
+	renames := []Rename{{Old: srcMod, New: dstMod}}
+	newMod := dstMod
+	if !isRoot {
+		newMod = f.Module.Mod.Path
+		if p, _, ok := mapImportPath(newMod, renames); ok {
+			newMod = p
+		}
+	}
+	changed := false
+	rename := func(p *string, line *modfile.Line) {
+		if newPath, _, ok := mapImportPath(*p, renames); ok && newPath != *p {
+			renameToken(line, *p, newPath)
+			*p = newPath
+			changed = true
+		}
+	}
+	for _, r := range f.Require {
+		rename(&r.Mod.Path, r.Syntax)
+	}
+	for _, r := range f.Replace {
+		rename(&r.Old.Path, r.Syntax)
+		rename(&r.New.Path, r.Syntax)
+	}
+	for _, x := range f.Exclude {
+		rename(&x.Mod.Path, x.Syntax)
+	}
+	for _, t := range f.Tool {
+		rename(&t.Path, t.Syntax)
+	}
+	if goVersion != "" && (f.Go == nil || f.Go.Version != goVersion) {
+		if err := f.AddGoStmt(goVersion); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		changed = true
+	}
+	if goVersion != "" && f.Toolchain != nil && f.Toolchain.Name != "go"+goVersion {
+		if err := f.AddToolchainStmt("go" + goVersion); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		changed = true
+	}
+	selfRequire := false
+	for _, r := range f.Require {
+		if r.Mod.Path == newMod {
+			selfRequire = true
+		}
+	}
+	if f.Module.Mod.Path == newMod && !selfRequire && !changed {
+		return data, nil
+	}
+	if err := f.AddModuleStmt(newMod); err != nil {
+		return nil, fmt.Errorf("%s: rewriting module statement: %v", file, err)
+	}
+	if selfRequire {
+		if err := f.DropRequire(newMod); err != nil {
+			return nil, fmt.Errorf("%s: dropping requirement on %s: %v", file, newMod, err)
+		}
+		f.Cleanup()
+	}
+	new, err := f.Format()
+	if err != nil {
+		return nil, fmt.Errorf("%s: formatting rewritten go.mod: %v", file, err)
+	}
+	if err := checkGoMod(new, file, newMod); err != nil {
+		return nil, err
+	}
+	return new, nil
+}
+
+// renameToken replaces the module path old with new in the tokens of line,
+// so that the formatted go.mod keeps the line's layout and comments.
+func renameToken(line *modfile.Line, old, new string) {
+	for i, tok := range line.Token {
+		if tok == modfile.AutoQuote(old) {
+			line.Token[i] = modfile.AutoQuote(new)
+		}
+	}
+}
+
+// fixGoWork rewrites the go.work content in data to replace srcMod with
+// dstMod in the paths of its replace directives, and sets its go directive,
+// and toolchain line if there is one, to goVersion if that is not empty.
+// Its use directives name directories, which are left alone.
+func fixGoWork(data []byte, file, srcMod, dstMod, goVersion string) ([]byte, error) {
+	f, err := modfile.ParseWork(file, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("parsing source workspace:\n%s", err)
+	}
+	renames := []Rename{{Old: srcMod, New: dstMod}}
+	changed := false
+	rename := func(p *string, line *modfile.Line) {
+		if newPath, _, ok := mapImportPath(*p, renames); ok && newPath != *p {
+			renameToken(line, *p, newPath)
+			*p = newPath
+			changed = true
+		}
+	}
+	for _, r := range f.Replace {
+		rename(&r.Old.Path, r.Syntax)
+		rename(&r.New.Path, r.Syntax)
+	}
+	if goVersion != "" && (f.Go == nil || f.Go.Version != goVersion) {
+		if err := f.AddGoStmt(goVersion); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		changed = true
+	}
+	if goVersion != "" && f.Toolchain != nil && f.Toolchain.Name != "go"+goVersion {
+		if err := f.AddToolchainStmt("go" + goVersion); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		changed = true
+	}
+	if !changed {
+		return data, nil
+	}
+	new := modfile.Format(f.Syntax)
+	if _, err := modfile.ParseWork(file, new, nil); err != nil {
+		return nil, fmt.Errorf("%s: rewritten go.work is invalid:\n%s", file, err)
+	}
+	return new, nil
+}
+
+// fixGoSum drops from the go.sum or go.work.sum content in data the lines
+// for srcMod and the modules within it, such as the other modules of a
+// multi-module template, which fixGoMod renames. Their checksums are those
+// of the template's modules, which no module within dstMod can match,
+// and the modules replaced by directories of the template need none.
+// If there is nothing to drop, fixGoSum returns data unchanged.
+func fixGoSum(data []byte, srcMod string) []byte {
+	out := make([]byte, 0, len(data))
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		mod, _, _ := bytes.Cut(line, []byte(" "))
+		if string(mod) == srcMod || strings.HasPrefix(string(mod), srcMod+"/") {
+			continue
+		}
+		out = append(out, line...)
+	}
+	if len(out) == len(data) {
+		return data
+	}
+	return out
+}
+
+// checkGoMod checks that the rewritten go.mod content in data declares
+// module dstMod and does not require itself.
+func checkGoMod(data []byte, file, dstMod string) error {
+	f, err := modfile.ParseLax(file, data, nil)
+	if err != nil || f.Module == nil || f.Module.Mod.Path != dstMod {
+		return fmt.Errorf("%s: rewritten go.mod does not declare module %s", file, dstMod)
+	}
+	for _, r := range f.Require {
+		if r.Mod.Path == dstMod {
+			return fmt.Errorf("%s: rewritten go.mod requires itself (%s)", file, dstMod)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,716 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// manyFiles returns a template of module srcMod with pkgs packages of
+// files files each, as for writeTree, every file importing the package
+// before its own.
+func manyFiles(srcMod string, pkgs, files int) map[string]string {
+	tree := map[string]string{"go.mod": "module " + srcMod + "\n\ngo 1.23\n"}
+	for p := range pkgs {
+		imp := ""
+		if p > 0 {
+			imp = fmt.Sprintf("import _ %q\n\n", fmt.Sprintf("%s/pkg%d", srcMod, p-1))
+		}
+		for f := range files {
+			tree[fmt.Sprintf("pkg%d/f%d.go", p, f)] = fmt.Sprintf("package pkg%d\n\n%s// F%d is in %s.\nfunc F%d() string { return %q }\n",
+				p, imp, f, srcMod, f, srcMod)
+		}
+	}
+	return tree
+}
+
+func TestFindPrimary(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  primaryPackage
+	}{
+		{
+			"root",
+			map[string]string{"hello.go": "package hello\n", "cmd/hello/main.go": "package main\n"},
+			primaryPackage{".", "hello"},
+		},
+		{
+			"cmd layout",
+			map[string]string{"cmd/hello/main.go": "package main\n", "pkg/hello/hello.go": "package hello\n", "internal/util/util.go": "package util\n"},
+			primaryPackage{"pkg/hello", "hello"},
+		},
+		{
+			"shallowest",
+			map[string]string{"a/b/hello/hello.go": "package hello\n", "hello/hello.go": "package hello\n"},
+			primaryPackage{"hello", "hello"},
+		},
+		{
+			"sole root package",
+			map[string]string{"lib.go": "package lib\n", "lib_test.go": "package lib_test\n"},
+			primaryPackage{".", "lib"},
+		},
+		{
+			"tagged tools.go",
+			map[string]string{"lib.go": "package lib\n", "tools.go": "//go:build tools\n\npackage tools\n"},
+			primaryPackage{".", "lib"},
+		},
+		{
+			"only main",
+			map[string]string{"main.go": "package main\n"},
+			primaryPackage{},
+		},
+	}
+	for _, tt := range tests {
+		root := t.TempDir()
+		writeTree(t, root, tt.files)
+		if got := findPrimary(root, "github.com/example/hello", &RewriteOptions{}); got != tt.want {
+			t.Errorf("%s: findPrimary = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCloneRenamesRootPackage(t *testing.T) {
+	tmpl := t.TempDir()
+	writeTree(t, tmpl, map[string]string{
+		"go.mod":               "module github.com/example/hello\n",
+		"hello.go":             "// Package hello greets.\npackage hello\n",
+		"hello_test.go":        "package hello\n",
+		"example_test.go":      "package hello_test\n\nimport \"github.com/example/hello\"\n\nvar _ = hello.X\n",
+		"internal/hello/x.go":  "package hello\n",
+		"cmd/hello/main.go":    "package main\n",
+		"tools/go.mod":         "module github.com/example/hello/tools\n",
+		"tools/hello/hello.go": "package hello\n",
+	})
+	dir := cloneLocal(t, Options{SrcRepo: tmpl, DstMod: "example.com/myprog"})
+	checkTree(t, dir, map[string]string{
+		"hello.go":             "// Package hello greets.\npackage myprog\n",
+		"hello_test.go":        "package myprog\n",
+		"example_test.go":      "package myprog_test\n\nimport hello \"example.com/myprog\"\n\nvar _ = hello.X\n",
+		"internal/hello/x.go":  "package hello\n",
+		"cmd/hello/main.go":    "package main\n",
+		"tools/go.mod":         "module example.com/myprog/tools\n",
+		"tools/hello/hello.go": "package hello\n",
+	})
+}
+
+func TestCloneRootPackageName(t *testing.T) {
+	tests := []struct {
+		dstMod, name string
+	}{
+		{"example.com/my-prog", "myprog"},
+		{"example.com/prog/v2", "prog"},
+		{"gopkg.in/yaml.v3", "yaml"},
+		{"example.com/2fa", "hello"}, // no valid name, so the package keeps its own
+	}
+	tmpl := t.TempDir()
+	writeTree(t, tmpl, map[string]string{
+		"go.mod":            "module github.com/example/hello\n",
+		"hello.go":          "package hello\n",
+		"hello_test.go":     "package hello_test\n",
+		"cmd/hello/main.go": "package main\n\nimport \"github.com/example/hello\"\n\nvar _ = hello.X\n",
+	})
+	for _, tt := range tests {
+		t.Run(tt.dstMod, func(t *testing.T) {
+			dir := cloneLocal(t, Options{SrcRepo: tmpl, DstMod: tt.dstMod})
+			alias := "hello "
+			if tt.name == "hello" {
+				alias = ""
+			}
+			checkTree(t, dir, map[string]string{
+				"hello.go":          "package " + tt.name + "\n",
+				"hello_test.go":     "package " + tt.name + "_test\n",
+				"cmd/hello/main.go": "package main\n\nimport " + alias + strconv.Quote(tt.dstMod) + "\n\nvar _ = hello.X\n",
+			})
+		})
+	}
+}
+
+func TestClonePrimaryInSubdir(t *testing.T) {
+	tmpl := t.TempDir()
+	writeTree(t, tmpl, map[string]string{
+		"go.mod":              "module github.com/example/hello\n",
+		"cmd/hello/main.go":   "package main\n\nimport \"github.com/example/hello/pkg/hello\"\n\nfunc main() { hello.Hello() }\n",
+		"pkg/hello/hello.go":  "package hello\n\nfunc Hello() {}\n",
+		"pkg/hello/x_test.go": "package hello_test\n",
+	})
+	dir := cloneLocal(t, Options{SrcRepo: tmpl, DstMod: "example.com/myprog"})
+	checkTree(t, dir, map[string]string{
+		"cmd/hello/main.go":   "package main\n\nimport hello \"example.com/myprog/pkg/hello\"\n\nfunc main() { hello.Hello() }\n",
+		"pkg/hello/hello.go":  "package myprog\n\nfunc Hello() {}\n",
+		"pkg/hello/x_test.go": "package myprog_test\n",
+	})
+}
+
+func TestClonePrimaryVersioned(t *testing.T) {
+	tmpl := t.TempDir()
+	writeTree(t, tmpl, map[string]string{
+		"go.mod":                "module github.com/example/hello/v2\n",
+		"cmd/hello/main.go":     "package main\n\nimport \"github.com/example/hello/v2/pkg/hello\"\n\nfunc main() { hello.Hello() }\n",
+		"pkg/hello/hello.go":    "package hello\n\nfunc Hello() {}\n",
+		"internal/util/util.go": "package util\n",
+	})
+	dir := cloneLocal(t, Options{SrcRepo: tmpl, DstMod: "example.com/myprog"})
+	checkTree(t, dir, map[string]string{
+		"cmd/hello/main.go":     "package main\n\nimport hello \"example.com/myprog/pkg/hello\"\n\nfunc main() { hello.Hello() }\n",
+		"pkg/hello/hello.go":    "package myprog\n\nfunc Hello() {}\n",
+		"internal/util/util.go": "package util\n",
+	})
+}
+
+func TestFixGoImportComments(t *testing.T) {
+	in := `package main
+
+import (
+	"fmt" // not ours
+
+	"github.com/example/hello"     // the root, renamed
+	"github.com/example/hello/foo" // used for X
+	/* before */ "github.com/example/hello/bar" /* after */
+	h "github.com/example/hello/baz" // aliased already
+)
+
+import "github.com/example/hello/qux" // alone
+`
+	want := `package main
+
+import (
+	"fmt" // not ours
+
+	hello "example.com/myprog"     // the root, renamed
+	"example.com/myprog/foo" // used for X
+	/* before */ "example.com/myprog/bar" /* after */
+	h "example.com/myprog/baz" // aliased already
+)
+
+import "example.com/myprog/qux" // alone
+`
+	primary := primaryPackage{".", "hello"}
+	got, err := fixGo([]byte(in), "main.go", "github.com/example/hello", "example.com/myprog", primary, false, nil, &RewriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("fixGo:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestClonePlatformFiles(t *testing.T) {
+	tmpl := t.TempDir()
+	// The files import the primary package, so that they need an alias,
+	// whichever platform the test runs on.
+	src := "package main\n\nimport \"github.com/example/hello\"\n\nvar _ = hello.Greeting\n"
+	want := "package main\n\nimport hello \"example.com/myprog\"\n\nvar _ = hello.Greeting\n"
+	writeTree(t, tmpl, map[string]string{
+		"go.mod":                    "module github.com/example/hello\n",
+		"hello.go":                  "package hello\n\nconst Greeting = \"hi\"\n",
+		"hello_windows.go":          "package hello\n",
+		"hello_plan9_arm.go":        "package hello\n",
+		"cmd/hello/main_windows.go": src,
+		"cmd/hello/main_linux.go":   src,
+		"cmd/hello/main_darwin.go":  src,
+	})
+	dir := cloneLocal(t, Options{SrcRepo: tmpl, DstMod: "example.com/myprog"})
+	checkTree(t, dir, map[string]string{
+		"hello.go":                  "package myprog\n\nconst Greeting = \"hi\"\n",
+		"hello_windows.go":          "package myprog\n",
+		"hello_plan9_arm.go":        "package myprog\n",
+		"cmd/hello/main_windows.go": want,
+		"cmd/hello/main_linux.go":   want,
+		"cmd/hello/main_darwin.go":  want,
+	})
+}
+
+func TestCloneTaggedFiles(t *testing.T) {
+	tmpl := t.TempDir()
+	writeTree(t, tmpl, map[string]string{
+		"go.mod":   "module github.com/example/hello\n",
+		"hello.go": "package hello\n\nconst Greeting = \"hi\"\n",
+		"hello_integration_test.go": "//go:build integration\n\npackage hello_test\n\n" +
+			"import \"github.com/example/hello\"\n\nvar _ = hello.Greeting\n",
+		"hello_test.go": "package hello_test\n\nimport \"github.com/example/hello\"\n\nvar _ = hello.Greeting\n",
+		"tools.go":      "//go:build tools\n\npackage tools\n\nimport _ \"github.com/example/hello/internal/gen\"\n",
+		"cgo.go": "package hello\n\n// #include <stdio.h>\nimport \"C\"\n\n" +
+			"import _ \"github.com/example/hello/internal/gen\"\n",
+		"internal/gen/gen.go": "package gen\n",
+	})
+	dir := cloneLocal(t, Options{SrcRepo: tmpl, DstMod: "example.com/myprog"})
+	checkTree(t, dir, map[string]string{
+		"hello.go": "package myprog\n\nconst Greeting = \"hi\"\n",
+		"hello_integration_test.go": "//go:build integration\n\npackage myprog_test\n\n" +
+			"import hello \"example.com/myprog\"\n\nvar _ = hello.Greeting\n",
+		"hello_test.go": "package myprog_test\n\nimport hello \"example.com/myprog\"\n\nvar _ = hello.Greeting\n",
+		"tools.go":      "//go:build tools\n\npackage tools\n\nimport _ \"example.com/myprog/internal/gen\"\n",
+		"cgo.go": "package myprog\n\n// #include <stdio.h>\nimport \"C\"\n\n" +
+			"import _ \"example.com/myprog/internal/gen\"\n",
+	})
+}
+
+func TestMaxFileSize(t *testing.T) {
+	root := t.TempDir()
+	big := "package hello\n\nimport _ \"github.com/example/hello/api\"\n\n// " + strings.Repeat("x", 200) + "\n"
+	bigText := "github.com/example/hello " + strings.Repeat("x", 200) + "\n"
+	writeTree(t, root, map[string]string{
+		"go.mod":    "module github.com/example/hello\n",
+		"small.go":  "package hello\n\nimport _ \"github.com/example/hello/api\"\n",
+		"big.go":    big,
+		"README.md": bigText,
+	})
+	var warnings []string
+	opts := &RewriteOptions{
+		MaxFileSize: 100,
+		RewriteExt:  []string{"md"},
+		Warnf:       func(format string, args ...any) { warnings = append(warnings, fmt.Sprintf(format, args...)) },
+		Jobs:        1,
+	}
+	rewritten, skipped, _, err := rewriteTree(context.Background(), root, "github.com/example/hello", "example.com/hi", opts, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTree(t, root, map[string]string{
+		"small.go":  "package hi\n\nimport _ \"example.com/hi/api\"\n",
+		"big.go":    big,
+		"README.md": bigText,
+	})
+	if rewritten["big.go"] || rewritten["README.md"] || !rewritten["small.go"] {
+		t.Errorf("rewritten = %v, want small.go and not big.go or README.md", rewritten)
+	}
+	want := []SkippedFile{{"README.md", SkipTooLarge}, {"big.go", SkipTooLarge}}
+	if got := skipped.sorted(); !slices.Equal(got, want) {
+		t.Errorf("skipped = %v, want %v", got, want)
+	}
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "exceeds the size limit of 100 bytes") {
+		t.Errorf("warnings = %q, want two saying the files exceed the limit", warnings)
+	}
+}
+
+func TestCloneBrokenBody(t *testing.T) {
+	tmpl := t.TempDir()
+	// A placeholder body does not parse, but the imports before it do,
+	// and RenameUses, which needs the whole file, falls back to an alias.
+	writeTree(t, tmpl, map[string]string{
+		"go.mod":   "module github.com/example/hello\n",
+		"hello.go": "package hello\n",
+		"cmd/hello/main.go": `package main
+
+import (
+	"github.com/example/hello"
+	"github.com/example/hello/api"
+)
+
+func main() {
+	hello.{{.Method}}(api.X)
+}
+`,
+	})
+	dir := cloneLocal(t, Options{SrcRepo: tmpl, DstMod: "example.com/myprog", RewriteOptions: RewriteOptions{RenameUses: true}})
+	checkTree(t, dir, map[string]string{
+		"hello.go": "package myprog\n",
+		"cmd/hello/main.go": `package main
+
+import (
+	hello "example.com/myprog"
+	"example.com/myprog/api"
+)
+
+func main() {
+	hello.{{.Method}}(api.X)
+}
+`,
+	})
+}
+
+func TestUnreadable(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("permissions do not stop this user from reading files")
+	}
+	for _, strict := range []bool{false, true} {
+		root := t.TempDir()
+		writeTree(t, root, map[string]string{
+			"go.mod":           "module github.com/example/hello\n",
+			"hello.go":         "package hello\n\nimport _ \"github.com/example/hello/api\"\n",
+			"secret/secret.go": "package secret\n",
+			"locked/locked.go": "package locked\n",
+		})
+		if err := os.Chmod(filepath.Join(root, "secret"), 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(filepath.Join(root, "locked/locked.go"), 0); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.Chmod(filepath.Join(root, "secret"), 0777) })
+		var warnings []string
+		opts := &RewriteOptions{
+			Strict: strict,
+			Warnf:  func(format string, args ...any) { warnings = append(warnings, fmt.Sprintf(format, args...)) },
+		}
+		_, skipped, _, err := rewriteTree(context.Background(), root, "github.com/example/hello", "example.com/hi", opts, false)
+		if strict {
+			if err == nil || !errors.Is(err, fs.ErrPermission) {
+				t.Errorf("strict: rewriteTree = %v, want a permission error", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkTree(t, root, map[string]string{"hello.go": "package hi\n\nimport _ \"example.com/hi/api\"\n"})
+		want := []SkippedFile{{"locked/locked.go", SkipUnreadable}, {"secret", SkipUnreadable}}
+		if got := skipped.sorted(); !slices.Equal(got, want) {
+			t.Errorf("skipped = %v, want %v", got, want)
+		}
+		if len(warnings) != 2 {
+			t.Errorf("warnings = %q, want one for each unreadable file and directory", warnings)
+		}
+	}
+}
+
+func TestFixGoStrings(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`const Module = "github.com/example/hello"`, `const Module = "example.com/hi"`},
+		{"const Module = `github.com/example/hello`", "const Module = `example.com/hi`"},
+		{`var m = "github.com/example/hello/api"`, `var m = "github.com/example/hello/api"`},
+		{`var m = "see github.com/example/hello"`, `var m = "see github.com/example/hello"`},
+		{`var m = "github.com/example/hellothere"`, `var m = "github.com/example/hellothere"`},
+		{`var m = "github.com/example/\x68ello"`, `var m = "example.com/hi"`},
+		{`// "github.com/example/hello"`, `// "github.com/example/hello"`},
+		{`var r = 'g'; var m = {{"github.com/example/hello"}}`, `var r = 'g'; var m = {{"example.com/hi"}}`},
+	}
+	for _, tt := range tests {
+		in := "package hello\n\n" + tt.in + "\n"
+		got := string(fixGoStrings([]byte(in), "hello.go", "github.com/example/hello", "example.com/hi"))
+		if want := "package hello\n\n" + tt.want + "\n"; got != want {
+			t.Errorf("fixGoStrings(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCloneRewriteStrings(t *testing.T) {
+	tmpl := t.TempDir()
+	writeTree(t, tmpl, map[string]string{
+		"go.mod":     "module github.com/example/hello\n",
+		"version.go": "package hello\n\nconst Module = \"github.com/example/hello\"\n",
+	})
+	for _, rewrite := range []bool{false, true} {
+		dir := cloneLocal(t, Options{SrcRepo: tmpl, DstMod: "example.com/hi", RewriteOptions: RewriteOptions{RewriteStrings: rewrite}})
+		want := "package hi\n\nconst Module = \"github.com/example/hello\"\n"
+		if rewrite {
+			want = "package hi\n\nconst Module = \"example.com/hi\"\n"
+		}
+		checkTree(t, dir, map[string]string{"version.go": want})
+	}
+}
+
+func TestFixGoMod(t *testing.T) {
+	tests := []struct {
+		name   string
+		isRoot bool
+		in     string
+		want   string
+	}{
+		{
+			"root", true,
+			"module github.com/example/hello\n\ngo 1.23\n\nrequire github.com/example/hello/api v0.1.0\n\nreplace github.com/example/hello/api => ./api\n",
+			"module example.com/hi\n\ngo 1.23\n\nrequire example.com/hi/api v0.1.0\n\nreplace example.com/hi/api => ./api\n",
+		},
+		{
+			"nested", false,
+			"module github.com/example/hello/api\n\ngo 1.23\n\nrequire github.com/example/hello v0.1.0 // indirect\n",
+			"module example.com/hi/api\n\ngo 1.23\n\nrequire example.com/hi v0.1.0 // indirect\n",
+		},
+		{
+			"nested elsewhere", false,
+			"module github.com/other/tool\n\ngo 1.23\n\nrequire github.com/example/hello v0.1.0\n",
+			"module github.com/other/tool\n\ngo 1.23\n\nrequire example.com/hi v0.1.0\n",
+		},
+		{
+			"unchanged", false,
+			"module github.com/other/tool\n\ngo 1.23\n",
+			"module github.com/other/tool\n\ngo 1.23\n",
+		},
+	}
+	for _, tt := range tests {
+		got, err := fixGoMod([]byte(tt.in), "go.mod", "github.com/example/hello", "example.com/hi", "", tt.isRoot)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: fixGoMod:\n%s\nwant:\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFixGoModReplace(t *testing.T) {
+	in := `module github.com/example/hello
+
+go 1.23
+
+require (
+	github.com/example/hello/internal v0.0.0 // local
+	github.com/example/hellothere v1.0.0
+	golang.org/x/mod v0.20.0
+)
+
+replace github.com/example/hello/internal => ./internal
+
+replace golang.org/x/mod => github.com/example/hello/third_party/mod v0.20.1
+
+replace github.com/example/hello/tools v1.0.0 => ../tools
+
+exclude github.com/example/hello/internal v0.0.1
+`
+	want := `module example.com/hi
+
+go 1.23
+
+require (
+	example.com/hi/internal v0.0.0 // local
+	github.com/example/hellothere v1.0.0
+	golang.org/x/mod v0.20.0
+)
+
+replace example.com/hi/internal => ./internal
+
+replace golang.org/x/mod => example.com/hi/third_party/mod v0.20.1
+
+replace example.com/hi/tools v1.0.0 => ../tools
+
+exclude example.com/hi/internal v0.0.1
+`
+	got, err := fixGoMod([]byte(in), "go.mod", "github.com/example/hello", "example.com/hi", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("fixGoMod:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFixGoModSelfRequire(t *testing.T) {
+	tests := []struct {
+		name   string
+		isRoot bool
+		in     string
+		want   string
+	}{
+		{
+			"template requires itself", true,
+			"module github.com/example/hello\n\ngo 1.23\n\nrequire (\n\tgithub.com/example/hello v0.1.0\n\tgolang.org/x/mod v0.20.0\n)\n",
+			"module example.com/hi\n\ngo 1.23\n\nrequire golang.org/x/mod v0.20.0\n",
+		},
+		{
+			"destination already required", true,
+			"module github.com/example/hello\n\ngo 1.23\n\nrequire example.com/hi v1.0.0\n",
+			"module example.com/hi\n\ngo 1.23\n",
+		},
+		{
+			"nested module requiring its renamed self", false,
+			"module github.com/example/hello/api\n\ngo 1.23\n\nrequire (\n\tgithub.com/example/hello v0.1.0\n\tgithub.com/example/hello/api v0.1.0\n)\n",
+			"module example.com/hi/api\n\ngo 1.23\n\nrequire example.com/hi v0.1.0\n",
+		},
+	}
+	for _, tt := range tests {
+		got, err := fixGoMod([]byte(tt.in), "go.mod", "github.com/example/hello", "example.com/hi", "", tt.isRoot)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: fixGoMod:\n%s\nwant:\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckGoMod(t *testing.T) {
+	tests := []struct {
+		data, err string
+	}{
+		{"module example.com/hi\n\nrequire golang.org/x/mod v0.20.0\n", ""},
+		{"module example.com/other\n", "does not declare module example.com/hi"},
+		{"go 1.23\n", "does not declare module example.com/hi"},
+		{"module example.com/hi\n\nrequire example.com/hi v1.0.0\n", "requires itself"},
+	}
+	for _, tt := range tests {
+		err := checkGoMod([]byte(tt.data), "go.mod", "example.com/hi")
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("checkGoMod(%q) = %v, want error %q", tt.data, err, tt.err)
+		}
+	}
+}
+
+func TestFixGoModMalformed(t *testing.T) {
+	tests := []struct {
+		in, err string
+	}{
+		{"go 1.23\n", "missing module statement"},
+		{"module \"github.com/example/hello\n", "parsing source module"},
+		{"module github.com/example/hello extra\n", "parsing source module"},
+		{"module (\n", "parsing source module"},
+	}
+	for _, tt := range tests {
+		got, err := fixGoMod([]byte(tt.in), "go.mod", "github.com/example/hello", "example.com/hi", "", true)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("fixGoMod(%q) = %q, %v, want error %q", tt.in, got, err, tt.err)
+		}
+	}
+
+	tmpl := t.TempDir()
+	writeTree(t, tmpl, map[string]string{
+		"go.mod":     "module github.com/example/hello\n",
+		"api/go.mod": "module \"github.com/example/hello/api\n",
+	})
+	_, err := CloneContext(context.Background(), Options{SrcRepo: tmpl, DstMod: "example.com/hi", Dir: filepath.Join(t.TempDir(), "out")})
+	if err == nil || !strings.Contains(err.Error(), "parsing source module") {
+		t.Errorf("cloning a template with a malformed nested go.mod: %v, want a parse error", err)
+	}
+}
+
+func TestFixGoWork(t *testing.T) {
+	tests := []struct {
+		name, goVersion, in, want string
+	}{
+		{
+			"replace", "",
+			"go 1.23\n\nuse (\n\t.\n\t./api\n)\n\nreplace github.com/example/hello/api => ./api\n",
+			"go 1.23\n\nuse (\n\t.\n\t./api\n)\n\nreplace example.com/hi/api => ./api\n",
+		},
+		{
+			"go version", "1.24",
+			"go 1.23\n\nuse .\n",
+			"go 1.24\n\nuse .\n",
+		},
+		{
+			"unchanged", "",
+			"go 1.23\n\nuse ./hello\n\nreplace github.com/other/x => ../x\n",
+			"go 1.23\n\nuse ./hello\n\nreplace github.com/other/x => ../x\n",
+		},
+	}
+	for _, tt := range tests {
+		got, err := fixGoWork([]byte(tt.in), "go.work", "github.com/example/hello", "example.com/hi", tt.goVersion)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: fixGoWork:\n%s\nwant:\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCloneMultiModule(t *testing.T) {
+	tmpl := t.TempDir()
+	writeTree(t, tmpl, map[string]string{
+		"go.mod":        "module github.com/example/hello\n\ngo 1.23\n\nrequire github.com/example/hello/api v0.0.0\n\nreplace github.com/example/hello/api => ./api\n",
+		"go.work":       "go 1.23\n\nuse (\n\t.\n\t./api\n)\n",
+		"hello.go":      "package hello\n\nimport _ \"github.com/example/hello/api\"\n",
+		"api/go.mod":    "module github.com/example/hello/api\n\ngo 1.23\n",
+		"api/api.go":    "package api\n",
+		"api/v2/go.mod": "module github.com/example/hello/api/v2\n\ngo 1.23\n\nrequire github.com/example/hello/api v0.0.0\n",
+	})
+	dir := cloneLocal(t, Options{SrcRepo: tmpl, DstMod: "example.com/hi"})
+	checkTree(t, dir, map[string]string{
+		"go.mod":        "module example.com/hi\n\ngo 1.23\n\nrequire example.com/hi/api v0.0.0\n\nreplace example.com/hi/api => ./api\n",
+		"go.work":       "go 1.23\n\nuse (\n\t.\n\t./api\n)\n",
+		"hello.go":      "package hi\n\nimport _ \"example.com/hi/api\"\n",
+		"api/go.mod":    "module example.com/hi/api\n\ngo 1.23\n",
+		"api/v2/go.mod": "module example.com/hi/api/v2\n\ngo 1.23\n\nrequire example.com/hi/api v0.0.0\n",
+	})
+}
+
+func TestFixGoSum(t *testing.T) {
+	const other = "golang.org/x/mod v0.20.0 h1:abc=\ngolang.org/x/mod v0.20.0/go.mod h1:def=\n"
+	tests := []struct {
+		name, in, want string
+	}{
+		{"nothing to drop", other, other},
+		{
+			"srcMod",
+			"github.com/example/hello v1.0.0 h1:x=\n" + other + "github.com/example/hello v1.0.0/go.mod h1:y=\n",
+			other,
+		},
+		{
+			"within srcMod",
+			"github.com/example/hello/api v0.1.0 h1:x=\n" + other + "github.com/example/hello/api/v2 v2.0.0/go.mod h1:y=\n",
+			other,
+		},
+		{
+			"srcMod prefix",
+			"github.com/example/hellox v1.0.0 h1:x=\n" + other,
+			"github.com/example/hellox v1.0.0 h1:x=\n" + other,
+		},
+		{"no final newline", other + "github.com/example/hello v1.0.0 h1:x=", other},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := []byte(tt.in)
+			got := fixGoSum(in, "github.com/example/hello")
+			if string(got) != tt.want {
+				t.Errorf("fixGoSum:\n%s\nwant:\n%s", got, tt.want)
+			}
+			if tt.in == tt.want && &got[0] != &in[0] {
+				t.Errorf("fixGoSum copied data with nothing to drop")
+			}
+		})
+	}
+}
+
+func TestCloneGoSum(t *testing.T) {
+	const ext = "golang.org/x/mod v0.20.0 h1:abc=\ngolang.org/x/mod v0.20.0/go.mod h1:def=\n"
+	const self = "github.com/example/hello/api v0.1.0 h1:x=\ngithub.com/example/hello/api v0.1.0/go.mod h1:y=\n"
+	tmpl := t.TempDir()
+	writeTree(t, tmpl, map[string]string{
+		"go.mod":      "module github.com/example/hello\n\ngo 1.23\n\nrequire github.com/example/hello/api v0.1.0\n",
+		"go.sum":      self + ext,
+		"go.work":     "go 1.23\n\nuse (\n\t.\n\t./api\n)\n",
+		"go.work.sum": ext + self,
+		"hello.go":    "package hello\n",
+		"api/go.mod":  "module github.com/example/hello/api\n\ngo 1.23\n",
+		"api/go.sum":  ext,
+	})
+	dir := cloneLocal(t, Options{SrcRepo: tmpl, DstMod: "example.com/hi"})
+	checkTree(t, dir, map[string]string{
+		"go.mod":      "module example.com/hi\n\ngo 1.23\n\nrequire example.com/hi/api v0.1.0\n",
+		"go.sum":      ext,
+		"go.work.sum": ext,
+		"api/go.sum":  ext,
+	})
+}
+
+func BenchmarkRewriteTree(b *testing.B) {
+	tree := manyFiles("github.com/example/big", 50, 40)
+	for _, bb := range []struct {
+		name string
+		jobs int
+	}{
+		{"serial", 1},
+		{"parallel", 0},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				root := filepath.Join(b.TempDir(), "big")
+				writeTree(b, root, tree)
+				b.StartTimer()
+				opts := &RewriteOptions{Jobs: bb.jobs}
+				if _, _, _, err := rewriteTree(context.Background(), root, "github.com/example/big", "example.com/big", opts, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"path/filepath"
+	"sort"
+)
+
+// A SkipReason is a short code explaining why a file was not rewritten.
+type SkipReason string
+
+const (
+	SkipNotIncluded SkipReason = "not-included" // matched no RewriteOptions.Include glob
+	SkipTooLarge    SkipReason = "too-large"    // larger than RewriteOptions.MaxFileSize
+	SkipSymlink     SkipReason = "symlink"      // a symbolic link, never written through
+	SkipUnreadable  SkipReason = "unreadable"   // could not be read for lack of permission
+	SkipExcludedDir SkipReason = "excluded-dir" // a directory named in RewriteOptions.SkipDirs
+	SkipParseError  SkipReason = "parse-error"  // a Go file that does not parse
+)
+
+// A skipReport records the files that the rewrite pass skipped.
+type skipReport struct {
+	entries []SkippedFile
+}
+
+// A SkippedFile is a file that would otherwise have been rewritten
+// but was copied unchanged.
+type SkippedFile struct {
+	Path   string     `json:"path"`   // slash-separated, relative to the module root
+	Reason SkipReason `json:"reason"` // why the file was skipped
+}
+
+// add records that the file at rel, relative to the module root,
+// was skipped for the given reason.
+func (r *skipReport) add(rel string, reason SkipReason) {
+	r.entries = append(r.entries, SkippedFile{filepath.ToSlash(rel), reason})
+}
+
+// sorted returns the skipped files, sorted by path.
+func (r *skipReport) sorted() []SkippedFile {
+	sort.Slice(r.entries, func(i, j int) bool {
+		return r.entries[i].Path < r.entries[j].Path
+	})
+	return r.entries
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A Submodule is a git submodule of a template, at the commit the
+// template pins it to.
+type Submodule struct {
+	Path   string `json:"path"`   // slash-separated, relative to the template root
+	Commit string `json:"commit"` // commit the template pins
+}
+
+// updateSubmodules checks out the submodules of the clone in dir, and
+// theirs in turn, at the commits it pins, adding env to git's environment.
+// Unless full is set, it fetches only those commits, falling back to
+// whole clones if the host does not serve single commits.
+// Submodules already checked out are left alone.
+func updateSubmodules(ctx context.Context, dir string, full bool, env []string, logf func(string, ...any)) error {
+	if _, err := os.Stat(filepath.Join(dir, ".gitmodules")); err != nil {
+		return nil
+	}
+	run := func(args ...string) error {
+		logf("running git %s", strings.Join(args, " "))
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+		if env != nil {
+			cmd.Env = append(os.Environ(), env...)
+		}
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("git %s: %v\n%s", strings.Join(args, " "), err, stderr.Bytes())
+		}
+		return nil
+	}
+	args := []string{"submodule", "update", "--init", "--recursive"}
+	if !full {
+		if run(append(args, "--depth", "1")...) == nil {
+			return nil
+		}
+		logf("shallow submodule update failed; retrying with full clones")
+	}
+	return run(args...)
+}
+
+// submodules returns the submodules of the clone in dir, recursively,
+// sorted by path, or nil if it has none or is not a git repository.
+func submodules(ctx context.Context, dir string) ([]Submodule, error) {
+	if _, err := os.Stat(filepath.Join(dir, ".gitmodules")); err != nil {
+		return nil, nil
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		return nil, nil
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", "-C", dir, "submodule", "status", "--recursive")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git submodule status: %v\n%s", err, stderr.Bytes())
+	}
+	// Each line is a status character, the commit, the path,
+	// and, for a submodule checked out, a description in parentheses.
+	var list []Submodule
+	for _, line := range strings.Split(string(out), "\n") {
+		if len(line) < 2 {
+			continue
+		}
+		commit, rest, _ := strings.Cut(line[1:], " ")
+		path, _, _ := strings.Cut(rest, " (")
+		list = append(list, Submodule{Path: filepath.ToSlash(path), Commit: commit})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list, nil
+}
+
+// flattenSubmodules turns the submodules subs of the clone in dir into
+// plain directories, removing the .git file each has and the .gitmodules
+// files declaring them, so that their files become part of the new module.
+// It removes the directory of a submodule that was never checked out,
+// which git leaves empty, along with any parents that leaves empty,
+// and returns their paths.
+func flattenSubmodules(dir string, subs []Submodule) (empty []string, err error) {
+	for _, s := range subs {
+		sub := filepath.Join(dir, filepath.FromSlash(s.Path))
+		if err := os.RemoveAll(filepath.Join(sub, ".git")); err != nil {
+			return nil, err
+		}
+		if err := os.Remove(filepath.Join(sub, ".gitmodules")); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if entries, err := os.ReadDir(sub); err == nil && len(entries) == 0 {
+			empty = append(empty, s.Path)
+			if err := os.Remove(sub); err != nil {
+				return nil, err
+			}
+			for d := filepath.Dir(sub); d != dir; d = filepath.Dir(d) {
+				if os.Remove(d) != nil {
+					break // not empty
+				}
+			}
+		}
+	}
+	if err := os.Remove(filepath.Join(dir, ".gitmodules")); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return empty, nil
+}
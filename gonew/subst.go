@@ -0,0 +1,140 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// placeholderRE matches a template placeholder such as {{.Author}}.
+var placeholderRE = regexp.MustCompile(`\{\{\s*\.([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// ReadVars reads an answers file giving template variables, a YAML or JSON
+// mapping from variable names to values, such as
+//
+//	Author: Jane Doe
+//	Description: A tool for greeting people.
+//
+// or a file in the full form [ReadAnswers] reads, of which it returns
+// just the variables.
+func ReadVars(file string) (map[string]string, error) {
+	a, err := ReadAnswers(file)
+	if err != nil {
+		return nil, err
+	}
+	return a.Vars, nil
+}
+
+// substVars returns the variables available to placeholders in a module
+// with path dstMod: the automatic ModulePath, ProjectName, and Year,
+// overridden by any of the same name in vars, and the rest of vars.
+func substVars(dstMod string, vars map[string]string) map[string]string {
+	m := map[string]string{
+		"ModulePath":  dstMod,
+		"ProjectName": guessPackageName(dstMod),
+		"Year":        strconv.Itoa(time.Now().Year()),
+	}
+	for k, v := range vars {
+		m[k] = v
+	}
+	return m
+}
+
+// substituteTree replaces each placeholder in the text files of the tree
+// rooted at root with the value of its variable in vars, adding the files
+// it changes to rewritten. A placeholder naming an unknown variable is an
+// error, unless allowMissing is set, in which case it is left alone.
+// If only is not nil, only the files matching one of its globs are changed.
+func substituteTree(root string, vars map[string]string, only []string, allowMissing bool, opts *RewriteOptions, rewritten map[string]bool) error {
+	var errs []error
+	err := walkText(root, only, opts, rewritten, func(rel string, data []byte) []byte {
+		if !placeholderRE.Match(data) {
+			return data
+		}
+		var new []byte
+		last := 0
+		for _, m := range placeholderRE.FindAllSubmatchIndex(data, -1) {
+			name := string(data[m[2]:m[3]])
+			v, ok := vars[name]
+			if !ok {
+				if !allowMissing {
+					line := 1 + bytes.Count(data[:m[0]], []byte("\n"))
+					errs = append(errs, fmt.Errorf("%s:%d: unknown template variable %s", rel, line, name))
+				}
+				continue
+			}
+			new = append(new, data[last:m[0]]...)
+			new = append(new, v...)
+			last = m[1]
+		}
+		return append(new, data[last:]...)
+	})
+	if err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// walkText calls edit for each text file in the tree rooted at root, with
+// its slash-separated path relative to root and its content, and writes
+// back the content edit returns, adding the file to rewritten, if it differs.
+// If only is not nil, only the files matching one of its globs are edited.
+// Binary files, symbolic links, files larger than opts.MaxFileSize, the
+// directories in opts.SkipDirs, and the .git directory are skipped.
+func walkText(root string, only []string, opts *RewriteOptions, rewritten map[string]bool, edit func(rel string, data []byte) []byte) error {
+	return filepath.WalkDir(root, func(src string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" || src != root && opts.skipDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, src)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if only != nil && !matchAny(only, rel) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if opts.MaxFileSize > 0 && info.Size() > opts.MaxFileSize {
+			return nil
+		}
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		if bytes.IndexByte(data, 0) >= 0 {
+			return nil // binary
+		}
+		new := edit(rel, data)
+		if bytes.Equal(new, data) {
+			return nil
+		}
+		if err := writeFile(src, new, info.Mode().Perm()); err != nil {
+			return err
+		}
+		rewritten[rel] = true
+		return nil
+	})
+}
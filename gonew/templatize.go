@@ -0,0 +1,272 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// TemplatizeOptions controls [Templatize].
+type TemplatizeOptions struct {
+	RewriteOptions
+
+	// Parameterize makes the manifest replace the candidate placeholders
+	// Templatize finds with template variables. Otherwise it only lists
+	// them, commented out, for the template's author to review.
+	Parameterize bool
+}
+
+// A TemplatizeResult describes the template [Templatize] created.
+type TemplatizeResult struct {
+	Dir           string      `json:"dir"`           // absolute path of the template root
+	Module        string      `json:"module"`        // module path of the project and the template
+	Files         []string    `json:"files"`         // files copied, slash-separated and relative to Dir, in sorted order
+	Removed       []string    `json:"removed"`       // environment-specific files and directories left out, in sorted order
+	Candidates    []Candidate `json:"candidates"`    // strings that would become placeholders
+	Parameterized bool        `json:"parameterized"` // whether the manifest replaces the Candidates
+}
+
+// A Candidate is a string of a project that Templatize proposes to
+// replace with the template variable Var, such as the name of the
+// application with ProjectName.
+type Candidate struct {
+	Var   string   `json:"var"`
+	Value string   `json:"value"`
+	Files []string `json:"files"` // files mentioning Value other than in the module path, in sorted order
+}
+
+// envFiles lists the names of files and directories that belong to one
+// checkout of a project, such as editor settings and build output, rather
+// than to the project, and that Templatize therefore leaves out.
+var envFiles = []string{".DS_Store", "Thumbs.db", ".idea", ".vscode", "node_modules", ".terraform", "coverage.out", RecordFile}
+
+// Templatize creates, in the new directory out, a template from the
+// existing project in dir, the root of a module: a copy of the project,
+// with a starter gonew.yaml manifest describing it. The copy leaves out
+// the .git directory, any file git ignores, if dir is a git repository,
+// and files that belong to one checkout or that usually hold credentials,
+// such as .vscode, .env, and private keys, which Result.Removed lists.
+//
+// The module path needs no placeholder, since [Clone] rewrites it anyway.
+// Templatize proposes as placeholders the name of the project, the last
+// element of the module path, for the ProjectName variable, and the
+// organization, the element after a host such as github.com, for an Org
+// variable, wherever the project's text files mention them other than in
+// that path. The manifest declares Org and lists a replacement for each,
+// as whole words in all their case variants, commented out unless
+// opts.Parameterize is set.
+func Templatize(ctx context.Context, dir, out string, opts TemplatizeOptions) (*TemplatizeResult, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not the root of a module: %v", root, err)
+	}
+	mod := modfile.ModulePath(data)
+	if mod == "" {
+		return nil, fmt.Errorf("%s has no module statement", filepath.Join(root, "go.mod"))
+	}
+	for _, name := range manifestFiles {
+		if _, err := os.Stat(filepath.Join(root, name)); err == nil {
+			return nil, fmt.Errorf("%s is already a template: it has %s", root, name)
+		}
+	}
+	final, err := filepath.Abs(out)
+	if err != nil {
+		return nil, err
+	}
+	if rel, err := filepath.Rel(root, final); err == nil && filepath.IsLocal(rel) {
+		return nil, fmt.Errorf("destination %s is inside project %s", final, root)
+	}
+	if _, err := checkDest(final, false); err != nil {
+		return nil, err
+	}
+
+	files, err := projectFiles(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	res := &TemplatizeResult{Dir: final, Module: mod, Parameterized: opts.Parameterize}
+	removed := make(map[string]bool)
+	for _, name := range files {
+		if drop := envPath(name); drop != "" {
+			removed[drop] = true
+			continue
+		}
+		res.Files = append(res.Files, name)
+	}
+	for name := range removed {
+		opts.logf("leaving out %s", name)
+		res.Removed = append(res.Removed, name)
+	}
+	sort.Strings(res.Removed)
+
+	if err := os.MkdirAll(final, 0777); err != nil {
+		return nil, err
+	}
+	for _, name := range res.Files {
+		src, dst := filepath.Join(root, filepath.FromSlash(name)), filepath.Join(final, filepath.FromSlash(name))
+		if err := addFile(src, dst); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range candidates(mod) {
+		rp, err := newReplacer(Replacement{Old: c.Value, Words: true, Cases: true})
+		if err != nil {
+			return nil, err
+		}
+		err = walkText(final, nil, &opts.RewriteOptions, nil, func(rel string, data []byte) []byte {
+			if rp.re.Match(replaceModPath(data, mod, "")) {
+				c.Files = append(c.Files, rel)
+			}
+			return data
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(c.Files) > 0 {
+			sort.Strings(c.Files)
+			res.Candidates = append(res.Candidates, c)
+		}
+	}
+
+	opts.logf("writing gonew.yaml")
+	if err := os.WriteFile(filepath.Join(final, "gonew.yaml"), starterManifest(mod, res.Candidates, opts.Parameterize), 0666); err != nil {
+		return nil, err
+	}
+	res.Files = append(res.Files, "gonew.yaml")
+	sort.Strings(res.Files)
+	return res, nil
+}
+
+// projectFiles returns the slash-separated names of the files of the
+// project in root, relative to it, in sorted order: if it is a git
+// repository, those git tracks or does not ignore, and otherwise all
+// of them, except for those in the .git directory.
+func projectFiles(ctx context.Context, root string) ([]string, error) {
+	if _, err := os.Stat(filepath.Join(root, ".git")); err != nil {
+		return listFiles(root)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", "-C", root, "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-files: %v\n%s", err, stderr.Bytes())
+	}
+	var files []string
+	for _, name := range strings.Split(string(out), "\x00") {
+		if name == "" {
+			continue
+		}
+		// A file deleted but not yet committed is still listed,
+		// and a submodule is listed as a directory.
+		info, err := os.Lstat(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			sub, err := listFiles(filepath.Join(root, filepath.FromSlash(name)))
+			if err != nil {
+				return nil, err
+			}
+			for _, s := range sub {
+				if path.Base(s) != ".git" { // the submodule's own
+					files = append(files, name+"/"+s)
+				}
+			}
+			continue
+		}
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	return slices.Compact(files), nil
+}
+
+// envPath returns the file or directory, the slash-separated name or one
+// of its parents, that makes name environment-specific, or "" if none does.
+func envPath(name string) string {
+	elems := strings.Split(name, "/")
+	for i, e := range elems {
+		if slices.Contains(envFiles, e) || i == len(elems)-1 && isSecretFile(e) {
+			return strings.Join(elems[:i+1], "/")
+		}
+	}
+	return ""
+}
+
+// candidates returns the strings of the module mod that Templatize
+// proposes as placeholders, without their files.
+func candidates(mod string) []Candidate {
+	name := guessPackageName(mod)
+	list := []Candidate{{Var: "ProjectName", Value: name}}
+	// On a code host, the element after the host names the organization.
+	if elems := strings.Split(mod, "/"); len(elems) >= 3 && strings.Contains(elems[0], ".") {
+		if org := elems[1]; len(org) >= 3 && org != name {
+			list = append(list, Candidate{Var: "Org", Value: org})
+		}
+	}
+	return list
+}
+
+// starterManifest returns the gonew.yaml Templatize writes for a template
+// of the module mod with the placeholders cands, commented out unless on.
+func starterManifest(mod string, cands []Candidate, on bool) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# The manifest of this template, created by gonew templatize from\n")
+	fmt.Fprintf(&buf, "# %s. Gonew rewrites the module path itself.\n", mod)
+	fmt.Fprintf(&buf, "description: %s\n", strconv.Quote("A template created from "+mod))
+	if len(cands) == 0 {
+		return buf.Bytes()
+	}
+	comment := "# "
+	if on {
+		comment = ""
+	}
+	if !on {
+		fmt.Fprintf(&buf, "\n# Uncomment these lines to turn the strings below into placeholders.\n")
+	}
+	for _, c := range cands {
+		if c.Var == "Org" {
+			fmt.Fprintf(&buf, "%svars:\n", comment)
+			fmt.Fprintf(&buf, "%s  - name: Org\n", comment)
+			fmt.Fprintf(&buf, "%s    description: organization owning the new module\n", comment)
+			fmt.Fprintf(&buf, "%s    default: %s\n", comment, strconv.Quote(c.Value))
+		}
+	}
+	fmt.Fprintf(&buf, "%sreplace:\n", comment)
+	for _, c := range cands {
+		fmt.Fprintf(&buf, "%s  # %s appears in %s\n", comment, c.Value, describeFiles(c.Files))
+		fmt.Fprintf(&buf, "%s  - old: %s\n", comment, strconv.Quote(c.Value))
+		fmt.Fprintf(&buf, "%s    new: %s\n", comment, strconv.Quote("{{."+c.Var+"}}"))
+		fmt.Fprintf(&buf, "%s    words: true\n", comment)
+		fmt.Fprintf(&buf, "%s    cases: true\n", comment)
+	}
+	return buf.Bytes()
+}
+
+// describeFiles lists the first few files, noting how many more there are.
+func describeFiles(files []string) string {
+	const max = 3
+	if len(files) <= max {
+		return strings.Join(files, ", ")
+	}
+	return fmt.Sprintf("%s, and %d more", strings.Join(files[:max], ", "), len(files)-max)
+}
@@ -0,0 +1,147 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"bytes"
+	"strings"
+)
+
+// isCodegenConfig reports whether name is the name of a code generator
+// configuration file that refers to packages by import path.
+func isCodegenConfig(name string) bool {
+	switch name {
+	case "sqlc.yaml", "sqlc.yml", "sqlc.json", // sqlc
+		"entc.go": // ent
+		return true
+	}
+	return false
+}
+
+// TextFiles lists the extensions and names, in the form of
+// RewriteOptions.RewriteExt, of the text files outside Go code in which
+// templates commonly mention their module path: documentation, container
+// and build files, CI workflows, and deployment manifests.
+var TextFiles = []string{
+	"md", "txt",
+	"Dockerfile", "Makefile", "mk", "sh",
+	"yml", "yaml", "toml", "json",
+}
+
+// matchExt reports whether the file name has one of the extensions in exts,
+// given without the leading dot, or is exactly one of them, as in Dockerfile.
+func matchExt(name string, exts []string) bool {
+	for _, ext := range exts {
+		ext = strings.TrimPrefix(strings.TrimSpace(ext), ".")
+		if ext == "" {
+			continue
+		}
+		if name == ext || strings.HasSuffix(name, "."+ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceModPath returns a copy of data in which each occurrence of srcMod
+// as a whole module path, or as the prefix of a package path within it,
+// is replaced by dstMod. An occurrence counts only if it is not part of a
+// longer path element: github.com/a/b matches in "github.com/a/b/c" but not
+// in "github.com/a/bc" or "example.github.com/a/b". A period ending a
+// sentence, with no path element after it, does not extend the path.
+func replaceModPath(data []byte, srcMod, dstMod string) []byte {
+	return replacePaths(data, []Rename{{Old: srcMod, New: dstMod}}, false)
+}
+
+// rewriteText returns a copy of the text file content data with srcMod
+// replaced by dstMod, and the paths of opts.Renames by theirs, as by
+// replaceModPath, and the directories that RenamePaths renamed, written
+// relative to the module root as in "go run ./cmd/hello", by their
+// new names, so that documentation follows the Go files.
+func rewriteText(data []byte, srcMod, dstMod string, opts *RewriteOptions) []byte {
+	renames := append([]Rename{{Old: srcMod, New: dstMod}}, opts.Renames...)
+	data = replacePaths(data, renames, false)
+	if len(opts.pathRenames) > 0 {
+		data = replacePaths(data, opts.pathRenames, true)
+	}
+	return data
+}
+
+// replacePaths returns a copy of data in which each occurrence of the old
+// path of one of renames, delimited as in replaceModPath, is replaced by
+// its new path; where several old paths match, the longest applies.
+// If rel is set, the old paths are relative, as in cmd/hello, and must
+// not instead end a longer path, as in github.com/x/cmd/hello, though
+// they may begin with ./; one of a single element, such as hello, must
+// either begin with ./ or be followed by a slash.
+func replacePaths(data []byte, renames []Rename, rel bool) []byte {
+	var out []byte
+	last := 0 // data[:last] is done: copied to out or replaced
+	for pos := 0; pos < len(data); {
+		i := -1
+		for _, r := range renames {
+			if j := bytes.Index(data[pos:], []byte(r.Old)); j >= 0 && (i < 0 || pos+j < i) {
+				i = pos + j
+			}
+		}
+		if i < 0 {
+			break
+		}
+		best := -1
+		for k, r := range renames {
+			end := i + len(r.Old)
+			if !bytes.HasPrefix(data[i:], []byte(r.Old)) || !pathStart(data, i, rel) || !pathEnd(data, end) {
+				continue
+			}
+			if rel && !strings.Contains(r.Old, "/") && !(end < len(data) && data[end] == '/') && !bytes.HasSuffix(data[:i], []byte("./")) {
+				continue // a single word, as likely prose as a directory
+			}
+			if best < 0 || len(r.Old) > len(renames[best].Old) {
+				best = k
+			}
+		}
+		if best < 0 {
+			pos = i + 1
+			continue
+		}
+		out = append(out, data[last:i]...)
+		out = append(out, renames[best].New...)
+		last = i + len(renames[best].Old)
+		pos = last
+	}
+	return append(out, data[last:]...)
+}
+
+// pathStart reports whether a path can begin at data[i], as in replacePaths.
+func pathStart(data []byte, i int, rel bool) bool {
+	if i == 0 {
+		return true
+	}
+	c := data[i-1]
+	switch {
+	case isPathByte(c):
+		return false
+	case c != '/' || !rel:
+		return true
+	}
+	// A relative path may follow ./ but not another path element.
+	return i >= 2 && data[i-2] == '.' && (i == 2 || !isPathByte(data[i-3]) && data[i-3] != '/')
+}
+
+// pathEnd reports whether a path can end before data[end], as in replacePaths.
+func pathEnd(data []byte, end int) bool {
+	if end == len(data) || !isPathByte(data[end]) {
+		return true
+	}
+	// A full stop.
+	return data[end] == '.' && (end+1 == len(data) || !isPathByte(data[end+1]))
+}
+
+// isPathByte reports whether c can appear within a module path element.
+// The slash separating path elements is deliberately excluded.
+func isPathByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
@@ -0,0 +1,176 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRewriteTextPathRenames(t *testing.T) {
+	opts := &RewriteOptions{
+		Renames: []Rename{
+			{Old: "github.com/example/hello/cmd/hello", New: "example.com/myprog/cmd/myprog"},
+			{Old: "github.com/example/hello/hello", New: "example.com/myprog/myprog"},
+		},
+		pathRenames: []Rename{
+			{Old: "cmd/hello", New: "cmd/myprog"},
+			{Old: "hello", New: "myprog"},
+		},
+	}
+	tests := []struct {
+		in, want string
+	}{
+		{"go install github.com/example/hello/cmd/hello@latest", "go install example.com/myprog/cmd/myprog@latest"},
+		{"import \"github.com/example/hello/internal\"", "import \"example.com/myprog/internal\""},
+		{"go run ./cmd/hello", "go run ./cmd/myprog"},
+		{"see cmd/hello/main.go.", "see cmd/myprog/main.go."},
+		{"COPY cmd/hello /src/cmd/hello", "COPY cmd/myprog /src/cmd/hello"},
+		{"github.com/other/cmd/hello", "github.com/other/cmd/hello"},
+		{"cmd/hello2 and xcmd/hello", "cmd/hello2 and xcmd/hello"},
+		{"ls ./hello hello/x.go", "ls ./myprog myprog/x.go"},
+		{"hello, world", "hello, world"},
+	}
+	for _, tt := range tests {
+		got := string(rewriteText([]byte(tt.in), "github.com/example/hello", "example.com/myprog", opts))
+		if got != tt.want {
+			t.Errorf("rewriteText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRenamePathsRewritesText(t *testing.T) {
+	tmpl := t.TempDir()
+	writeTree(t, tmpl, map[string]string{
+		"go.mod":            "module github.com/example/hello\n",
+		"cmd/hello/main.go": "package main\n",
+		"README.md":         "Run go run ./cmd/hello, or install github.com/example/hello/cmd/hello.\n",
+		"docs/usage.md":     "The command lives in cmd/hello/main.go.\n",
+	})
+	dir := cloneLocal(t, Options{
+		SrcRepo:        tmpl,
+		DstMod:         "example.com/myprog",
+		RenamePaths:    true,
+		RewriteOptions: RewriteOptions{RewriteExt: TextFiles},
+	})
+	checkTree(t, dir, map[string]string{
+		"cmd/myprog/main.go": "package main\n",
+		"README.md":          "Run go run ./cmd/myprog, or install example.com/myprog/cmd/myprog.\n",
+		"docs/usage.md":      "The command lives in cmd/myprog/main.go.\n",
+	})
+}
+
+func TestRewriteCodegen(t *testing.T) {
+	tmpl := t.TempDir()
+	sqlc := `version: "2"
+sql:
+  - engine: postgresql
+    queries: query.sql
+    gen:
+      go:
+        package: db
+        out: internal/db
+        overrides:
+          - db_type: uuid
+            go_type: github.com/example/hello/internal/types.UUID
+          - db_type: text
+            go_type: github.com/example/hellothere/types.Text
+`
+	entc := "//go:build ignore\n\npackage main\n\n// Generates into github.com/example/hello/ent.\nfunc main() { gen(\"github.com/example/hello/ent\") }\n"
+	writeTree(t, tmpl, map[string]string{
+		"go.mod":         "module github.com/example/hello\n",
+		"sqlc.yaml":      sqlc,
+		"ent/entc.go":    entc,
+		"other/sqlc.txt": "github.com/example/hello/internal/types\n",
+	})
+	for _, codegen := range []bool{false, true} {
+		dir := cloneLocal(t, Options{
+			SrcRepo:        tmpl,
+			DstMod:         "example.com/hi",
+			RewriteOptions: RewriteOptions{RewriteCodegen: codegen},
+		})
+		want := map[string]string{
+			"sqlc.yaml":      sqlc,
+			"ent/entc.go":    entc,
+			"other/sqlc.txt": "github.com/example/hello/internal/types\n",
+		}
+		if codegen {
+			want["sqlc.yaml"] = strings.Replace(sqlc, "github.com/example/hello/", "example.com/hi/", 1)
+			want["ent/entc.go"] = strings.ReplaceAll(entc, "github.com/example/hello/", "example.com/hi/")
+		}
+		checkTree(t, dir, want)
+	}
+}
+
+func TestMatchExt(t *testing.T) {
+	exts := []string{"md", ".yml", " Dockerfile ", ""}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"README.md", true},
+		{"ci.yml", true},
+		{"Dockerfile", true},
+		{"build.Dockerfile", true},
+		{"md", true},
+		{"README.mdx", false},
+		{"ci.yaml", false},
+		{"Dockerfile.dev", false},
+		{"main.go", false},
+	}
+	for _, tt := range tests {
+		if got := matchExt(tt.name, exts); got != tt.want {
+			t.Errorf("matchExt(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReplaceModPath(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"COPY . /go/src/github.com/example/hello", "COPY . /go/src/example.com/hi"},
+		{"go install github.com/example/hello/cmd/hello@latest", "go install example.com/hi/cmd/hello@latest"},
+		{"See github.com/example/hello.", "See example.com/hi."},
+		{"github.com/example/hello.v2", "github.com/example/hello.v2"},
+		{"github.com/example/hellothere", "github.com/example/hellothere"},
+		{"example.github.com/example/hello", "example.github.com/example/hello"},
+		{"(github.com/example/hello)", "(example.com/hi)"},
+	}
+	for _, tt := range tests {
+		if got := string(replaceModPath([]byte(tt.in), "github.com/example/hello", "example.com/hi")); got != tt.want {
+			t.Errorf("replaceModPath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRewriteExt(t *testing.T) {
+	tmpl := t.TempDir()
+	binary := "\x00\x01github.com/example/hello\x00"
+	writeTree(t, tmpl, map[string]string{
+		"go.mod":                   "module github.com/example/hello\n",
+		"Dockerfile":               "FROM golang\nCOPY . /go/src/github.com/example/hello\nRUN go install github.com/example/hello/cmd/hello\n",
+		"README.md":                "Install with go install github.com/example/hello/cmd/hello@latest.\n",
+		"logo.md":                  binary,
+		".github/workflows/ci.yml": "run: go test github.com/example/hello/...\n",
+	})
+	dir := cloneLocal(t, Options{
+		SrcRepo:        tmpl,
+		DstMod:         "example.com/hi",
+		RewriteOptions: RewriteOptions{RewriteExt: []string{"md", "Dockerfile"}},
+	})
+	checkTree(t, dir, map[string]string{
+		"Dockerfile":               "FROM golang\nCOPY . /go/src/example.com/hi\nRUN go install example.com/hi/cmd/hello\n",
+		"README.md":                "Install with go install example.com/hi/cmd/hello@latest.\n",
+		"logo.md":                  binary,
+		".github/workflows/ci.yml": "run: go test github.com/example/hello/...\n",
+	})
+
+	// Without RewriteExt, no text file is rewritten.
+	dir = cloneLocal(t, Options{SrcRepo: tmpl, DstMod: "example.com/hi"})
+	checkTree(t, dir, map[string]string{
+		"README.md": "Install with go install github.com/example/hello/cmd/hello@latest.\n",
+	})
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// KeepFile is the name of the file gonew writes into each directory that
+// is empty in a template, so that git, which tracks only files, keeps it.
+const KeepFile = ".gitkeep"
+
+// removeUnsafeLinks removes from the tree rooted at root the symbolic links
+// whose targets are absolute or lie outside the tree, which a new module
+// would otherwise reach out of, and returns their slash-separated paths,
+// relative to root, in walk order. The .git directory is left alone.
+func removeUnsafeLinks(root string) ([]string, error) {
+	var removed []string
+	err := filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		target, err := os.Readlink(file)
+		if err != nil {
+			return err
+		}
+		if !filepath.IsAbs(target) && filepath.IsLocal(filepath.Join(filepath.Dir(rel), target)) {
+			return nil
+		}
+		removed = append(removed, filepath.ToSlash(rel))
+		return os.Remove(file)
+	})
+	return removed, err
+}
+
+// emptyDirs returns the slash-separated paths, relative to root, of the
+// empty directories in the tree rooted there, other than in .git.
+func emptyDirs(root string) ([]string, error) {
+	var dirs []string
+	err := filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		if d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		entries, err := os.ReadDir(file)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 && file != root {
+			rel, err := filepath.Rel(root, file)
+			if err != nil {
+				return err
+			}
+			dirs = append(dirs, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// keepDirs writes a KeepFile into each of the directories dirs, relative
+// to root, that is still there and still empty. The others have since
+// been removed or filled, as by the template's manifest.
+func keepDirs(root string, dirs []string, logf func(string, ...any)) error {
+	for _, dir := range dirs {
+		d := filepath.Join(root, filepath.FromSlash(dir))
+		entries, err := os.ReadDir(d)
+		if errors.Is(err, fs.ErrNotExist) || err == nil && len(entries) > 0 {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		logf("writing %s/%s to keep the empty directory", dir, KeepFile)
+		if err := os.WriteFile(filepath.Join(d, KeepFile), nil, 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,389 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// An UpdateResult describes the outcome of [Update].
+// File names are slash-separated and relative to Dir, in sorted order.
+type UpdateResult struct {
+	Dir     string `json:"dir"`               // absolute path of the module root
+	Version string `json:"version,omitempty"` // template version or commit updated to, if known
+
+	Updated   []string `json:"updated"`   // files changed to match the template, merging in local changes
+	Added     []string `json:"added"`     // files the template added
+	Deleted   []string `json:"deleted"`   // files the template removed, which had no local changes
+	Conflicts []string `json:"conflicts"` // files whose local and template changes conflict
+}
+
+// Update brings the module in dir, created by [Clone] with Record set,
+// up to date with its template. It reads the template and version from
+// dir's RecordFile, recreates the module as it was created then and as it
+// would be created now from opts.Version, which, if empty, means the default
+// branch, and merges the difference between the two into dir, changing
+// the module path in both the same way Clone did.
+//
+// A file changed both in dir and in the template is merged with
+// "git merge-file"; if the changes conflict, the file is left with
+// conflict markers, or, for a binary file or one removed on one side,
+// is left alone, and it is listed in the result's Conflicts.
+// Update then records the new version in RecordFile.
+//
+// Options fields that only make sense when creating a module, such as
+// Dir, DstMod, GitInit, Tidy, and Exec, are ignored; Vars adds to the
+// variables recorded, and Features, if not nil, replaces the features. SrcRepo, if set, replaces the recorded template
+// location, as for a template that has moved, and Overlays, if not nil,
+// replaces the recorded overlays, which are otherwise updated too.
+func Update(ctx context.Context, dir string, opts Options) (*UpdateResult, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	r, err := readRecord(root)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s has no %s; only modules created with a record of their template can be updated", root, RecordFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	template, subdir, _ := strings.Cut(r.Template, "//")
+	if opts.SrcRepo == "" {
+		opts.SrcRepo = template
+		if r.Source != "" {
+			opts.SrcRepo = r.Source
+		}
+	}
+	if !IsLocal(opts.SrcRepo) {
+		opts.Subdir = subdir
+	}
+	opts.Proxy = opts.Proxy || r.Proxy
+	opts.DstMod = r.Module
+	vars := make(map[string]string)
+	for k, v := range r.Vars {
+		vars[k] = v
+	}
+	for k, v := range opts.Vars {
+		vars[k] = v
+	}
+	opts.Vars = vars
+	if opts.Features == nil && r.Features != nil {
+		opts.Features = []string{}
+		for name, on := range r.Features {
+			if on {
+				opts.Features = append(opts.Features, name)
+			}
+		}
+	}
+	opts.Record = true
+	opts.Keep = false
+	opts.KeepGit, opts.RenameOrigin = false, false
+	opts.GitInit, opts.GitCommit, opts.SetOrigin, opts.Push = false, false, false, false
+	opts.Gitignore, opts.GitignoreMerge = false, false
+	opts.Tidy, opts.Format, opts.Verify, opts.RunHooks = false, false, false, false
+	opts.Exec = nil
+	opts.ModTime, opts.TouchModTime = time.Time{}, false
+
+	tmp, err := os.MkdirTemp("", "gonew-update-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	// Recreate the module as it was first created, at the recorded commit
+	// when there is one, since a branch may have moved on since.
+	base := opts
+	base.Dir = filepath.Join(tmp, "base")
+	base.Version = r.Version
+	if r.Commit != "" {
+		base.Version = r.Commit
+	}
+	base.Ask = nil // the answers given then are in r.Vars
+	base.Overlays = make([]Overlay, len(r.Overlays))
+	for i, ov := range r.Overlays {
+		base.Overlays[i] = Overlay{SrcRepo: ov.SrcRepo, Subdir: ov.Subdir, Version: ov.Version}
+		if ov.Commit != "" {
+			base.Overlays[i].Version = ov.Commit
+		}
+	}
+	// The recorded commit pins the original, which was checked, if
+	// asked, when it was created; only the new version needs checking.
+	base.RequireCommit, base.VerifySignature = "", false
+	opts.logf("recreating %s from %s", r.Module, describeVersion(r.Template, base.Version))
+	if _, err := CloneContext(ctx, base); err != nil {
+		return nil, fmt.Errorf("recreating original module: %v", err)
+	}
+
+	next := opts
+	next.Dir = filepath.Join(tmp, "new")
+	if next.Overlays == nil {
+		next.Overlays = make([]Overlay, len(r.Overlays))
+		for i, ov := range r.Overlays {
+			next.Overlays[i] = Overlay{SrcRepo: ov.SrcRepo, Subdir: ov.Subdir, Version: ov.Version}
+		}
+	}
+	opts.logf("creating %s from %s", r.Module, describeVersion(r.Template, next.Version))
+	if _, err := CloneContext(ctx, next); err != nil {
+		return nil, err
+	}
+	nr, err := readRecord(next.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := updateFiles(base.Dir, next.Dir)
+	if err != nil {
+		return nil, err
+	}
+	res := &UpdateResult{Dir: root, Version: nr.Version}
+	if nr.Commit != "" {
+		res.Version = nr.Commit
+	}
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := updateFile(ctx, root, base.Dir, next.Dir, name, res, opts.logf); err != nil {
+			return nil, err
+		}
+	}
+
+	r.Version = nr.Version
+	r.Commit = nr.Commit
+	r.Hash = nr.Hash
+	r.Submodules = nr.Submodules
+	r.Time = nr.Time
+	r.Vars = nr.Vars
+	r.Features = nr.Features
+	r.Overlays = nr.Overlays
+	r.Gonew = gonewVersion()
+	if err := writeRecord(root, *r); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// describeVersion returns template@vers, or just template for an empty vers.
+func describeVersion(template, vers string) string {
+	if vers == "" {
+		return template
+	}
+	return template + "@" + vers
+}
+
+// updateFiles returns the slash-separated names of the regular files in
+// either of the trees base and next, except for the record file, in sorted order.
+func updateFiles(base, next string) ([]string, error) {
+	seen := make(map[string]bool)
+	for _, root := range []string{base, next} {
+		err := filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(root, file)
+			if err != nil {
+				return err
+			}
+			if rel != RecordFile {
+				seen[filepath.ToSlash(rel)] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	var names []string
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// updateFile applies the template's change, from the tree base to the tree
+// next, to the file name in the module root, recording what it did in res.
+func updateFile(ctx context.Context, root, base, next, name string, res *UpdateResult, logf func(string, ...any)) error {
+	rel := filepath.FromSlash(name)
+	old, oldOK, err := readIfExists(filepath.Join(base, rel))
+	if err != nil {
+		return err
+	}
+	theirs, theirsOK, err := readIfExists(filepath.Join(next, rel))
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(root, rel)
+	ours, oursOK, err := readIfExists(file)
+	if err != nil {
+		return err
+	}
+
+	// A change of mode in the template, as for a script made executable,
+	// applies unless the file's mode was changed locally too.
+	if oldOK && theirsOK && oursOK {
+		changed, err := updateMode(file, filepath.Join(base, rel), filepath.Join(next, rel))
+		if err != nil {
+			return err
+		}
+		if changed && (bytes.Equal(ours, theirs) || bytes.Equal(old, theirs)) {
+			logf("updating the mode of %s", name)
+			res.Updated = append(res.Updated, name)
+			return nil
+		}
+	}
+
+	switch {
+	case oldOK && theirsOK && bytes.Equal(old, theirs):
+		return nil // unchanged in the template
+	case oursOK && theirsOK && bytes.Equal(ours, theirs):
+		return nil // already up to date
+	case !theirsOK:
+		// Removed from the template.
+		if !oursOK {
+			return nil
+		}
+		if !bytes.Equal(ours, old) {
+			logf("%s: changed locally but removed from the template", name)
+			res.Conflicts = append(res.Conflicts, name)
+			return nil
+		}
+		logf("removing %s", name)
+		res.Deleted = append(res.Deleted, name)
+		return os.Remove(file)
+	case !oursOK:
+		if oldOK {
+			logf("%s: removed locally but changed in the template", name)
+			res.Conflicts = append(res.Conflicts, name)
+			return nil
+		}
+		logf("adding %s", name)
+		res.Added = append(res.Added, name)
+		info, err := os.Stat(filepath.Join(next, rel))
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+			return err
+		}
+		return os.WriteFile(file, theirs, info.Mode().Perm())
+	case oldOK && bytes.Equal(ours, old):
+		logf("updating %s", name)
+		res.Updated = append(res.Updated, name)
+		return writeKeepMode(file, theirs)
+	}
+
+	// Changed both locally and in the template, or added in both.
+	if bytes.IndexByte(ours, 0) >= 0 || bytes.IndexByte(theirs, 0) >= 0 || bytes.IndexByte(old, 0) >= 0 {
+		logf("%s: binary file changed locally and in the template", name)
+		res.Conflicts = append(res.Conflicts, name)
+		return nil
+	}
+	logf("merging %s", name)
+	merged, clean, err := mergeFile(ctx, name, ours, old, theirs)
+	if err != nil {
+		return err
+	}
+	if clean {
+		res.Updated = append(res.Updated, name)
+	} else {
+		res.Conflicts = append(res.Conflicts, name)
+	}
+	return writeKeepMode(file, merged)
+}
+
+// readIfExists returns the content of file and true,
+// or nil and false if file does not exist.
+func readIfExists(file string) ([]byte, bool, error) {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// updateMode gives file the mode of the file next, if that differs from the
+// mode of the file base and file still has that mode, reporting whether it
+// changed the mode.
+func updateMode(file, base, next string) (bool, error) {
+	var perm [3]fs.FileMode
+	for i, f := range []string{file, base, next} {
+		info, err := os.Stat(f)
+		if err != nil {
+			return false, err
+		}
+		perm[i] = info.Mode().Perm()
+	}
+	if perm[1] == perm[2] || perm[0] != perm[1] {
+		return false, nil
+	}
+	return true, os.Chmod(file, perm[2])
+}
+
+// writeKeepMode replaces the content of the existing file with data,
+// keeping its permissions.
+func writeKeepMode(file string, data []byte) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, info.Mode().Perm())
+}
+
+// mergeFile merges the change from old to theirs into ours using
+// "git merge-file", returning the result and whether it is free of conflicts.
+// Conflicts are marked with the file name and "(local)", "(original)",
+// or "(template)".
+func mergeFile(ctx context.Context, name string, ours, old, theirs []byte) (merged []byte, clean bool, err error) {
+	tmp, err := os.MkdirTemp("", "gonew-merge-")
+	if err != nil {
+		return nil, false, err
+	}
+	defer os.RemoveAll(tmp)
+	var files []string
+	for i, data := range [][]byte{ours, old, theirs} {
+		f := filepath.Join(tmp, fmt.Sprint(i))
+		if err := os.WriteFile(f, data, 0666); err != nil {
+			return nil, false, err
+		}
+		files = append(files, f)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", append([]string{"merge-file", "-p",
+		"-L", name + " (local)", "-L", name + " (original)", "-L", name + " (template)"}, files...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		return stdout.Bytes(), true, nil
+	case errors.As(err, &exit) && exit.ExitCode() > 0 && exit.ExitCode() < 128:
+		// The exit status is the number of conflicts.
+		return stdout.Bytes(), false, nil
+	}
+	return nil, false, fmt.Errorf("git merge-file %s: %v\n%s", name, err, stderr.Bytes())
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonew
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// checkSource reports an error, before anything else is done with it, if the
+// template fetched into dir at commit fails the checks opts asks for.
+func (opts *Options) checkSource(ctx context.Context, dir, commit string) error {
+	if opts.RequireCommit != "" {
+		if commit == "" {
+			return fmt.Errorf("cannot check for commit %s: the template has no git history", opts.RequireCommit)
+		}
+		if !strings.HasPrefix(commit, strings.ToLower(opts.RequireCommit)) {
+			return fmt.Errorf("template is at commit %s, not the required %s", commit, opts.RequireCommit)
+		}
+	}
+	if opts.VerifySignature {
+		if commit == "" {
+			return errors.New("cannot verify the template's signature: it has no git history")
+		}
+		if err := verifySignature(ctx, dir, opts.Version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRequireCommit reports an error if c is not a commit hash,
+// or an unambiguous enough prefix of one.
+func checkRequireCommit(c string) error {
+	if len(c) < 7 || len(c) > 64 || strings.Trim(strings.ToLower(c), "0123456789abcdef") != "" {
+		return fmt.Errorf("invalid required commit %q: want at least 7 hex digits of a commit hash", c)
+	}
+	return nil
+}
+
+// verifySignature checks the signature of the annotated tag vers names
+// in the git repository at dir, or, if vers is empty or not such a tag, of
+// the commit checked out, against the keys git is configured to trust.
+// A lightweight tag has no signature of its own, so its commit must.
+func verifySignature(ctx context.Context, dir, vers string) error {
+	args := []string{"verify-commit", "HEAD"}
+	what := "commit HEAD"
+	out, err := exec.CommandContext(ctx, "git", "-C", dir, "cat-file", "-t", "refs/tags/"+vers).Output()
+	if vers != "" && err == nil && strings.TrimSpace(string(out)) == "tag" {
+		args = []string{"verify-tag", vers}
+		what = "tag " + vers
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%v\n%s", err, msg)
+		}
+		return fmt.Errorf("template %s has no valid signature: %v", what, err)
+	}
+	return nil
+}
+
+// checkSumDB reports an error unless the go command's settings have it
+// check the module mod against the checksum database when downloading it,
+// and the files of the download m, in the module cache, still have the
+// hash the go command verified. The go command does not check the
+// module cache again on later downloads, so a file changed there since
+// would otherwise go unnoticed.
+func checkSumDB(ctx context.Context, mod string, m *proxyModule) error {
+	out, err := exec.CommandContext(ctx, "go", "env", "-json", "GOSUMDB", "GONOSUMDB", "GOPRIVATE").Output()
+	if err != nil {
+		return fmt.Errorf("go env: %v", err)
+	}
+	var env struct{ GOSUMDB, GONOSUMDB, GOPRIVATE string }
+	if err := json.Unmarshal(out, &env); err != nil {
+		return fmt.Errorf("go env: %v", err)
+	}
+	if env.GOSUMDB == "off" {
+		return fmt.Errorf("cannot check %s against the checksum database: GOSUMDB=off", mod)
+	}
+	// GONOSUMDB defaults to GOPRIVATE.
+	name, patterns := "GONOSUMDB", env.GONOSUMDB
+	if patterns == "" {
+		name, patterns = "GOPRIVATE", env.GOPRIVATE
+	}
+	if module.MatchPrefixPatterns(patterns, mod) {
+		return fmt.Errorf("cannot check %s against the checksum database: %s=%s matches it", mod, name, patterns)
+	}
+	if m.Sum == "" {
+		return fmt.Errorf("go mod download %s@%s reported no checksum", mod, m.Version)
+	}
+	hash, err := templateHash(m.Dir, mod, m.Version)
+	if err != nil {
+		return err
+	}
+	if hash != m.Sum {
+		return fmt.Errorf("%s@%s in the module cache has hash %s, not the verified %s; run go clean -modcache", mod, m.Version, hash, m.Sum)
+	}
+	return nil
+}
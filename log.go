@@ -0,0 +1,63 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// A logLevel says which messages gonew writes to standard error.
+type logLevel int
+
+const (
+	levelQuiet   logLevel = iota // only errors, with -q
+	levelNormal                  // errors and warnings
+	levelVerbose                 // also each step taken, with -v
+)
+
+// level is the logging level, set by setupLog.
+var level = levelNormal
+
+// setupLog validates the -q and -v flags and sets level.
+func setupLog() {
+	switch {
+	case *quiet && *verbose:
+		fatal("-q and -v are mutually exclusive")
+	case *quiet:
+		level = levelQuiet
+	case *verbose:
+		level = levelVerbose
+	}
+}
+
+// infof logs a step, with -v.
+func infof(format string, args ...any) {
+	if level >= levelVerbose {
+		log.Printf(format, args...)
+	}
+}
+
+// warnf logs a warning, unless -q is given.
+func warnf(format string, args ...any) {
+	if level >= levelNormal {
+		log.Print(stderrColors.paint(colorYellow, "warning:") + " " + fmt.Sprintf(format, args...))
+	}
+}
+
+// fatal logs an error, formatted as by fmt.Print, in red, and exits with
+// status 1.
+func fatal(args ...any) {
+	log.Print(stderrColors.paint(colorRed, fmt.Sprint(args...)))
+	os.Exit(1)
+}
+
+// fatalf logs an error, formatted as by fmt.Printf, in red, and exits with
+// status 1.
+func fatalf(format string, args ...any) {
+	log.Print(stderrColors.paint(colorRed, fmt.Sprintf(format, args...)))
+	os.Exit(1)
+}
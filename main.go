@@ -6,12 +6,656 @@
 //
 // Usage:
 //
-//	gonew src repo[@version] [dstmod [dir]]
+//	gonew [new] src repo[@version] [dstmod [dir]]
+//	gonew add src repo[@version] [dir]
+//	gonew lint src repo[@version]
+//	gonew templatize dir [out]
+//	gonew update [dir [version]]
+//	gonew list
+//	gonew cache clean|dir|list
+//	gonew completion bash|zsh|fish|powershell
+//	gonew version
+//	gonew -rehome dir dstmod
+//	gonew -rename-imports-only -old path -new path dir
+//
+// Gonew's commands are new, which creates a module and is also what gonew
+// does when given no command, add, lint, templatize, update, list, cache,
+// completion, and version; flags may come before or after the command name.
+// A local template directory whose name is that of a command must be
+// written as a path, as in ./list.
 //
 // Gonew clones the src repo, changing its module path to dstmod.
 // It writes that new module to a new directory named by dir.
-// If dir already exists, it must be an empty directory.
+// If dir already exists, it must be an empty directory, unless the -force
+// flag is given, to write the new module into it, replacing the files it
+// already has that the template also has, or -allow-existing, to write only
+// the template's files that it does not have yet, keeping the others as
+// they are. Neither touches a .git directory dir already has, and gonew then
+// does not initialize a new repository.
 // If dir is omitted, gonew uses ./elem where elem is the final path element of dstmod.
+// If dstmod is omitted and standard input is a terminal, gonew asks for
+// it, offering src as the default and checking that the answer is a valid
+// module path; likewise it asks for each variable declared in the
+// template's manifest (see below) that no -var flag sets. The -no-input
+// flag disables the prompts, as for use in scripts and CI, so that an
+// omitted dstmod means src and an unset variable takes its default.
+// The -infer-module flag instead derives an omitted dstmod from the origin
+// remote of the git repository in the current directory, or in the -dir
+// directory, and writes the new module there, as when run in a fresh clone
+// of an empty repository: in a clone of git@github.com:you/app.git, say,
+// dstmod is github.com/you/app. A directory holding nothing but a .git
+// directory counts as empty, and its repository is kept. Dstmod, however
+// given, must be a valid module path; gonew suggests the path meant by
+// one that is a repository URL instead.
+// The -dir flag gives dir without having to spell out dstmod. Dir may be an
+// absolute path or a nested relative one; any missing parent directories are
+// created.
+//
+// The src repo must be a Go module: gonew fails if the clone has no go.mod
+// file, at its root or in a subdirectory, and warns if the root go.mod
+// declares a module path other than src, since none of the template's
+// imports would then be rewritten.
+//
+// The -patch flag causes gonew to write the new module to standard output
+// as a single git-style patch that adds every file, suitable for
+// "git apply" in an empty repository, instead of writing it to dir.
+//
+// The -n flag, or its synonym -dry-run, makes a dry run: gonew clones and
+// rewrites the template in a temporary directory, prints the new module
+// path, the tree of files it would create, as with -preview-tree, and a
+// unified diff of every file it rewrote, and removes the temporary
+// directory, leaving dir untouched. No -tidy, -exec, or template hook commands are run.
+//
+// The -tmp flag causes gonew to create the new module in a fresh temporary
+// directory instead of dir and print that directory's path on standard
+// output. The directory is not removed; cleaning it up is left to the caller.
+//
+// With the -rehome flag, gonew instead changes the module path of the
+// existing module in dir to dstmod, rewriting it in place the same way it
+// rewrites a cloned template. The old module path is read from dir/go.mod,
+// and dir's .git directory, if any, is preserved.
+//
+// The update command, or the -update flag, instead brings the module in dir, by default
+// the current directory, up to date with the template it was created from,
+// which must have been recorded with -record. It creates the module afresh
+// both from the recorded commit of the template and from its latest version,
+// or the given version, and merges the template's changes between the two
+// into dir, printing each file it changes with a status letter: M for
+// modified, A for added, D for deleted, and C for a conflict. A conflicting
+// text file is left with conflict markers to resolve by hand, as after
+// "git merge"; gonew exits with status 1 if there are any. The flags that
+// control fetching and rewriting, such as -https, -var, and -subst,
+// should be given as they were when the module was created.
+//
+// With the -rename-imports-only flag, gonew rewrites just the import
+// specs in the Go files under dir that import the -old path or a package
+// below it, to import the corresponding -new path instead. It renames no
+// packages and does not touch go.mod or any other file, which makes it
+// useful as a codemod partway through a migration.
+//
+// If dstmod is omitted but the -dst-host flag is given, gonew derives dstmod
+// by replacing the host of src: with -dst-host git.mycorp.com, the source
+// github.com/org/repo becomes git.mycorp.com/org/repo.
+//
+// A template may describe itself in a .gonew/manifest.yaml file. Its files
+// section lists paths, which may be globs, that belong in the new module
+// only when template variables, set with repeated -var key=value flags,
+// have particular values:
+//
+//	files:
+//	  - path: db/postgres/
+//	    when: {Database: postgres}
+//
+// With -var Database=postgres, db/postgres is kept; otherwise it is removed.
+// Paths without conditions are always kept. The .gonew directory itself is
+// never copied into the new module. A template may instead keep its manifest
+// in a gonew.yaml file at its root, which is likewise removed.
+// A manifest's description, a line saying what the template is for,
+// is what gonew list shows for it.
+//
+// The manifest can also declare the template's variables with their
+// defaults, list paths to exclude from every new module, name the files
+// whose {{.Name}} placeholders are always substituted, as with -subst
+// but for those files only, and give post-generation commands:
+//
+//	vars:
+//	  - name: Database
+//	    default: sqlite
+//	    description: the database to generate code for
+//	exclude: [.github/FUNDING.yml]
+//	render: [README.md, cmd/**/*.go]
+//	hooks: [go generate ./...]
+//
+// The hooks may also be listed under post_new. Since they come from the
+// template, gonew runs them, after -tidy and before any -exec commands, only
+// if the -run-hooks flag, or its synonym -allow-hooks, is given; otherwise
+// it warns about them. Even then it first prints the commands it is about
+// to run. It runs each in the new module's directory, without standard
+// input, and with only the part of the environment that finding programs
+// and running the go command need: PATH, HOME, the GO and CGO_ variables,
+// the locale, and a few more, but not $GONEW_TOKEN or any other variable
+// that might hold credentials.
+//
+// A manifest can declare optional features, each with the files that
+// belong to it, which a new module gets only when the feature is chosen:
+//
+//	features:
+//	  - name: postgres
+//	    description: PostgreSQL storage
+//	    files: [internal/store/postgres/, migrations/]
+//	  - name: docker
+//	    default: true
+//	    files: [Dockerfile, .dockerignore]
+//
+// The -features flag chooses them, as in -features postgres,docker; without
+// it, the features marked default are chosen, and -features "" chooses none.
+// A feature may also own blocks of lines in any text file, between comment
+// lines reading gonew:feature name and gonew:end, which are removed unless
+// the feature is chosen; a block opened by gonew:feature !name is removed
+// if it is. The marker lines themselves are always removed:
+//
+//	// gonew:feature postgres
+//	import _ "github.com/lib/pq"
+//	// gonew:end
+//
+// The comments may begin with //, /*, #, --, ;, or <!--, and blocks may nest.
+//
+// A template can also list files never to copy into a new module, such as
+// its own CI configuration or documentation, in a .gonewignore file at its
+// root, using .gitignore syntax; the file itself is not copied either.
+// The -exclude flag, which may be repeated, adds a pattern in the same
+// syntax, such as -exclude 'docs/**', taking precedence over the file's.
+//
+// The -replace flag, which may be repeated, replaces a string other than the
+// module path in every text file of the new module, once the module path is
+// rewritten, as with -replace MyApp=Greeter for an application's name. With
+// -replace-words, only whole words are replaced, and with -replace-cases,
+// so are the string's case variants: myApp, myapp, MYAPP, my_app, MY_APP,
+// and my-app, each with the same variant of the new string. A manifest can
+// list replacements too, whose new strings may use template variables:
+//
+//	replace:
+//	  - {old: hello, new: "{{.ProjectName}}", words: true, cases: true}
+//
+// The -rename-paths flag applies the change of project name, the last
+// element of the module path, to the names of files and directories as
+// well: cloning github.com/example/hello as example.com/myprog renames
+// cmd/hello to cmd/myprog and hello_test.go to myprog_test.go, and rewrites
+// the imports of any package it moves. The name must be a whole word:
+// helloworld.go is left alone.
+//
+// The -subst flag also replaces placeholders of the form {{.Name}} in every
+// text file of the new module with the value of the template variable Name,
+// as in "Copyright {{.Year}} {{.Author}}" with -var Author='Jane Doe'.
+// Three variables are filled in automatically unless set with -var:
+// ModulePath is dstmod, ProjectName is its last path element, without any
+// major version suffix, and Year is the current year. A placeholder naming
+// any other unset variable is an error, unless the -allow-missing-vars flag
+// is given, in which case it is left alone. Substitution happens after
+// the module path is rewritten, so it never affects import paths.
+//
+// The -answers flag, or its synonym -vars, reads the inputs gonew would
+// otherwise take from flags or prompts from an answers file in YAML or JSON:
+//
+//	module: example.com/greeter
+//	vars:
+//	  Author: Jane Doe
+//	  Description: A tool for greeting people.
+//	features: [postgres, docker]
+//
+// The module path is used when no dstmod argument is given, a -var flag
+// overrides the file's value for the same name, and -features overrides its
+// features; without features, the template's defaults are chosen. A file
+// that has neither vars nor features may instead be a plain mapping of
+// variable names to values. The -write-answers flag writes the inputs
+// actually used, including the answers to any prompts and the defaults,
+// to a file in the same form, JSON if its name ends in .json, so that an
+// interactive run can be reviewed and repeated with -no-input -answers.
+//
+// Gonew also renames the primary package of the module to match the final
+// path element of dstmod. The primary package is the package named after the
+// final path element of src, or, if there is none, the only non-main package
+// in the root directory, not counting one, such as package tools, found only
+// in files guarded by a //go:build line. The package's external tests, in
+// package name_test, are renamed along with it. Gonew rewrites every Go file
+// whatever its build constraints, such as _windows.go files and files for
+// -tags integration, and cgo files, with import "C", like any other.
+//
+// Other packages in the module that import the primary package refer to it
+// by its old name, so gonew renames those references to the new name, as in
+// myprog.Hello(), resolving the scopes of each file as the compiler does to
+// find them. Where the new name is already taken, say by a variable in scope
+// at one of the references, by another import, or by a declaration anywhere
+// in the package, and in a file that does not parse, gonew instead adds the
+// old name to the import, as in import hello "your.domain/myprog", which
+// -rename-uses=false asks for everywhere. Imports of the modules renamed
+// with -rename-file are treated the same way when their last path
+// element changes.
+//
+// The -require-prefix flag, which may be repeated, rejects a dstmod that is
+// not one of the given prefixes or a path within one of them, and the
+// -require-pattern flag rejects a dstmod that does not match a regular
+// expression. Both are checked before anything is cloned.
+//
+// Gonew clones src over SSH from the host named by its first path element,
+// so github.com/org/proj is cloned from git@github.com:org/proj.git and
+// gitlab.com/group/subgroup/proj from git@gitlab.com:group/subgroup/proj.git.
+// The -protocol=https flag, or -https for short, clones from
+// https://host/path.git instead, for networks where SSH is blocked.
+// If cloning over SSH fails to authenticate, as on a machine with no SSH
+// keys loaded, gonew warns and retries over HTTPS, which works anonymously
+// for public templates; the -no-fallback flag disables the retry.
+//
+// For a private template, the -token flag, or else the $GONEW_TOKEN
+// environment variable, gives an access token, such as a GitHub or GitLab
+// personal access token, with which gonew clones src over HTTPS. The token
+// is passed to git through its environment, never on a command line, and is
+// not saved in the new module. Otherwise git authenticates as it usually
+// does: GIT_SSH_COMMAND and the SSH agent apply to SSH clones, and
+// credential helpers and ~/.netrc to HTTPS ones. With -proxy, the go command
+// handles authentication, so GOPRIVATE, GONOSUMDB, and ~/.netrc apply.
+//
+// A src whose host includes a port, such as
+// git.corp.com:8443/team/repo, is cloned over SSH using an ssh:// URL,
+// since the scp-like git@host:path form cannot express a port.
+//
+// For hosts other than github.com, gitlab.com, and bitbucket.org, gonew
+// first finds the repository the way "go get" does, from the go-import
+// meta tag served at https://src?go-get=1, so that vanity import paths and
+// self-hosted GitLab, Gitea, and similar servers work. The repository's
+// HTTPS URL from the tag is used as is, and its SSH URL is derived from it.
+// If there is no such tag, gonew clones from the module path as above.
+//
+// If src is a local directory, beginning with . or /, or a file:// URL,
+// gonew copies the template from that directory instead of cloning it,
+// taking its module path from its go.mod file. Any .git directory is
+// removed from the copy as usual, and a @version suffix then checks out
+// that version in the copy. Src may likewise name a zip file or tarball
+// (.zip, .tar, .tar.gz, or .tgz), as in file:///tmp/template.zip, which
+// gonew extracts instead. If everything in the archive is in one top-level
+// directory, as in the archives code hosts offer for download, and there is
+// no go.mod file beside it, that directory is taken to be the template.
+//
+// A repository holding several templates, each in its own directory, can be
+// used by naming the directory after a double slash, as in
+// github.com/org/templates//services/grpc@v1.2.0. Gonew clones the repository
+// and uses just that directory, with its go.mod, as the template. If dstmod is
+// omitted, the new module keeps the module path from that go.mod.
+//
+// If src includes a @version suffix, gonew checks out that tag, branch,
+// or commit after cloning; otherwise it uses the default branch.
+// Since the template's history is discarded, gonew makes a shallow clone
+// of just the requested branch or tag, falling back to a full clone for
+// a commit; the -full flag always clones the whole history.
+// A version of the form pull/N/head, as in github.com/example/hello@pull/42/head,
+// selects the head of pull request N on hosts such as GitHub that publish
+// refs/pull/N/head, which is useful for trying out a template change under review.
+//
+// Each template gonew clones is saved in a cache directory, named by the
+// -template-dir flag, the $GONEW_CACHE environment variable, or else the
+// gonew subdirectory of the user cache directory, in that order.
+// When the branch or tag requested still names the commit that was cached,
+// as checked with "git ls-remote", gonew copies the cached clone instead
+// of cloning src again, which makes creating many modules from the same
+// template quick. The -offline flag makes gonew use only the cached copy
+// of src, failing if it is not present, instead of cloning it over the
+// network. The -no-cache flag makes gonew always clone src and leave the
+// cache alone. Clones made with -full are never cached.
+// The gonew cache list command prints each template in the cache, with the
+// version and commit cloned; gonew cache dir prints the cache directory, and
+// gonew cache clean removes it and everything in it. The version command
+// prints the version of gonew and the Go toolchain that built it, and then
+// the commit gonew was built from, when it was made, and whether the
+// working tree had changes, as the go command records them in a binary
+// built in a clone of gonew's repository; with -json, it prints them as
+// JSON. A binary built for a release may instead be given its version and
+// commit with -ldflags "-X main.version=v1.2.3 -X main.commit=abc1234".
+// Include its output in a bug report.
+//
+// The completion command writes a script completing gonew's commands,
+// flags, and template names from the registry in the given shell, bash,
+// zsh, fish, or powershell. Each script says how to install it; for bash,
+// add source <(gonew completion bash) to ~/.bashrc.
+//
+// A registry gives templates short names, so that gonew new grpc-service
+// example.com/foo will do. It is a YAML file, named by the -registry flag,
+// the $GONEW_REGISTRY environment variable, or else gonew/registry.yaml in
+// the user config directory, and -registry or $GONEW_REGISTRY may instead
+// give an https URL, for a registry shared by an organization:
+//
+//	templates:
+//	  - name: grpc-service
+//	    repo: github.com/example/grpc-template
+//	    version: v1.2.0
+//	    description: A gRPC service with health checks and metrics
+//	  - name: cli
+//	    repo: github.com/example/templates
+//	    subdir: cli
+//
+// A src without a dot or slash is looked up in the registry, and a version
+// given with it, as in grpc-service@v1.3.0, overrides the registry's.
+// The list command prints the templates in the registry, each with the
+// description in its manifest, if the template is local or in the cache,
+// or else the description in the registry.
+//
+// An organization may give every developer the same defaults and rules
+// with a policy file, named by the $GONEW_CONFIG environment variable or
+// else /etc/gonew/config.yaml (%ProgramData%\gonew\config.yaml on Windows):
+//
+//	registry: https://gonew.example.com/registry.yaml
+//	vars:
+//	  Org: Example Corp
+//	org: Example Corp
+//	license: apache2
+//	allowed_templates:
+//	  - github.com/example/*
+//	require_prefix: [github.com/example]
+//	record: true
+//	exec:
+//	  - provenance stamp .
+//
+// Its registry, org, and license are the defaults for -registry, -org,
+// unless -author is given, and -license, and its vars the defaults for the
+// variables a template's manifest declares, which -var and -answers still
+// override. Its rules, however, apply whatever the flags: every template,
+// including overlays and the template gonew update merges from, must match
+// one of the allowed_templates patterns, in which * matches one element
+// of a path and ** any number of them; a dstmod must meet require_prefix
+// and require_pattern as it must -require-prefix and -require-pattern;
+// record has gonew act as if -record were always given; and the exec
+// commands run in each new module, after those of -exec. Gonew rejects
+// a policy file with fields it does not know, rather than ignore a
+// misspelled rule.
+//
+// The -proxy flag downloads src through the Go module proxy with
+// "go mod download" instead of cloning it with git, so that
+// gonew example.com/tmpl@v1.2.3 works anywhere the go command does, without
+// git or SSH keys, honoring GOPROXY, GONOSUMDB, and the other go command
+// settings. The version is then a module version or query, defaulting to
+// latest, and -offline consults only the module cache.
+//
+// The -submodules flag checks out the template's git submodules, and theirs
+// in turn, at the commits the template pins, fetching just those commits
+// unless -full is given, and includes their files in the new module as plain
+// directories. Without it, gonew leaves out the submodules, which a clone
+// gives as empty directories, and warns about them. Either way, the -json
+// report and the -record file list each submodule's path and pinned commit.
+// With -keep-git, the submodules are kept as they are.
+//
+// The -overlay flag, which may be repeated, composes the new module from
+// several templates, as from a base service skeleton and optional layers
+// for observability or deployment, instead of a template for every
+// combination:
+//
+//	gonew example.com/service my.mod/svc -overlay example.com/tmpl-observability -overlay example.com/tmpl-helm
+//
+// Each overlay, named as src is, is created as gonew add would create it
+// with -force on top of the module created so far, in order: a file of an
+// overlay replaces the one the base template or an earlier overlay has,
+// and the requirements of its go.mod and go.sum are added to the module's.
+// The other flags apply to every template. A -features name need only be
+// declared by one of them, and the hooks of the overlays' manifests run,
+// with -run-hooks, after the base template's. The -json report and the
+// -record file list the overlays, and update updates them, unless given
+// -overlay flags to use instead.
+//
+// The new module's files keep the modes of the template's, even those
+// gonew rewrites, so that a script stays executable. Symbolic links are
+// copied as links, never followed; a link to an absolute path or outside
+// the template is left out, with a warning. A directory that is empty in
+// the template, as one copied from a local directory or archive may be,
+// gets a .gitkeep file, so that the new module's git repository keeps it.
+// Gonew update applies a change of mode in the template too, unless the
+// file's mode was changed locally as well.
+//
+// An interrupt or termination signal stops gonew, killing any git, go,
+// or hook command it is running, and removes what it created, as after
+// any other failure. The -timeout flag does the same once the given time,
+// such as 2m, has passed, as for a clone hung on the network in a script;
+// since it counts the time spent answering prompts, it goes well with
+// -no-input.
+//
+// Three flags check that the template is the code expected, for teams that
+// must not scaffold from tampered templates. The -require-commit flag fails
+// unless the template, once its version is checked out, is at the commit
+// with the given hash, or hash prefix of at least seven digits, noticing a
+// tag moved since it was reviewed. The -verify-signature flag fails unless
+// the tag the version names, or else the commit checked out, has a valid
+// signature, as git verify-tag and git verify-commit check it against the
+// keys git trusts. Both need a git template, not -proxy. With -proxy, the go
+// command checks the template against the checksum database (sum.golang.org)
+// as go get does; the -require-sumdb flag fails if GOSUMDB=off, GONOSUMDB,
+// or GOPRIVATE turns that check off for the template, or if its files in the
+// module cache no longer have the hash that was checked.
+//
+// A template may hold several modules, as in a go.work workspace. Each
+// nested module whose path lies within src, such as src/tools, is given the
+// corresponding path within dstmod, such as dstmod/tools, and the module
+// paths in go.work replace directives are rewritten the same way.
+//
+// The -go flag sets the go directive of the new module's go.mod and go.work
+// files, and their toolchain lines if they have them, to the given version,
+// such as 1.22.
+// Otherwise the template's go directive is left alone.
+//
+// Gonew rewrites files in parallel, up to GOMAXPROCS at a time by default
+// or as many as the -j flag gives. If a file cannot be rewritten, gonew
+// stops and reports it, along with any other file that failed meanwhile.
+// Each file is replaced by renaming a rewritten copy into place, so that
+// even an interrupted -rehome never leaves a file half-written.
+//
+// Files larger than the -max-file-size flag, 4 MiB by default, are never
+// read into memory for rewriting; gonew copies them unchanged and warns.
+//
+// The -v flag logs each step gonew takes as it goes: the git commands it
+// runs, along with git's own progress output for a clone, each file it
+// rewrites and what it changed there, each file it checks and leaves
+// unchanged, the removal of the template's .git directory, and the final
+// location of the new module.
+// It also reports, at the end of the run, each file that gonew would
+// otherwise have rewritten but skipped, along with the reason: not-included
+// (the file matched no -include glob), too-large (the file exceeded
+// -max-file-size), symlink (the file is a symbolic link, which gonew
+// never writes through), unreadable (gonew lacked permission to read the
+// file or directory), excluded-dir (the directory is listed in
+// -skip-dirs), or parse-error (the Go file does not parse, so that its
+// imports cannot be rewritten; gonew warns about each one). Unreadable
+// files and directories, and Go files that do not parse, are fatal
+// errors instead if the -strict flag is given.
+//
+// The -q flag silences warnings, so that gonew logs nothing but the error
+// that makes it fail; it cannot be combined with -v. Output asked for,
+// such as that of -list-changed or -json, is still printed.
+//
+// The -gitignore flag writes a standard Go .gitignore, covering binaries,
+// test executables, and coverage profiles, into the new module if the
+// template does not provide one. An existing .gitignore is left alone,
+// unless the -gitignore-merge flag is used to append the standard patterns
+// it lacks.
+//
+// The -author, -org, -license, and -year flags keep a new module from
+// shipping with the template author's copyright and license. Gonew gives
+// the copyright lines of the template's license, and of the header comments
+// of its files, the -org as copyright holder, or else the -author, and the
+// -year, by default this year; when the license names the template's
+// holder, lines naming others, as in third-party code, are left alone. The
+// -license flag, mit, apache2, or none, replaces the template's LICENSE or
+// COPYING file with one for the given license, or removes it, and updates
+// the SPDX-License-Identifier lines of the headers along with sentences such
+// as "Use of this source code is governed by a BSD-style license". A
+// CODEOWNERS file is given the -org, as @org, or an -author that is a
+// @handle or email address, as the owner of every rule, and AUTHORS and
+// CONTRIBUTORS files list just the new owner. For example:
+//
+//	gonew -org acme -license mit github.com/example/hello acme.com/hello
+//
+// Gonew removes the template's .git directory, so that the new module does not
+// inherit the template's history, and then runs "git init" to start a fresh
+// repository in its place. The -git=false flag skips that step, and the
+// -git-commit flag additionally stages every file and creates an initial
+// commit, with the message "Initialized from src@version". The -set-origin
+// flag sets the new repository's origin remote to the URL inferred from
+// dstmod, just as src's URL is inferred from src, and the -push flag, which
+// implies -git-commit and -set-origin, pushes the initial commit there, for
+// a remote repository that has already been created empty. A failed push
+// leaves the new module in place, with a warning.
+//
+// The -keep-git flag instead keeps the template's full history and remotes
+// in the new module, still rewriting its module path, so that a fork can
+// later pull improvements from the template. With -rename-origin, the
+// template's origin remote is renamed to template, freeing origin for the
+// new module's own repository. With -git-commit, the changes gonew makes
+// are committed on top of the template's history. Since there is no fresh
+// repository to start, -keep-git cannot be combined with -git, -set-origin,
+// or -push. The -keep-history flag is short for -keep-git -rename-origin
+// -git-commit: it keeps the history, records the template as the remote
+// named template, and commits the rewrite to the checked-out branch.
+//
+// If gonew fails partway through, it removes the new module directory, or
+// empties it again if it was an existing empty directory, so that the same
+// command can simply be run again. The -keep flag leaves the partial result
+// in place instead, for debugging.
+//
+// Gonew drops from each go.sum or go.work.sum file the checksums of the
+// template's module and of the modules within it, such as the other
+// modules of a multi-module template, which it renames and which so no
+// longer match them. The -tidy flag runs "go mod tidy" in the new module
+// once it is rewritten, and in each module nested in it, so that their
+// go.sum files match; the -fmt flag then runs "gofmt -w .", and the
+// -exec flag, which may be repeated, runs a further command there, such as
+// -exec 'go generate ./...'; the command is split into words at spaces,
+// without any shell quoting. If any of these commands fails, gonew reports
+// its output and removes the new module.
+//
+// The -verify flag checks that the finished module compiles by running
+// "go build ./..." and "go vet ./..." in it. If either fails, gonew reports
+// its output but leaves the new module in place for inspection.
+//
+// The add command applies a template to the existing module in dir, by
+// default the current directory, rather than creating a new one, as for a
+// template holding just a component such as a gRPC handler. The template's
+// module path is rewritten to the one in dir's go.mod, so that its imports of
+// its own packages become imports of the module's, and its files are copied
+// into dir, except for its go.mod and go.sum, whose requirements and checksums
+// that dir's lack are added to dir's instead. Gonew add refuses to overwrite
+// a file the module already has unless the -force flag is given, in which
+// case it replaces it, or -allow-existing, in which case it keeps it. It prints each file it adds or changes; -tidy runs
+// "go mod tidy" in dir afterward, and -var, -features, and the flags that
+// control fetching and rewriting work as they do when creating a module.
+//
+// The lint command checks a template for its author, as in the CI of a
+// template repository. Gonew lint fetches the template as it would to
+// create a module and reports, one per line as file:line: message, each
+// go.mod or Go file that does not parse, each variable the manifest
+// declares that no placeholder or condition uses, each placeholder in a
+// rendered file naming one it does not declare, each absolute path into
+// a home directory and each replace directive or symbolic link leading
+// outside the template, each nested .git, and each file that looks like a
+// secret, such as .env, a private key, or an access token. It then creates
+// a trial module from the template in a temporary directory, with the
+// variables' defaults, and builds and vets it as -verify would. Gonew lint
+// exits with status 1 if it finds any problems. The -json flag prints them
+// as JSON instead, and -var, -features, -subst, -tidy, and the flags that
+// control fetching and rewriting apply to the trial module.
+//
+// The templatize command turns the existing project in dir, the root of a
+// module, into a template in the new directory out, by default dir's name
+// followed by -template, beside it. It copies the project, except for its
+// .git directory, the files git ignores, editor settings and similar files
+// of one checkout, such as .vscode and .DS_Store, and files that usually
+// hold credentials, such as .env and private keys, and writes a starter
+// gonew.yaml manifest. Since gonew rewrites the module path anyway, the
+// manifest proposes placeholders only for the project's name, the last
+// element of the module path, and its organization, the element after
+// the host, listing where else they appear, as replacements with
+// {{.ProjectName}} and an Org variable. They are commented out for review
+// unless the -parameterize flag is given. Gonew templatize prints the
+// files it left out and the candidates it found, or with -json, a report.
+//
+// The -record flag writes a .gonew.json file into the new module recording
+// the template's module path, and its directory if it is local, the requested
+// version and cloned commit, a hash of the template's files, the template
+// variables and features chosen, the new module path, the time, and the
+// version of gonew used, from which gonew update can later work and which
+// answers, for an audit, which template a module came from. The hash has
+// the "h1:" form of a go.sum line; for a template fetched with -proxy, it
+// is the one go.sum and the checksum database list for its version.
+//
+// The -list-changed flag prints the slash-separated path, relative to the
+// root of the new module, of each file gonew rewrote, one per line in sorted
+// order, for use with tools such as xargs. With -n, it prints just the files
+// gonew would rewrite, instead of the tree and diff.
+//
+// The -preview-tree flag prints the file tree of the new module as an
+// indented list, marking each file as either copied from the template
+// unchanged ("new") or rewritten by gonew ("rewritten").
+//
+// The -json flag prints, instead, a JSON report for programs that run gonew:
+// the new module's directory and path, the template and the version,
+// commit, and hash of it used, every file created and those rewritten or skipped,
+// the import paths renamed and strings replaced, and the template variables
+// and features. Log messages, warnings, and the output of -list-changed and
+// -preview-tree go to standard error, as they always do with -patch.
+// With gonew update, -json prints the files updated, added, deleted, and in
+// conflict in the same way.
+//
+// The -color flag controls colorized output: warnings are shown in yellow and
+// patch additions in green. The default, auto, colors output only when it is
+// written to a terminal and the NO_COLOR environment variable is unset;
+// always and never force colors on and off.
+//
+// The -touch-mod-time flag sets the modification time of every file in the
+// new module to the commit time of the template, and the -mtime flag sets it
+// to an explicit time instead, so that archives of the result are reproducible.
+// There is no separate -reproducible flag: -touch-mod-time, or -mtime with a
+// fixed time, is how to make the timestamps of the output deterministic.
+// Without either, files keep the time they were written.
+//
+// The -rename-file flag names a file of additional renames to apply to import
+// paths, one "oldpath newpath" pair per line; blank lines and lines beginning
+// with # are ignored. This is useful for templates assembled from several
+// upstream modules.
+//
+// The -rewrite-strings flag also rewrites Go string literals whose value is
+// exactly the source module path, such as const Module = "github.com/example/hello".
+// Literals that merely contain the module path are left alone.
+//
+// The module path is always rewritten in //go:generate directives, such as
+// //go:generate go run github.com/example/hello/tools/gen, and the
+// -rewrite-comments flag rewrites it in every other comment in Go files
+// too, such as "Code generated by github.com/example/hello/tools/gen."
+// headers and doc comments.
+//
+// The -rewrite-codegen flag additionally rewrites references to the source
+// module path in code generator configuration that names import paths:
+// sqlc.yaml, sqlc.yml, and sqlc.json for sqlc, and entc.go for ent.
+//
+// The -rewrite-ext flag takes a comma-separated list of file extensions and
+// names, such as md,yml,Dockerfile, and rewrites references to the source
+// module path in the matching files as well, for example in a README's install
+// instructions or a Dockerfile's COPY destination. Files that look binary,
+// because they contain a NUL byte, are left alone. The -rewrite-text flag
+// does the same for the text files templates most often mention their module
+// path in: Dockerfile, Makefile, and files ending in .md, .txt, .yml, .yaml,
+// .toml, .json, .sh, and .mk, which covers READMEs, CI workflows such as
+// .github/workflows/*.yml, and Kubernetes manifests. The two flags combine.
+//
+// Gonew does not rewrite the files in directories named vendor, which hold
+// copies of other modules, testdata, or node_modules, at any depth. The
+// -skip-dirs flag sets a different comma-separated list of directory names
+// to leave alone; -skip-dirs= rewrites every directory. The one exception
+// is a vendor directory holding copies of the template's own modules, as
+// for a replace directive naming a sibling module: gonew moves those
+// copies to the new module path and rewrites them, along with
+// vendor/modules.txt, so that the vendor directory matches go.mod.
+//
+// The -include flag restricts rewriting to files whose path, relative to the
+// root of the cloned repository, matches the given glob. Globs use path.Match
+// syntax, plus "**" to match any number of directories. The flag may be
+// repeated; files that match none of the globs are copied verbatim.
+//
+// Programs that want to create modules from templates without running gonew
+// can use the package github.com/cody0704/gonew/gonew, which implements it.
 //
 // This command is highly experimental and subject to change.
 //
@@ -34,29 +678,539 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"go/parser"
-	"go/token"
+	"io"
 	"io/fs"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cody0704/gonew/gonew"
+)
 
-	"github.com/cody0704/gonew/internal/edit"
-	"golang.org/x/mod/modfile"
+var (
+	vars            = make(map[string]string)
+	dstHost         = flag.String("dst-host", "", "if dstmod is omitted, derive it from src by replacing its host with `host`")
+	includes        stringList
+	excludes        stringList
+	featuresFlag    = flag.String("features", "", "include the optional template features in the comma-separated `list` (default those the template marks default)")
+	replaces        stringList
+	prefixes        stringList
+	pattern         = flag.String("require-pattern", "", "require dstmod to match the regular expression `re`")
+	useTmp          = flag.Bool("tmp", false, "create the new module in a new temporary directory and print its path")
+	importsOnly     = flag.Bool("rename-imports-only", false, "rewrite only the Go imports of -old to -new in dir")
+	oldPath         = flag.String("old", "", "with -rename-imports-only, the import `path` to rewrite")
+	newPath         = flag.String("new", "", "with -rename-imports-only, the replacement import `path`")
+	renamePathsFlag = flag.Bool("rename-paths", false, "rename files and directories named after the template, such as cmd/hello, after dstmod")
+	replaceWords    = flag.Bool("replace-words", false, "with -replace, replace only whole words")
+	replaceCases    = flag.Bool("replace-cases", false, "with -replace, also replace the camelCase, snake_case, and other case variants")
+	rehome          = flag.Bool("rehome", false, "rewrite the existing module in dir, in place, to have module path dstmod")
+	update          = flag.Bool("update", false, "merge changes to the template since it was recorded into the module in dir")
+	emitPatch       = flag.Bool("patch", false, "write the new module to standard output as a git-style patch instead of to dir")
+	renameFile      = flag.String("rename-file", "", "also rewrite imports using the `file` of \"oldpath newpath\" lines")
+	strict          = flag.Bool("strict", false, "fail on unreadable files and directories, and Go files that do not parse, instead of skipping them")
+	colorMode       = flag.String("color", "auto", "colorize output: `when` is auto, always, or never")
+	verbose         = flag.Bool("v", false, "log each step and each file rewritten or not, and why, and show git's progress")
+	quiet           = flag.Bool("q", false, "log only errors, not warnings")
+	goVersion       = flag.String("go", "", "set the go directive in go.mod to `version`")
+	jobs            = flag.Int("j", 0, "rewrite up to `n` files in parallel (default GOMAXPROCS)")
+	skipDirs        = flag.String("skip-dirs", strings.Join(gonew.DefaultSkipDirs, ","), "copy the comma-separated directories `names` without rewriting them")
+	maxSize         = flag.Int64("max-file-size", 4<<20, "copy files larger than `n` bytes without rewriting them (0 means no limit)")
+	gitignore       = flag.Bool("gitignore", false, "write a standard Go .gitignore if the template has none")
+	gitignoreMerge  = flag.Bool("gitignore-merge", false, "like -gitignore, but also add missing standard patterns to an existing .gitignore")
+	author          = flag.String("author", "", "make `name` the copyright holder and owner of the new module, unless -org is given")
+	org             = flag.String("org", "", "make the organization `name` the copyright holder and owner of the new module")
+	license         = flag.String("license", "", "replace the template's license with `name`: mit, apache2, or none")
+	year            = flag.Int("year", 0, "give the new module's copyright lines the `year` (default this year)")
+	recordFlag      = flag.Bool("record", false, "write "+gonew.RecordFile+" recording the template the module was created from")
+	jsonOut         = flag.Bool("json", false, "print a JSON report of the new module, its files, and the rewrites applied")
+	listChanged     = flag.Bool("list-changed", false, "print the paths of rewritten files, one per line")
+	previewTree     = flag.Bool("preview-tree", false, "print the file tree of the new module, marking new and rewritten files")
+	touch           = flag.Bool("touch-mod-time", false, "set the modification time of every file to the template's commit time")
+	useHTTPS        = flag.Bool("https", false, "clone src over HTTPS instead of SSH; same as -protocol=https")
+	protocol        = flag.String("protocol", "ssh", "clone src using `transport` ssh or https")
+	dirFlag         = flag.String("dir", "", "write the new module to `dir`, as if given as the dir argument")
+	noInput         = flag.Bool("no-input", false, "never prompt for missing inputs")
+	inferModule     = flag.Bool("infer-module", false, "if dstmod is omitted, derive it from the origin remote of the git repository in dir, by default the current directory, and write the module there")
+	runHooks        = flag.Bool("run-hooks", false, "run the post-generation commands of the template's manifest")
+	useProxy        = flag.Bool("proxy", false, "download src through the Go module proxy instead of cloning it with git")
+	token           = flag.String("token", "", "clone src over HTTPS using the access `token` (default $GONEW_TOKEN)")
+	noFallback      = flag.Bool("no-fallback", false, "do not retry over HTTPS when cloning over SSH fails to authenticate")
+	offline         = flag.Bool("offline", false, "use only the template cache; never access the network")
+	registryFlag    = flag.String("registry", "", "look up template names in the registry `file` or URL (default $GONEW_REGISTRY or the user config directory)")
+	noCache         = flag.Bool("no-cache", false, "always clone src, neither using nor saving a copy in the template cache")
+	tmplDir         = flag.String("template-dir", "", "cache templates in `dir` (default $GONEW_CACHE or the user cache directory)")
+	rewriteComments = flag.Bool("rewrite-comments", false, "also rewrite the source module path in Go comments, such as generated-code headers")
+	rewriteStrings  = flag.Bool("rewrite-strings", false, "also rewrite Go string literals whose value is exactly the source module path")
+	renameUses      = flag.Bool("rename-uses", true, "rename uses of the primary package instead of importing it under its old name")
+	codegen         = flag.Bool("rewrite-codegen", false, "also rewrite module path references in sqlc and ent configuration")
+	mtime           = flag.String("mtime", "", "set the modification time of every file to `time` (RFC 3339 or Unix seconds)")
+	rewriteText     = flag.Bool("rewrite-text", false, "also rewrite the module path in common text files: docs, Dockerfiles, Makefiles, and YAML, TOML, and JSON configuration")
+	rewriteExt      = flag.String("rewrite-ext", "", "also rewrite the module path in files with the comma-separated extensions or names `list`, such as md,yml,Dockerfile")
+	dryRun          = flag.Bool("n", false, "print the changes gonew would make, without creating the new module")
+	gitInit         = flag.Bool("git", true, "initialize a new git repository in the new module")
+	gitCommit       = flag.Bool("git-commit", false, "with -git or -keep-git, also commit the new module's files")
+	setOrigin       = flag.Bool("set-origin", false, "with -git, set the origin remote to dstmod's repository URL")
+	push            = flag.Bool("push", false, "with -git, commit and push the new module to dstmod's repository")
+	fullClone       = flag.Bool("full", false, "clone the template's whole history instead of making a shallow clone")
+	submodulesFlag  = flag.Bool("submodules", false, "check out the template's git submodules and include their files")
+	requireCommit   = flag.String("require-commit", "", "fail unless the template is at the commit with the hash, or hash prefix, `sha`")
+	verifySig       = flag.Bool("verify-signature", false, "fail unless the template's tag, or else commit, has a signature git trusts")
+	requireSumDB    = flag.Bool("require-sumdb", false, "with -proxy, fail unless the template is checked against the checksum database")
+	keepGit         = flag.Bool("keep-git", false, "keep the template's git history and remotes in the new module")
+	keepHistory     = flag.Bool("keep-history", false, "same as -keep-git -rename-origin, also committing the rewrite on top of the template's history")
+	renameOrigin    = flag.Bool("rename-origin", false, "with -keep-git, rename the template's origin remote to template")
+	subst           = flag.Bool("subst", false, "replace {{.Name}} placeholders in text files with template variables")
+	answersFile     = flag.String("answers", "", "read the new module path, template variables, and features from the YAML or JSON answers `file`")
+	writeAnswers    = flag.String("write-answers", "", "write the new module path, template variables, and features used to the answers `file`")
+	allowMissing    = flag.Bool("allow-missing-vars", false, "with -subst, leave placeholders for unset variables alone instead of failing")
+	force           = flag.Bool("force", false, "write into a dir that is not empty, or with gonew add, the module, replacing the files the template also has")
+	allowExisting   = flag.Bool("allow-existing", false, "like -force, but keep the files that already exist, writing only new ones")
+	keep            = flag.Bool("keep", false, "keep the partially created module if gonew fails, for debugging")
+	timeout         = flag.Duration("timeout", 0, "give up if cloning and creating the module take longer than `duration`, such as 2m (default no limit)")
+	tidy            = flag.Bool("tidy", false, "run go mod tidy in the new module and each module nested in it")
+	format          = flag.Bool("fmt", false, "run gofmt -w in the new module")
+	verify          = flag.Bool("verify", false, "check that the new module builds and vets cleanly, keeping it if not")
+	parameterize    = flag.Bool("parameterize", false, "with gonew templatize, replace the candidate placeholders instead of only listing them")
+	hooks           stringList
+	overlays        stringList
 )
 
+// A stringList is a flag.Value that accumulates the values of a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func init() {
+	flag.Var(&includes, "include", "only rewrite files matching `glob` (may be repeated)")
+	flag.Var(&replaces, "replace", "also replace `old=new` in every text file (may be repeated)")
+	flag.Var(&excludes, "exclude", "do not copy files matching `pattern`, in .gitignore syntax (may be repeated)")
+	flag.Func("var", "set template variable `key=value` (may be repeated)", func(s string) error {
+		k, v, ok := strings.Cut(s, "=")
+		if !ok || k == "" {
+			return fmt.Errorf("want key=value")
+		}
+		vars[k] = v
+		return nil
+	})
+	flag.BoolVar(dryRun, "dry-run", false, "same as -n")
+	flag.BoolVar(runHooks, "allow-hooks", false, "same as -run-hooks")
+	flag.StringVar(answersFile, "vars", "", "same as -answers")
+	flag.Var(&hooks, "exec", "run `command` in the new module after rewriting it (may be repeated)")
+	flag.Var(&overlays, "overlay", "apply the template `src` on top of the template, in order (may be repeated)")
+	flag.Var(&prefixes, "require-prefix", "require dstmod to be `prefix` or lie within it (may be repeated)")
+}
+
 func usage() {
-	fmt.Fprintf(os.Stderr, "usage: gonew src repo[@version] [dstmod [dir]]\n")
+	fmt.Fprintf(os.Stderr, "usage: gonew [new] [flags] src repo[@version] [dstmod [dir]]\n")
+	fmt.Fprintf(os.Stderr, "       gonew add [flags] src repo[@version] [dir]\n")
+	fmt.Fprintf(os.Stderr, "       gonew lint [flags] src repo[@version]\n")
+	fmt.Fprintf(os.Stderr, "       gonew templatize [flags] dir [out]\n")
+	fmt.Fprintf(os.Stderr, "       gonew update [flags] [dir [version]]\n")
+	fmt.Fprintf(os.Stderr, "       gonew list [flags]\n")
+	fmt.Fprintf(os.Stderr, "       gonew cache [flags] clean|dir|list\n")
+	fmt.Fprintf(os.Stderr, "       gonew completion bash|zsh|fish|powershell\n")
+	fmt.Fprintf(os.Stderr, "       gonew version [flags]\n")
+	fmt.Fprintf(os.Stderr, "       gonew -rehome [flags] dir dstmod\n")
+	fmt.Fprintf(os.Stderr, "       gonew -rename-imports-only -old path -new path [flags] dir\n")
+	flag.PrintDefaults()
 	fmt.Fprintf(os.Stderr, "See https://pkg.go.dev/golang.org/x/tools/cmd/gonew.\n")
 	os.Exit(2)
 }
 
+// features returns the features chosen by -features, or else by the
+// answers file a, or nil if neither chooses them.
+func features(a *gonew.Answers) []string {
+	set := false
+	flag.Visit(func(f *flag.Flag) { set = set || f.Name == "features" })
+	if !set {
+		return a.Features
+	}
+	list := []string{}
+	for _, f := range strings.Split(*featuresFlag, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			list = append(list, f)
+		}
+	}
+	return list
+}
+
+// readAnswers reads the -answers file, adding its variables to vars,
+// except for those that -var flags set. Without -answers, it returns
+// empty answers.
+func readAnswers() *gonew.Answers {
+	if *answersFile == "" {
+		return new(gonew.Answers)
+	}
+	answers, err := gonew.ReadAnswers(*answersFile)
+	if err != nil {
+		fatal(err)
+	}
+	for k, v := range answers.Vars {
+		if _, ok := vars[k]; !ok {
+			vars[k] = v
+		}
+	}
+	return answers
+}
+
+// saveAnswers writes the inputs of the module created as res,
+// with module path mod, to the -write-answers file, if any.
+func saveAnswers(mod string, res *gonew.Result) {
+	if *writeAnswers == "" {
+		return
+	}
+	a := &gonew.Answers{Module: mod, Vars: res.Vars, Features: res.Features}
+	if err := gonew.WriteAnswers(*writeAnswers, a); err != nil {
+		fatal(err)
+	}
+}
+
+// accessToken returns the access token for cloning private templates.
+func accessToken() string {
+	if *token != "" {
+		return *token
+	}
+	return os.Getenv("GONEW_TOKEN")
+}
+
+// progress returns where git's progress goes: standard error with -v.
+func progress() io.Writer {
+	if *verbose {
+		return os.Stderr
+	}
+	return nil
+}
+
+// checkTrustFlags exits if the flags checking the template
+// do not suit the way it is fetched.
+func checkTrustFlags() {
+	if *useProxy && (*requireCommit != "" || *verifySig) {
+		fatal("-require-commit and -verify-signature cannot be combined with -proxy, which fetches no commits; use -require-sumdb")
+	}
+	if *requireSumDB && !*useProxy {
+		fatal("-require-sumdb requires -proxy")
+	}
+}
+
+// cacheDir returns the directory holding cached templates,
+// or "" with -no-cache.
+func cacheDir() string {
+	if *noCache {
+		return ""
+	}
+	if *tmplDir != "" {
+		return *tmplDir
+	}
+	if dir := os.Getenv("GONEW_CACHE"); dir != "" {
+		return dir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gonew")
+}
+
+// cacheCommand implements "gonew cache".
+func cacheCommand(args []string) {
+	if len(args) != 1 {
+		usage()
+	}
+	dir := cacheDir()
+	if dir == "" {
+		fatal("no template cache directory")
+	}
+	switch args[0] {
+	case "dir":
+		fmt.Println(dir)
+	case "list":
+		listCache()
+	case "clean":
+		infof("removing %s", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			fatal(err)
+		}
+	default:
+		fatalf("unknown cache command %q: want clean, dir, or list", args[0])
+	}
+}
+
+// policy is the organization's policy, which readConfig reads.
+// Without a policy file, it sets no defaults and imposes no rules.
+var policy = new(gonew.Config)
+
+// configLocation returns the location of the policy file, and whether
+// it is the default, which need not exist.
+func configLocation() (string, bool) {
+	if file := os.Getenv("GONEW_CONFIG"); file != "" {
+		return file, false
+	}
+	if runtime.GOOS == "windows" {
+		dir := os.Getenv("ProgramData")
+		if dir == "" {
+			return "", true
+		}
+		return filepath.Join(dir, "gonew", "config.yaml"), true
+	}
+	return "/etc/gonew/config.yaml", true
+}
+
+// readConfig reads the policy file, if there is one, into policy.
+func readConfig() {
+	file, isDefault := configLocation()
+	if file == "" {
+		return
+	}
+	c, err := gonew.ReadConfig(file)
+	if isDefault && errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		fatal(err)
+	}
+	infof("applying the policy in %s", file)
+	policy = c
+}
+
+// registryLocation returns the location of the template registry, and
+// whether it is the default, which need not exist.
+func registryLocation() (string, bool) {
+	if *registryFlag != "" {
+		return *registryFlag, false
+	}
+	if loc := os.Getenv("GONEW_REGISTRY"); loc != "" {
+		return loc, false
+	}
+	if policy.Registry != "" {
+		return policy.Registry, false
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", true
+	}
+	return filepath.Join(dir, "gonew", "registry.yaml"), true
+}
+
+// readRegistry returns the template registry, or nil if there is none.
+func readRegistry() *gonew.Registry {
+	loc, isDefault := registryLocation()
+	if loc == "" {
+		return nil
+	}
+	ctx, stop := runContext()
+	reg, err := gonew.ReadRegistry(ctx, loc)
+	err = contextErr(ctx, err)
+	stop()
+	if isDefault && errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		fatal(err)
+	}
+	return reg
+}
+
+// resolveName returns the template src names, in the
+// repo[//subdir][@version] form, if it is the short name of a template
+// in the registry, as in grpc-service or grpc-service@v2; a version
+// given with the name overrides the registry's. Any other src is
+// returned unchanged.
+func resolveName(src string) string {
+	name, vers, hasVers := strings.Cut(src, "@")
+	if gonew.IsLocal(src) || strings.ContainsAny(name, "./") || name == "" {
+		return src
+	}
+	t, ok := readRegistry().Lookup(name)
+	if !ok {
+		loc, _ := registryLocation()
+		fatalf("%s: not a module path, and no template of that name in the registry %s", name, loc)
+	}
+	if !hasVers {
+		vers = t.Version
+	}
+	repo := templateSource(t, vers)
+	infof("%s is %s", name, repo)
+	return repo
+}
+
+// overlayList returns the templates the -overlay flags name, or nil
+// if there are none.
+func overlayList() []gonew.Overlay {
+	var list []gonew.Overlay
+	for _, arg := range overlays {
+		repo, subdir, vers := parseSrc(arg)
+		list = append(list, gonew.Overlay{SrcRepo: repo, Subdir: subdir, Version: vers})
+	}
+	return list
+}
+
+// parseSrc splits the src argument, repo[//subdir][@version] or the short
+// name of a template in the registry, into its repository, subdirectory,
+// and version.
+func parseSrc(arg string) (repo, subdir, vers string) {
+	arg = resolveName(arg)
+	repo, vers, hasVers := strings.Cut(arg, "@")
+	if hasVers && vers == "" {
+		// Most likely an unset shell variable, as in repo@$VERSION;
+		// silently using the default branch instead would be surprising.
+		fatalf("%s: missing version after @", arg)
+	}
+
+	// A template in a subdirectory of a repository is named repo//subdir.
+	// The file:// of a local template is no such separator, and a local
+	// template's subdirectory is simply a directory itself.
+	scheme, rest := "", repo
+	if r, ok := strings.CutPrefix(repo, "file://"); ok {
+		scheme, rest = "file://", r
+	}
+	if r, sub, ok := strings.Cut(rest, "//"); ok {
+		if sub == "" {
+			fatalf("%s: missing subdirectory after //", arg)
+		}
+		repo, subdir = scheme+r, sub
+		if gonew.IsLocal(repo) {
+			repo, subdir = repo+"/"+sub, ""
+		}
+	}
+	return repo, subdir, vers
+}
+
+// templateSource returns the src argument naming the registry template t
+// at version vers: repo[//subdir][@vers].
+func templateSource(t gonew.RegistryTemplate, vers string) string {
+	src := t.Repo
+	if t.Subdir != "" {
+		src += "//" + t.Subdir
+	}
+	if vers != "" {
+		src += "@" + vers
+	}
+	return src
+}
+
+// listTemplates implements "gonew list".
+func listTemplates(args []string) {
+	if len(args) != 0 {
+		usage()
+	}
+	reg := readRegistry()
+	if reg == nil {
+		loc, _ := registryLocation()
+		fatalf("no template registry at %s", loc)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, t := range reg.Templates {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", t.Name, templateSource(t, t.Version), t.Describe(cacheDir()))
+	}
+	w.Flush()
+}
+
+// listCache implements "gonew cache list".
+func listCache() {
+	list, err := gonew.CachedTemplates(cacheDir())
+	if err != nil {
+		fatal(err)
+	}
+	for _, t := range list {
+		name := t.Repo
+		if t.Version != "" {
+			name += "@" + t.Version
+		}
+		if t.Commit != "" {
+			name += " " + t.Commit
+		}
+		fmt.Println(name)
+	}
+}
+
+// version and commit, if set with -ldflags "-X main.version=... -X
+// main.commit=...", as for a release, override those the go command
+// records in the binary.
+var version, commit string
+
+// A buildInfo is what gonew version prints.
+type buildInfo struct {
+	Version  string `json:"version"`
+	Commit   string `json:"commit,omitempty"`
+	Time     string `json:"time,omitempty"` // commit time, in RFC 3339 format
+	Modified bool   `json:"modified,omitempty"`
+	Go       string `json:"go"`
+	OS       string `json:"os"`
+	Arch     string `json:"arch"`
+}
+
+// readBuildInfo returns the build information of the running binary.
+func readBuildInfo() buildInfo {
+	b := buildInfo{Version: "(devel)", Go: runtime.Version(), OS: runtime.GOOS, Arch: runtime.GOARCH}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Version != "" {
+			b.Version = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				b.Commit = s.Value
+			case "vcs.time":
+				b.Time = s.Value
+			case "vcs.modified":
+				b.Modified = s.Value == "true"
+			}
+		}
+	}
+	if version != "" {
+		b.Version = version
+	}
+	if commit != "" && commit != b.Commit {
+		b.Commit, b.Time, b.Modified = commit, "", false
+	}
+	return b
+}
+
+// printVersion implements "gonew version".
+func printVersion(args []string) {
+	if len(args) != 0 {
+		usage()
+	}
+	b := readBuildInfo()
+	if *jsonOut {
+		printJSON(b)
+		return
+	}
+	fmt.Printf("gonew %s %s/%s %s\n", b.Version, b.OS, b.Arch, b.Go)
+	if b.Commit != "" {
+		line := "commit " + b.Commit
+		if b.Time != "" {
+			line += " of " + b.Time
+		}
+		if b.Modified {
+			line += ", with uncommitted changes"
+		}
+		fmt.Println(line)
+	}
+}
+
 func main() {
 	log.SetPrefix("gonew: ")
 	log.SetFlags(0)
@@ -64,166 +1218,847 @@ func main() {
 	flag.Parse()
 	args := flag.Args()
 
+	// A subcommand may be followed by more flags. Anything else is the
+	// original form, gonew src dstmod, which is short for gonew new src dstmod.
+	cmd := ""
+	if len(args) > 0 {
+		if slices.Contains(commands, args[0]) {
+			cmd = args[0]
+			flag.CommandLine.Parse(args[1:])
+			args = flag.Args()
+		}
+	}
+	setupColor()
+	setupLog()
+	if cmd != "version" && cmd != "completion" {
+		readConfig()
+	}
+
+	switch {
+	case cmd == "add":
+		addTemplate(args)
+		return
+	case cmd == "lint":
+		lintTemplate(args)
+		return
+	case cmd == "templatize":
+		templatize(args)
+		return
+	case cmd == "list":
+		listTemplates(args)
+		return
+	case cmd == "cache":
+		cacheCommand(args)
+		return
+	case cmd == "completion":
+		completion(args)
+		return
+	case cmd == "version":
+		printVersion(args)
+		return
+	case cmd == "update" || *update:
+		updateModule(args)
+		return
+	case *rehome:
+		rehomeModule(args)
+		return
+	case *importsOnly:
+		renameImports(args)
+		return
+	}
+	if *noCache && *offline {
+		fatal("-no-cache cannot be combined with -offline")
+	}
+
 	if len(args) < 1 || len(args) > 3 {
 		usage()
 	}
 
-	srcRepo := args[0]
-	srcRepoVers := ""
-	if strings.Contains(srcRepo, "@") {
-		srcRepoVers = strings.Split(srcRepo, "@")[1]
+	if *protocol != "ssh" && *protocol != "https" {
+		fatalf("invalid -protocol %q: want ssh or https", *protocol)
 	}
-	_ = srcRepoVers
 
-	dstRepo := srcRepo
+	srcRepo, subdir, srcRepoVers := parseSrc(args[0])
+
+	srcMod := srcRepo
+	if subdir != "" {
+		srcMod = srcRepo + "/" + subdir
+	}
+	if gonew.IsLocal(srcRepo) {
+		var err error
+		srcMod, err = gonew.LocalModulePath(srcRepo)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	if *inferModule && (len(args) >= 2 || *dstHost != "") {
+		fatal("-infer-module cannot be combined with a dstmod argument or -dst-host")
+	}
+	answers := readAnswers()
+	dstRepo := srcMod
+	inferred := false
 	if len(args) >= 2 {
 		dstRepo = args[1]
+		if err := gonew.CheckModulePath(dstRepo); err != nil {
+			fatalf("invalid new module path: %v", err)
+		}
+	} else if answers.Module != "" {
+		dstRepo = answers.Module
+		if err := gonew.CheckModulePath(dstRepo); err != nil {
+			fatalf("%s: %v", *answersFile, err)
+		}
+	} else if *inferModule {
+		repo := "."
+		if *dirFlag != "" {
+			repo = *dirFlag
+		}
+		ctx, stop := runContext()
+		var err error
+		dstRepo, err = gonew.InferModulePath(ctx, repo)
+		stop()
+		if err != nil {
+			fatalf("-infer-module: %v", err)
+		}
+		infof("using module path %s, from the origin remote of %s", dstRepo, repo)
+		inferred = true
+	} else if *dstHost != "" {
+		_, rest, ok := strings.Cut(srcMod, "/")
+		if !ok {
+			fatalf("-dst-host: %s has no host to replace", srcMod)
+		}
+		dstRepo = strings.TrimSuffix(*dstHost, "/") + "/" + rest
+		if err := gonew.CheckModulePath(dstRepo); err != nil {
+			fatalf("-dst-host: %v", err)
+		}
+	} else if interactive() {
+		var err error
+		dstRepo, err = ask("new module path", srcMod, gonew.CheckModulePath)
+		if err != nil {
+			fatal(err)
+		}
+	}
+	checkModule(dstRepo)
+	dir := path.Base(dstRepo)
+	if inferred {
+		dir = "." // the repository whose remote named the module
+	}
+	if *dirFlag != "" {
+		if len(args) == 3 {
+			fatal("-dir cannot be combined with a dir argument")
+		}
+		dir = *dirFlag
+	}
+	if len(args) == 3 {
+		dir = args[2]
 	}
-	dstRepoNameSlice := strings.Split(dstRepo, "/")
-	dstRepoName := dstRepoNameSlice[len(dstRepoNameSlice)-1]
-	_ = dstRepoName
-	// github.com/<org>/<project> -> github.com:<org>/<project>
-	githubURL := strings.Replace(srcRepo, "/", ":", 1)
-	_ = githubURL
 
-	// Clone the source repo
-	giturl := fmt.Sprintf("%s@%s.git", "git", githubURL)
+	if *keepHistory {
+		*keepGit, *renameOrigin, *gitCommit = true, true, true
+	}
+	holder, lic := *org, *license
+	if holder == "" && *author == "" {
+		holder = policy.Org
+	}
+	if lic == "" {
+		lic = policy.License
+	}
 
-	var stdout, stderr bytes.Buffer
-	cmd := exec.Command("git", "clone", giturl, dstRepoName)
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		log.Fatalf("git clone %s: %v\n%s%s", srcRepo, err, stderr.Bytes(), stdout.Bytes())
+	opts := gonew.Options{
+		RewriteOptions:   rewriteOptions(),
+		SrcRepo:          srcRepo,
+		Subdir:           subdir,
+		Version:          srcRepoVers,
+		DstMod:           dstRepo,
+		Dir:              dir,
+		HTTPS:            *useHTTPS || *protocol == "https",
+		NoFallback:       *noFallback,
+		Token:            accessToken(),
+		Proxy:            *useProxy,
+		RequireCommit:    *requireCommit,
+		VerifySignature:  *verifySig,
+		RequireSumDB:     *requireSumDB,
+		AllowedTemplates: policy.AllowedTemplates,
+		Submodules:       *submodulesFlag,
+		Overlays:         overlayList(),
+		FullClone:        *fullClone || *keepGit,
+		Progress:         progress(),
+		CacheDir:         cacheDir(),
+		Offline:          *offline,
+		Exclude:          excludes,
+		Features:         features(answers),
+		RenamePaths:      *renamePathsFlag,
+		Vars:             vars,
+		DefaultVars:      policy.Vars,
+		Subst:            *subst,
+		AllowMissingVars: *allowMissing,
+		GitInit:          *gitInit && !*emitPatch,
+		GitCommit:        *gitCommit || *push,
+		SetOrigin:        *setOrigin || *push,
+		Push:             *push,
+		KeepGit:          *keepGit,
+		RenameOrigin:     *renameOrigin,
+		Record:           *recordFlag || policy.Record,
+		Gitignore:        *gitignore,
+		GitignoreMerge:   *gitignoreMerge,
+		Author:           *author,
+		Org:              holder,
+		License:          lic,
+		Year:             *year,
+		TouchModTime:     *touch,
+		Keep:             *keep,
+		Force:            *force,
+		AllowExisting:    *allowExisting,
+	}
+	if !*dryRun {
+		opts.Tidy = *tidy
+		opts.Format = *format
+		opts.Verify = *verify
+		for _, h := range append(hooks, policy.Exec...) {
+			opts.Exec = append(opts.Exec, strings.Fields(h))
+		}
+		opts.RunHooks = *runHooks
+	}
+	if interactive() {
+		opts.Ask = askVar
+	}
+	if *mtime != "" {
+		t, err := parseTime(*mtime)
+		if err != nil {
+			fatalf("invalid -mtime: %v", err)
+		}
+		opts.ModTime = t
 	}
 
-	// get now working directory
-	wd, err := os.Getwd()
-	if err != nil {
-		log.Fatalf("get working directory: %v", err)
+	// With -patch, build the module in a scratch directory
+	// and write it out as a patch once it is complete.
+	// With -tmp, build it in a fresh temporary directory and leave it there.
+	// With -n, rewrite a scratch clone only to report the changes.
+	if *keepGit {
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "git" && *gitInit || f.Name == "set-origin" && *setOrigin || f.Name == "push" && *push {
+				fatalf("-keep-git and -%s are mutually exclusive", f.Name)
+			}
+		})
+	}
+	if n := countTrue(*emitPatch, *useTmp, *dryRun); n > 1 {
+		fatal("only one of -patch, -tmp, and -n may be given")
+	}
+	if *force && *allowExisting {
+		fatal("-force and -allow-existing are mutually exclusive")
+	}
+	checkTrustFlags()
+	if *jsonOut && (*emitPatch || *dryRun) {
+		fatal("-json cannot be combined with -patch or -n")
+	}
+	if *push && (*emitPatch || *dryRun || !*gitInit) {
+		fatal("-push cannot be combined with -patch, -n, or -git=false")
+	}
+	tmpdir := ""
+	if *emitPatch || *useTmp || *dryRun {
+		var err error
+		tmpdir, err = os.MkdirTemp("", "gonew-")
+		if err != nil {
+			fatal(err)
+		}
+		opts.Dir = filepath.Join(tmpdir, filepath.Base(dir))
+	}
+	if *dryRun {
+		// Keep a copy of the template's files to diff against.
+		opts.Baseline = filepath.Join(tmpdir, "baseline")
+		opts.GitInit, opts.GitCommit = false, false
+	}
+
+	// A failure removes the scratch directory; Clone removes the module itself.
+	fail := func(err error) {
+		var verr *gonew.VerifyError
+		switch {
+		case tmpdir == "":
+		case *keep || errors.As(err, &verr):
+			warnf("keeping %s", tmpdir)
+		default:
+			os.RemoveAll(tmpdir)
+		}
+		var nerr *gonew.NotEmptyError
+		if errors.As(err, &nerr) {
+			fatalf("%v; -force or -allow-existing writes into it anyway", err)
+		}
+		fatal(err)
 	}
 
-	dst := path.Join(wd, dstRepoName)
+	// An interrupt stops the clone, which then removes what it created.
+	ctx, stop := runContext()
+	res, err := gonew.CloneContext(ctx, opts)
+	err = contextErr(ctx, err)
+	stop()
+	if err != nil {
+		fail(err)
+	}
+	reportSkipped(res.Skipped)
+	saveAnswers(dstRepo, res)
 
-	var gitdir string = ""
-	// Change project go module name to dstRepo
-	filepath.WalkDir(dst, func(src string, d fs.DirEntry, err error) error {
+	if *dryRun {
+		dst, err := filepath.Abs(dir)
 		if err != nil {
-			log.Fatal(err)
+			fail(err)
+		}
+		if *listChanged {
+			// Just the files that would change, for piping elsewhere.
+			printChanged(os.Stdout, res.Rewritten)
+		} else {
+			fmt.Printf("module %s would be created in %s\n", dstRepo, dst)
+			if err := printTree(os.Stdout, res.Dir, res.Rewritten); err != nil {
+				fail(err)
+			}
+			if err := printDiff(os.Stdout, opts.Baseline, res.Dir, res.Rewritten); err != nil {
+				fail(err)
+			}
 		}
+		if err := os.RemoveAll(tmpdir); err != nil {
+			fatal(err)
+		}
+		return
+	}
 
-		if d.IsDir() && d.Name() == ".git" {
-			gitdir = src
+	if *listChanged {
+		w := os.Stdout
+		if *emitPatch || *jsonOut {
+			w = os.Stderr
+		}
+		printChanged(w, res.Rewritten)
+	}
 
-			return nil
+	if *previewTree {
+		w := os.Stdout
+		if *emitPatch || *jsonOut {
+			w = os.Stderr
+		}
+		if err := printTree(w, res.Dir, res.Rewritten); err != nil {
+			fail(err)
 		}
+	}
 
-		// check *.go files
-		// fix go file
-		isRoot := !strings.Contains(src, string(filepath.Separator))
-		if strings.HasSuffix(src, ".go") {
-			data, err := os.ReadFile(src)
-			if err != nil {
-				log.Fatal(".go err:", err)
-			}
+	if *emitPatch {
+		if err := writePatch(os.Stdout, res.Dir, colorsFor(os.Stdout)); err != nil {
+			fail(err)
+		}
+		if err := os.RemoveAll(tmpdir); err != nil {
+			fail(err)
+		}
+	}
 
-			data = fixGo(data, src, srcRepo, dstRepo, isRoot)
+	if *jsonOut {
+		printJSON(res)
+	} else if *useTmp {
+		fmt.Println(res.Dir)
+	}
+}
 
-			if err := os.WriteFile(src, data, 0666); err != nil {
-				log.Fatal("write:", err)
-			}
+// runContext returns the context in which to run a command, canceled by
+// an interrupt or a termination signal, or with -timeout, once the timeout
+// passes, along with the function releasing it.
+func runContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if *timeout <= 0 {
+		return ctx, stop
+	}
+	ctx, cancel := context.WithTimeoutCause(ctx, *timeout, fmt.Errorf("timed out after %v", *timeout))
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// contextErr returns err, saying why if it is the result of ctx ending.
+func contextErr(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	cause := context.Cause(ctx)
+	if errors.Is(cause, context.Canceled) {
+		cause = errors.New("interrupted")
+	}
+	if errors.Is(err, ctx.Err()) {
+		return cause
+	}
+	return fmt.Errorf("%v: %w", cause, err)
+}
+
+// printJSON prints v to standard output as indented JSON.
+func printJSON(v any) {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		fatal(err)
+	}
+	os.Stdout.Write(append(data, '\n'))
+}
+
+// rewriteOptions returns the rewrite options set by the command-line flags.
+func rewriteOptions() gonew.RewriteOptions {
+	opts := gonew.RewriteOptions{
+		Include:         includes,
+		MaxFileSize:     *maxSize,
+		GoVersion:       *goVersion,
+		Jobs:            *jobs,
+		Strict:          *strict,
+		RewriteStrings:  *rewriteStrings,
+		RewriteComments: *rewriteComments,
+		RewriteCodegen:  *codegen,
+		RenameUses:      *renameUses,
+		Warnf:           warnf,
+	}
+	if *verbose {
+		opts.Logf = infof
+	}
+	if *rewriteText {
+		opts.RewriteExt = append(opts.RewriteExt, gonew.TextFiles...)
+	}
+	if *rewriteExt != "" {
+		opts.RewriteExt = append(opts.RewriteExt, strings.Split(*rewriteExt, ",")...)
+	}
+	opts.SkipDirs = []string{}
+	if *skipDirs != "" {
+		opts.SkipDirs = strings.Split(*skipDirs, ",")
+	}
+	for _, r := range replaces {
+		old, new, ok := strings.Cut(r, "=")
+		if !ok || old == "" {
+			fatalf("invalid -replace %q: want old=new", r)
+		}
+		opts.Replace = append(opts.Replace, gonew.Replacement{Old: old, New: new, Words: *replaceWords, Cases: *replaceCases})
+	}
+	if *renameFile != "" {
+		renames, err := gonew.ReadRenames(*renameFile)
+		if err != nil {
+			fatal(err)
 		}
+		opts.Renames = renames
+	}
+	return opts
+}
 
-		if strings.HasSuffix(src, "go.mod") {
-			data, err := os.ReadFile(src)
-			if err != nil {
-				log.Fatal(".go err:", err)
-			}
-			data = fixGoMod(data, dstRepo)
+// rehomeModule implements -rehome: args are the directory of an existing
+// module and its new module path. It rewrites the module in place,
+// leaving any .git directory alone.
+func rehomeModule(args []string) {
+	if len(args) != 2 {
+		usage()
+	}
+	if *emitPatch || *offline {
+		fatal("-rehome cannot be combined with -patch or -offline")
+	}
+	dir, dstMod := args[0], args[1]
+	if err := gonew.CheckModulePath(dstMod); err != nil {
+		fatalf("invalid new module path: %v", err)
+	}
+	checkModule(dstMod)
+	ctx, stop := runContext()
+	res, err := gonew.RehomeContext(ctx, dir, dstMod, rewriteOptions())
+	err = contextErr(ctx, err)
+	stop()
+	if err != nil {
+		fatal(err)
+	}
+	reportSkipped(res.Skipped)
+	if *listChanged {
+		printChanged(os.Stdout, res.Rewritten)
+	}
+	if *previewTree {
+		if err := printTree(os.Stdout, res.Dir, res.Rewritten); err != nil {
+			fatal(err)
+		}
+	}
+}
 
-			if err := os.WriteFile(src, data, 0666); err != nil {
-				log.Fatal("write2:", err)
-			}
+// addTemplate implements gonew add: args are the template
+// and optionally the directory of the module to add it to, by default ".".
+func addTemplate(args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		usage()
+	}
+	if *emitPatch || *dryRun || *useTmp || *rehome || *recordFlag {
+		fatal("gonew add cannot be combined with -patch, -n, -tmp, -rehome, or -record")
+	}
+	if *force && *allowExisting {
+		fatal("-force and -allow-existing are mutually exclusive")
+	}
+	checkTrustFlags()
+	srcRepo, subdir, vers := parseSrc(args[0])
+	dir := "."
+	if len(args) == 2 {
+		dir = args[1]
+	}
+	answers := readAnswers()
+	opts := gonew.Options{
+		RewriteOptions:   rewriteOptions(),
+		SrcRepo:          srcRepo,
+		Subdir:           subdir,
+		Version:          vers,
+		HTTPS:            *useHTTPS || *protocol == "https",
+		NoFallback:       *noFallback,
+		Token:            accessToken(),
+		Proxy:            *useProxy,
+		RequireCommit:    *requireCommit,
+		VerifySignature:  *verifySig,
+		RequireSumDB:     *requireSumDB,
+		AllowedTemplates: policy.AllowedTemplates,
+		Submodules:       *submodulesFlag,
+		Overlays:         overlayList(),
+		Progress:         progress(),
+		CacheDir:         cacheDir(),
+		Offline:          *offline,
+		Exclude:          excludes,
+		Features:         features(answers),
+		RenamePaths:      *renamePathsFlag,
+		Vars:             vars,
+		DefaultVars:      policy.Vars,
+		Subst:            *subst,
+		AllowMissingVars: *allowMissing,
+		Tidy:             *tidy,
+		Force:            *force,
+		AllowExisting:    *allowExisting,
+	}
+	if interactive() {
+		opts.Ask = askVar
+	}
+	ctx, stop := runContext()
+	res, err := gonew.Add(ctx, dir, opts)
+	err = contextErr(ctx, err)
+	stop()
+	var eerr *gonew.ExistError
+	if errors.As(err, &eerr) {
+		fatalf("%v; -force overwrites them, -allow-existing keeps them", err)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	reportSkipped(res.Skipped)
+	saveAnswers(res.Module, res)
+	if *jsonOut {
+		printJSON(res)
+		return
+	}
+	printChanged(os.Stdout, res.Files)
+}
+
+// lintTemplate implements gonew lint: args is the template to check.
+func lintTemplate(args []string) {
+	if len(args) != 1 {
+		usage()
+	}
+	if *emitPatch || *dryRun || *useTmp || *rehome || *recordFlag {
+		fatal("gonew lint cannot be combined with -patch, -n, -tmp, -rehome, or -record")
+	}
+	checkTrustFlags()
+	srcRepo, subdir, vers := parseSrc(args[0])
+	answers := readAnswers()
+	opts := gonew.Options{
+		RewriteOptions:   rewriteOptions(),
+		SrcRepo:          srcRepo,
+		Subdir:           subdir,
+		Version:          vers,
+		HTTPS:            *useHTTPS || *protocol == "https",
+		NoFallback:       *noFallback,
+		Token:            accessToken(),
+		Proxy:            *useProxy,
+		RequireCommit:    *requireCommit,
+		VerifySignature:  *verifySig,
+		RequireSumDB:     *requireSumDB,
+		AllowedTemplates: policy.AllowedTemplates,
+		Submodules:       *submodulesFlag,
+		Progress:         progress(),
+		CacheDir:         cacheDir(),
+		Offline:          *offline,
+		Exclude:          excludes,
+		Features:         features(answers),
+		RenamePaths:      *renamePathsFlag,
+		Vars:             vars,
+		DefaultVars:      policy.Vars,
+		Subst:            *subst,
+		AllowMissingVars: *allowMissing,
+		Tidy:             *tidy,
+	}
+	ctx, stop := runContext()
+	problems, err := gonew.Lint(ctx, opts)
+	err = contextErr(ctx, err)
+	stop()
+	if err != nil {
+		fatal(err)
+	}
+	if *jsonOut {
+		if problems == nil {
+			problems = []gonew.LintProblem{}
 		}
+		printJSON(problems)
+	} else {
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+}
 
-		return nil
-	})
+// templatize implements gonew templatize: args are the project directory
+// and optionally the directory of the template to create.
+func templatize(args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		usage()
+	}
+	dir, err := filepath.Abs(args[0])
+	if err != nil {
+		fatal(err)
+	}
+	out := dir + "-template"
+	if len(args) == 2 {
+		out = args[1]
+	}
+	opts := gonew.TemplatizeOptions{
+		RewriteOptions: rewriteOptions(),
+		Parameterize:   *parameterize,
+	}
+	ctx, stop := runContext()
+	res, err := gonew.Templatize(ctx, dir, out, opts)
+	err = contextErr(ctx, err)
+	stop()
+	if err != nil {
+		fatal(err)
+	}
+	if *jsonOut {
+		printJSON(res)
+		return
+	}
+	infof("created template %s in %s", res.Module, res.Dir)
+	for _, name := range res.Removed {
+		fmt.Printf("left out %s\n", name)
+	}
+	for _, c := range res.Candidates {
+		fmt.Printf("{{.%s}}: %s, in %s\n", c.Var, c.Value, strings.Join(c.Files, ", "))
+	}
+	if len(res.Candidates) > 0 && !res.Parameterized {
+		fmt.Printf("uncomment their replacements in %s, or rerun with -parameterize, to use them\n", filepath.Join(res.Dir, "gonew.yaml"))
+	}
+}
 
-	// Remove .git directory
-	if gitdir != "" {
-		if err := os.RemoveAll(gitdir); err != nil {
-			log.Fatal("remove .git:", err)
+// updateModule implements gonew update: args are the directory of a module
+// created with -record, by default ".", and optionally the template
+// version to update to.
+func updateModule(args []string) {
+	if len(args) > 2 {
+		usage()
+	}
+	if *emitPatch || *dryRun || *useTmp || *rehome {
+		fatal("-update cannot be combined with -patch, -n, -tmp, or -rehome")
+	}
+	checkTrustFlags()
+	dir, vers := ".", ""
+	if len(args) >= 1 {
+		dir = args[0]
+	}
+	if len(args) == 2 {
+		vers = args[1]
+	}
+	answers := readAnswers()
+	opts := gonew.Options{
+		RewriteOptions:   rewriteOptions(),
+		Version:          vers,
+		HTTPS:            *useHTTPS || *protocol == "https",
+		NoFallback:       *noFallback,
+		Token:            accessToken(),
+		Proxy:            *useProxy,
+		RequireCommit:    *requireCommit,
+		VerifySignature:  *verifySig,
+		RequireSumDB:     *requireSumDB,
+		AllowedTemplates: policy.AllowedTemplates,
+		Submodules:       *submodulesFlag,
+		Overlays:         overlayList(),
+		Progress:         progress(),
+		CacheDir:         cacheDir(),
+		Offline:          *offline,
+		Exclude:          excludes,
+		Features:         features(answers),
+		RenamePaths:      *renamePathsFlag,
+		Vars:             vars,
+		DefaultVars:      policy.Vars,
+		Subst:            *subst,
+		AllowMissingVars: *allowMissing,
+	}
+	if interactive() {
+		opts.Ask = askVar
+	}
+	ctx, stop := runContext()
+	res, err := gonew.Update(ctx, dir, opts)
+	err = contextErr(ctx, err)
+	stop()
+	if err != nil {
+		fatal(err)
+	}
+	if *jsonOut {
+		printJSON(res)
+	} else {
+		for _, f := range []struct {
+			status string
+			names  []string
+		}{
+			{"M", res.Updated},
+			{"A", res.Added},
+			{"D", res.Deleted},
+			{"C", res.Conflicts},
+		} {
+			for _, name := range f.names {
+				fmt.Printf("%s %s\n", f.status, name)
+			}
 		}
 	}
+	if len(res.Conflicts) > 0 {
+		fatal("the files marked C conflict with the template; resolve them by hand")
+	}
 }
 
-// fixGo rewrites the Go source in data to replace srcMod with dstMod.
-// isRoot indicates whether the file is in the root directory of the module,
-// in which case we also update the package name.
-func fixGo(data []byte, file string, srcMod, dstMod string, isRoot bool) []byte {
-	fset := token.NewFileSet()
-	f, err := parser.ParseFile(fset, file, data, parser.ImportsOnly)
+// renameImports implements -rename-imports-only: args is a single
+// directory, in which it rewrites the Go import paths at or below -old
+// to be at or below -new instead, and changes nothing else.
+func renameImports(args []string) {
+	if len(args) != 1 || *oldPath == "" || *newPath == "" {
+		usage()
+	}
+	res, err := gonew.RenameImports(args[0], *oldPath, *newPath, rewriteOptions())
 	if err != nil {
-		log.Fatalf("parsing source module:\n%s", err)
+		fatal(err)
+	}
+	reportSkipped(res.Skipped)
+	if *listChanged {
+		printChanged(os.Stdout, res.Rewritten)
 	}
+}
 
-	buf := edit.NewBuffer(data)
-	at := func(p token.Pos) int {
-		return fset.File(p).Offset(p)
+// printChanged writes the names in rewritten to w, one per line.
+func printChanged(w io.Writer, rewritten []string) {
+	for _, name := range rewritten {
+		fmt.Fprintln(w, name)
 	}
+}
 
-	srcName := path.Base(srcMod)
-	dstName := path.Base(dstMod)
-	if isRoot {
-		if name := f.Name.Name; name == srcName || name == srcName+"_test" {
-			dname := dstName + strings.TrimPrefix(name, srcName)
-			if !token.IsIdentifier(dname) {
-				log.Fatalf("%s: cannot rename package %s to package %s: invalid package name", file, name, dname)
-			}
-			buf.Replace(at(f.Name.Pos()), at(f.Name.End()), dname)
+// reportSkipped reports the skipped files: each of them, with -v, or else
+// just how many Go files did not parse, since those are copied with the
+// template's module path still in their imports.
+func reportSkipped(skipped []gonew.SkippedFile) {
+	if *verbose {
+		printSkipped(skipped)
+		return
+	}
+	n := 0
+	for _, e := range skipped {
+		if e.Reason == gonew.SkipParseError {
+			n++
 		}
 	}
+	if n > 0 {
+		warnf("%d Go files did not parse and were copied without rewriting; -strict makes this an error", n)
+	}
+}
 
-	for _, spec := range f.Imports {
-		path, err := strconv.Unquote(spec.Path.Value)
-		if err != nil {
-			continue
-		}
-
-		if path == srcMod {
-			if srcName != dstName && spec.Name == nil {
-				// Add package rename because source code uses original name.
-				// The renaming looks strange, but template authors are unlikely to
-				// create a template where the root package is imported by packages
-				// in subdirectories, and the renaming at least keeps the code working.
-				// A more sophisticated approach would be to rename the uses of
-				// the package identifier in the file too, but then you have to worry about
-				// name collisions, and given how unlikely this is, it doesn't seem worth
-				// trying to clean up the file that way.
-				buf.Insert(at(spec.Path.Pos()), srcName+" ")
+// printSkipped logs each skipped file and the reason it was skipped.
+func printSkipped(skipped []gonew.SkippedFile) {
+	for _, e := range skipped {
+		infof("skipped %s: %s", e.Path, e.Reason)
+	}
+}
+
+// printDiff writes to w a unified diff of the rewritten files in the new
+// module in dir against their originals in the directory base. It stages
+// base in a git index of its own, so that git can compare the two trees
+// whether or not the template came with a git history.
+func printDiff(w io.Writer, base, dir string, rewritten []string) error {
+	if len(rewritten) == 0 {
+		return nil
+	}
+	gitdir := filepath.Join(base, ".git")
+	if out, err := exec.Command("git", "init", "-q", base).CombinedOutput(); err != nil {
+		return fmt.Errorf("git init: %v\n%s", err, out)
+	}
+	if out, err := exec.Command("git", "-C", base, "add", "-A", "-f").CombinedOutput(); err != nil {
+		return fmt.Errorf("git add: %v\n%s", err, out)
+	}
+	args := []string{"--git-dir", gitdir, "--work-tree", dir, "diff", "--no-ext-diff", "--color=never"}
+	if colorsFor(os.Stdout).on {
+		args[len(args)-1] = "--color=always"
+	}
+	args = append(args, "--")
+	args = append(args, rewritten...)
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git diff: %v\n%s", err, stderr.Bytes())
+	}
+	return nil
+}
+
+// countTrue returns the number of its arguments that are true.
+func countTrue(bs ...bool) int {
+	n := 0
+	for _, b := range bs {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+// checkModule exits if the new module path dstMod breaks the rules
+// of the -require-prefix and -require-pattern flags or of the policy.
+func checkModule(dstMod string) {
+	// gonew.ReadConfig has already checked the policy's require_pattern,
+	// naming the policy file if it is invalid.
+	if _, err := regexp.Compile(*pattern); err != nil {
+		fatalf("invalid -require-pattern: %v", err)
+	}
+	if err := checkPolicy(dstMod, prefixes, *pattern); err != nil {
+		fatal(err)
+	}
+	if err := checkPolicy(dstMod, policy.RequirePrefix, policy.RequirePattern); err != nil {
+		file, _ := configLocation()
+		fatalf("%v, as the policy in %s requires", err, file)
+	}
+}
+
+// checkPolicy reports an error if dstMod lies outside every prefix in
+// prefixes or fails to match the regular expression pattern.
+// An empty list of prefixes or an empty pattern imposes no restriction.
+func checkPolicy(dstMod string, prefixes []string, pattern string) error {
+	if len(prefixes) > 0 {
+		ok := false
+		for _, p := range prefixes {
+			p = strings.TrimSuffix(p, "/")
+			if dstMod == p || strings.HasPrefix(dstMod, p+"/") {
+				ok = true
+				break
 			}
-			// Change import path to dstMod
-			buf.Replace(at(spec.Path.Pos()), at(spec.Path.End()), strconv.Quote(dstMod))
 		}
-		if strings.HasPrefix(path, srcMod+"/") {
-			// Change import path to begin with dstMod
-			buf.Replace(at(spec.Path.Pos()), at(spec.Path.End()), strconv.Quote(strings.Replace(path, srcMod, dstMod, 1)))
+		if !ok {
+			return fmt.Errorf("module path %s is not within an allowed prefix (%s)", dstMod, strings.Join(prefixes, ", "))
+		}
+	}
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %s: %v", pattern, err)
+		}
+		if !re.MatchString(dstMod) {
+			return fmt.Errorf("module path %s does not match required pattern %s", dstMod, pattern)
 		}
 	}
-	return buf.Bytes()
+	return nil
 }
 
-// fixGoMod rewrites the go.mod content in data to replace srcMod with dstMod
-// in the module path.
-func fixGoMod(data []byte, dstMod string) []byte {
-	f, err := modfile.ParseLax("go.mod", data, nil)
-	if err != nil {
-		log.Fatalf("parsing source module:\n%s", err)
-	}
-	f.AddModuleStmt(dstMod)
-	new, err := f.Format()
-	if err != nil {
-		return data
+// parseTime parses s as either an RFC 3339 time or a count of Unix seconds.
+func parseTime(s string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
 	}
-	return new
+	return time.Parse(time.RFC3339, s)
 }
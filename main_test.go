@@ -0,0 +1,430 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain runs gonew itself when the test binary is started by runGonew,
+// so that the tests can run the command without building it first.
+func TestMain(m *testing.M) {
+	if os.Getenv("GONEW_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// A run is the result of running gonew.
+type run struct {
+	stdout, stderr string
+	err            error // as from exec.Cmd.Run
+}
+
+// testGitConfig is the global git configuration of the tests, to which
+// fakeGitHub adds.
+const testGitConfig = "[user]\n\tname = Gonew Test\n\temail = test@example.com\n[init]\n\tdefaultBranch = main\n"
+
+// runGonew runs gonew with args in the directory dir, in an environment
+// of its own, without the user's git configuration, template cache, or
+// registry, plus the variables in env, each of the form key=value.
+func runGonew(t *testing.T, dir string, env []string, args ...string) run {
+	t.Helper()
+	home := t.TempDir()
+	gitconfig := filepath.Join(home, "gitconfig")
+	if err := os.WriteFile(gitconfig, []byte(testGitConfig), 0666); err != nil {
+		t.Fatal(err)
+	}
+	policy := filepath.Join(home, "policy.yaml")
+	if err := os.WriteFile(policy, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	for _, kv := range os.Environ() {
+		k, _, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(k, "GONEW_") && !strings.HasPrefix(k, "GIT_") && k != "NO_COLOR" && k != "HOME" && !strings.HasPrefix(k, "XDG_") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Env = append(cmd.Env,
+		"GONEW_TEST_MAIN=1",
+		"HOME="+home,
+		"XDG_CONFIG_HOME="+filepath.Join(home, "config"),
+		"XDG_CACHE_HOME="+filepath.Join(home, "cache"),
+		"GONEW_CONFIG="+policy,
+		"GIT_CONFIG_GLOBAL="+gitconfig,
+		"GIT_CONFIG_NOSYSTEM=1",
+	)
+	cmd.Env = append(cmd.Env, env...) // later values win
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	return run{stdout.String(), stderr.String(), err}
+}
+
+// fixture returns the absolute path of the template testdata/name.
+func fixture(t *testing.T, name string) string {
+	t.Helper()
+	dir, err := filepath.Abs(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// git runs git with args in dir, failing the test if it fails.
+func git(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_CONFIG_GLOBAL="+os.DevNull, "GIT_CONFIG_NOSYSTEM=1",
+		"GIT_AUTHOR_NAME=Gonew Test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=Gonew Test", "GIT_COMMITTER_EMAIL=test@example.com")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+// fakeGitHub makes bare repositories on the local disk standing in for
+// those on github.com, one for each entry in repos, a map from a path
+// such as example/hello to the files to commit to it, as for writeTree
+// in package gonew. It returns environment variables for runGonew that
+// make git clone them in place of the real ones.
+func fakeGitHub(t *testing.T, repos map[string]map[string]string) []string {
+	t.Helper()
+	root := t.TempDir()
+	for name, files := range repos {
+		work := filepath.Join(root, "work", filepath.FromSlash(name))
+		for file, data := range files {
+			file = filepath.Join(work, filepath.FromSlash(file))
+			if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(file, []byte(data), 0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+		git(t, work, "init", "-q", "-b", "main")
+		git(t, work, "add", "-A")
+		git(t, work, "commit", "-q", "-m", "initial")
+		git(t, root, "clone", "-q", "--bare", work, filepath.Join(root, filepath.FromSlash(name)+".git"))
+	}
+	base := "file://" + filepath.ToSlash(root) + "/"
+	config := filepath.Join(root, "gitconfig")
+	data := testGitConfig + "[url \"" + base + "\"]\n\tinsteadOf = git@github.com:\n\tinsteadOf = https://github.com/\n"
+	if err := os.WriteFile(config, []byte(data), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return []string{"GIT_CONFIG_GLOBAL=" + config, "GIT_ALLOW_PROTOCOL=file"}
+}
+
+func TestColor(t *testing.T) {
+	hello := fixture(t, "hello")
+	tests := []struct {
+		name  string
+		env   []string
+		args  []string
+		color bool
+		fail  bool
+	}{
+		{"never", nil, []string{"-color=never", "-n", hello, "example.com/x"}, false, false},
+		{"NO_COLOR", []string{"NO_COLOR=1"}, []string{"-n", hello, "example.com/x"}, false, false},
+		{"always", nil, []string{"-color=always", "-n", hello, "example.com/x"}, true, false},
+		{"never error", nil, []string{"-color=never", "-no-input", filepath.Join(hello, "missing"), "example.com/x"}, false, true},
+		{"always error", nil, []string{"-color=always", "-no-input", filepath.Join(hello, "missing"), "example.com/x"}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := runGonew(t, t.TempDir(), tt.env, tt.args...)
+			if failed := r.err != nil; failed != tt.fail {
+				t.Fatalf("gonew %s: failed = %v, want %v\n%s", strings.Join(tt.args, " "), failed, tt.fail, r.stderr)
+			}
+			out := r.stdout + r.stderr
+			if got := strings.Contains(out, "\x1b["); got != tt.color {
+				t.Errorf("gonew %s: ANSI escapes written = %v, want %v\n%s", strings.Join(tt.args, " "), got, tt.color, out)
+			}
+		})
+	}
+}
+
+func TestDryRunSubdir(t *testing.T) {
+	env := fakeGitHub(t, map[string]map[string]string{
+		"example/mono": {
+			"README.md":             "Templates.\n",
+			"svc/go.mod":            "module github.com/example/mono/svc\n\ngo 1.23\n",
+			"svc/main.go":           "package main\n\nimport _ \"github.com/example/mono/svc/internal/db\"\n\nfunc main() {}\n",
+			"svc/internal/db/db.go": "package db\n",
+		},
+	})
+	dir := t.TempDir()
+	r := runGonew(t, dir, env, "-n", "-color=never", "github.com/example/mono//svc", "example.com/svc")
+	if r.err != nil {
+		t.Fatalf("gonew -n github.com/example/mono//svc: %v\n%s", r.err, r.stderr)
+	}
+	if !strings.Contains(r.stdout, `+import _ "example.com/svc/internal/db"`) || !strings.Contains(r.stdout, "+module example.com/svc") {
+		t.Errorf("gonew -n github.com/example/mono//svc printed:\n%s\nwant a diff rewriting the module path", r.stdout)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "svc")); !os.IsNotExist(err) {
+		t.Errorf("gonew -n created the new module: Stat = %v", err)
+	}
+}
+
+func TestDryRunLocal(t *testing.T) {
+	// testdata/hello is not a git repository of its own.
+	dir := t.TempDir()
+	r := runGonew(t, dir, nil, "-n", "-color=never", fixture(t, "hello"), "example.com/hi")
+	if r.err != nil {
+		t.Fatalf("gonew -n testdata/hello: %v\n%s", r.err, r.stderr)
+	}
+	for _, want := range []string{"+module example.com/hi", `+	"example.com/hi"`} {
+		if !strings.Contains(r.stdout, want) {
+			t.Errorf("gonew -n testdata/hello printed:\n%s\nwant a line %q", r.stdout, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "hi")); !os.IsNotExist(err) {
+		t.Errorf("gonew -n created the new module: Stat = %v", err)
+	}
+}
+
+func TestCheckPolicy(t *testing.T) {
+	tests := []struct {
+		dstMod   string
+		prefixes []string
+		pattern  string
+		ok       bool
+	}{
+		{"example.com/x", nil, "", true},
+		{"git.mycorp.com/team/x", []string{"git.mycorp.com"}, "", true},
+		{"git.mycorp.com/team/x", []string{"git.mycorp.com/"}, "", true},
+		{"git.mycorp.com", []string{"git.mycorp.com"}, "", true},
+		{"git.mycorp.com.evil.com/x", []string{"git.mycorp.com"}, "", false},
+		{"github.com/mycorp/x", []string{"git.mycorp.com", "github.com/mycorp"}, "", true},
+		{"github.com/other/x", []string{"git.mycorp.com", "github.com/mycorp"}, "", false},
+		{"git.mycorp.com/team/x", nil, `^git\.mycorp\.com/[a-z]+/`, true},
+		{"git.mycorp.com/x", nil, `^git\.mycorp\.com/[a-z]+/`, false},
+		{"git.mycorp.com/team/x", []string{"git.mycorp.com"}, `/svc-`, false},
+	}
+	for _, tt := range tests {
+		err := checkPolicy(tt.dstMod, tt.prefixes, tt.pattern)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkPolicy(%q, %q, %q) = %v, want ok %v", tt.dstMod, tt.prefixes, tt.pattern, err, tt.ok)
+		}
+	}
+}
+
+func TestRequirePrefix(t *testing.T) {
+	hello := fixture(t, "hello")
+	r := runGonew(t, t.TempDir(), nil, "-require-prefix", "git.mycorp.com", "-require-prefix", "github.com/mycorp", hello, "example.com/x")
+	if r.err == nil || !strings.Contains(r.stderr, "module path example.com/x is not within an allowed prefix (git.mycorp.com, github.com/mycorp)") {
+		t.Errorf("gonew -require-prefix with an outside module path: %v\n%s", r.err, r.stderr)
+	}
+	dir := t.TempDir()
+	r = runGonew(t, dir, nil, "-require-prefix", "git.mycorp.com", "-require-prefix", "github.com/mycorp", hello, "github.com/mycorp/x")
+	if r.err != nil {
+		t.Fatalf("gonew -require-prefix with an allowed module path: %v\n%s", r.err, r.stderr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "x", "go.mod")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRequirePatternInvalid(t *testing.T) {
+	hello := fixture(t, "hello")
+	r := runGonew(t, t.TempDir(), nil, "-require-pattern", "(", hello, "example.com/x")
+	if r.err == nil || !strings.Contains(r.stderr, "invalid -require-pattern") {
+		t.Errorf("gonew -require-pattern '(': %v\n%s\nwant an invalid -require-pattern error", r.err, r.stderr)
+	}
+
+	policy := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(policy, []byte("require_pattern: \"(\"\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	r = runGonew(t, t.TempDir(), []string{"GONEW_CONFIG=" + policy}, hello, "example.com/x")
+	if r.err == nil || !strings.Contains(r.stderr, policy+": invalid require_pattern") || strings.Contains(r.stderr, "-require-pattern") {
+		t.Errorf("gonew with an invalid policy require_pattern: %v\n%s\nwant an error naming %s", r.err, r.stderr, policy)
+	}
+}
+
+func TestParseSrc(t *testing.T) {
+	tests := []struct {
+		arg, repo, subdir, vers string
+	}{
+		{"github.com/example/hello", "github.com/example/hello", "", ""},
+		{"github.com/example/hello@v1.3.0", "github.com/example/hello", "", "v1.3.0"},
+		{"github.com/example/hello@main", "github.com/example/hello", "", "main"},
+		{"github.com/example/mono//svc@v1.0.0", "github.com/example/mono", "svc", "v1.0.0"},
+		{"github.com/example/mono//services/grpc", "github.com/example/mono", "services/grpc", ""},
+		{"file:///tmp/hello@v1", "file:///tmp/hello", "", "v1"},
+		{"./templates//hello", "./templates/hello", "", ""},
+	}
+	for _, tt := range tests {
+		repo, subdir, vers := parseSrc(tt.arg)
+		if repo != tt.repo || subdir != tt.subdir || vers != tt.vers {
+			t.Errorf("parseSrc(%q) = %q, %q, %q, want %q, %q, %q", tt.arg, repo, subdir, vers, tt.repo, tt.subdir, tt.vers)
+		}
+	}
+}
+
+func TestMissingVersion(t *testing.T) {
+	for _, src := range []string{"github.com/example/hello@", "github.com/example/mono//svc@"} {
+		r := runGonew(t, t.TempDir(), nil, src, "example.com/x")
+		if r.err == nil || !strings.Contains(r.stderr, src+": missing version after @") {
+			t.Errorf("gonew %s: %v\n%s\nwant a missing version error", src, r.err, r.stderr)
+		}
+	}
+}
+
+// readModule returns the module path declared in dir/go.mod.
+func readModule(t *testing.T, dir string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod, _, _ := strings.Cut(strings.TrimPrefix(string(data), "module "), "\n")
+	return mod
+}
+
+func TestDstHost(t *testing.T) {
+	hello := fixture(t, "hello")
+	tests := []struct {
+		args       []string
+		dir, want  string
+		failPrefix string
+	}{
+		{args: []string{"-dst-host", "git.mycorp.com", hello}, dir: "hello", want: "git.mycorp.com/example/hello"},
+		{args: []string{"-dst-host", "git.mycorp.com/", hello}, dir: "hello", want: "git.mycorp.com/example/hello"},
+		{args: []string{"-dst-host", "git.mycorp.com", hello, "example.com/mine"}, dir: "mine", want: "example.com/mine"},
+		{args: []string{"-dst-host", "Bad_Host", hello}, failPrefix: "gonew: -dst-host: "},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		r := runGonew(t, dir, nil, tt.args...)
+		if tt.failPrefix != "" {
+			if r.err == nil || !strings.HasPrefix(r.stderr, tt.failPrefix) {
+				t.Errorf("gonew %s: %v\n%s\nwant an error starting %q", strings.Join(tt.args, " "), r.err, r.stderr, tt.failPrefix)
+			}
+			continue
+		}
+		if r.err != nil {
+			t.Errorf("gonew %s: %v\n%s", strings.Join(tt.args, " "), r.err, r.stderr)
+			continue
+		}
+		if got := readModule(t, filepath.Join(dir, tt.dir)); got != tt.want {
+			t.Errorf("gonew %s: module %s, want %s", strings.Join(tt.args, " "), got, tt.want)
+		}
+	}
+}
+
+func TestListChanged(t *testing.T) {
+	hello := fixture(t, "hello")
+	want := "cmd/hello/main.go\ngo.mod\nhello.go\n"
+	dir := t.TempDir()
+	r := runGonew(t, dir, nil, "-list-changed", hello, "example.com/hi")
+	if r.err != nil {
+		t.Fatalf("gonew -list-changed: %v\n%s", r.err, r.stderr)
+	}
+	if r.stdout != want {
+		t.Errorf("gonew -list-changed printed:\n%s\nwant:\n%s", r.stdout, want)
+	}
+	if got := readModule(t, filepath.Join(dir, "hi")); got != "example.com/hi" {
+		t.Errorf("gonew -list-changed created module %s, want example.com/hi", got)
+	}
+
+	dir = t.TempDir()
+	r = runGonew(t, dir, nil, "-n", "-list-changed", hello, "example.com/hi")
+	if r.err != nil {
+		t.Fatalf("gonew -n -list-changed: %v\n%s", r.err, r.stderr)
+	}
+	if r.stdout != want {
+		t.Errorf("gonew -n -list-changed printed:\n%s\nwant:\n%s", r.stdout, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "hi")); !os.IsNotExist(err) {
+		t.Errorf("gonew -n -list-changed created the new module: Stat = %v", err)
+	}
+}
+
+func TestRehome(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.CopyFS(dir, os.DirFS(fixture(t, "hello"))); err != nil {
+		t.Fatal(err)
+	}
+	r := runGonew(t, dir, nil, "-rehome", "-list-changed", ".", "example.com/hi")
+	if r.err != nil {
+		t.Fatalf("gonew -rehome: %v\n%s", r.err, r.stderr)
+	}
+	if want := "cmd/hello/main.go\ngo.mod\nhello.go\n"; r.stdout != want {
+		t.Errorf("gonew -rehome -list-changed printed:\n%s\nwant:\n%s", r.stdout, want)
+	}
+	if got := readModule(t, dir); got != "example.com/hi" {
+		t.Errorf("after gonew -rehome, module %s, want example.com/hi", got)
+	}
+}
+
+func TestTmp(t *testing.T) {
+	tmp := t.TempDir()
+	cwd := t.TempDir()
+	r := runGonew(t, cwd, []string{"TMPDIR=" + tmp}, "-tmp", fixture(t, "hello"), "example.com/hi")
+	if r.err != nil {
+		t.Fatalf("gonew -tmp: %v\n%s", r.err, r.stderr)
+	}
+	dir := strings.TrimSuffix(r.stdout, "\n")
+	if !filepath.IsAbs(dir) || !strings.HasPrefix(dir, tmp+string(filepath.Separator)) || filepath.Base(dir) != "hi" {
+		t.Fatalf("gonew -tmp printed %q, want a directory hi in a new directory in %s", r.stdout, tmp)
+	}
+	if got := readModule(t, dir); got != "example.com/hi" {
+		t.Errorf("gonew -tmp created module %s, want example.com/hi", got)
+	}
+	if entries, err := os.ReadDir(cwd); err != nil || len(entries) != 0 {
+		t.Errorf("gonew -tmp wrote into the current directory: %v, %v", entries, err)
+	}
+
+	r = runGonew(t, cwd, []string{"TMPDIR=" + tmp}, "-tmp", "-json", fixture(t, "hello"), "example.com/hi")
+	if r.err != nil {
+		t.Fatalf("gonew -tmp -json: %v\n%s", r.err, r.stderr)
+	}
+	var res struct {
+		Dir    string `json:"dir"`
+		Module string `json:"module"`
+	}
+	if err := json.Unmarshal([]byte(r.stdout), &res); err != nil {
+		t.Fatalf("gonew -tmp -json printed %q: %v", r.stdout, err)
+	}
+	if res.Module != "example.com/hi" || readModule(t, res.Dir) != "example.com/hi" {
+		t.Errorf("gonew -tmp -json reported module %s in %s, want example.com/hi", res.Module, res.Dir)
+	}
+}
+
+func TestRenameImportsOnly(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.CopyFS(dir, os.DirFS(fixture(t, "hello"))); err != nil {
+		t.Fatal(err)
+	}
+	r := runGonew(t, t.TempDir(), nil, "-rename-imports-only", "-old", "github.com/example/hello", "-new", "x.com/c", "-list-changed", dir)
+	if r.err != nil {
+		t.Fatalf("gonew -rename-imports-only: %v\n%s", r.err, r.stderr)
+	}
+	if r.stdout != "cmd/hello/main.go\n" {
+		t.Errorf("gonew -rename-imports-only -list-changed printed %q, want only cmd/hello/main.go", r.stdout)
+	}
+	if got := readModule(t, dir); got != "github.com/example/hello" {
+		t.Errorf("gonew -rename-imports-only changed the module path to %s", got)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "cmd/hello/main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `hello "x.com/c"`) {
+		t.Errorf("cmd/hello/main.go:\n%s\nwant an import of x.com/c", data)
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// writePatch writes to w a git-style patch that creates
+// every file in the tree rooted at root, colored using pal.
+func writePatch(w io.Writer, root string, pal palette) error {
+	bw := bufio.NewWriter(w)
+	err := filepath.WalkDir(root, func(src string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, src)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var data []byte
+		mode := "100644"
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(src)
+			if err != nil {
+				return err
+			}
+			data = []byte(target)
+			mode = "120000"
+		case info.Mode().IsRegular():
+			data, err = os.ReadFile(src)
+			if err != nil {
+				return err
+			}
+			if info.Mode()&0111 != 0 {
+				mode = "100755"
+			}
+		default:
+			return nil
+		}
+		writeFilePatch(bw, filepath.ToSlash(rel), mode, data, pal)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// writeFilePatch writes the patch creating the file name with the given
+// git mode and content.
+func writeFilePatch(w *bufio.Writer, name, mode string, data []byte, pal palette) {
+	a, b := quotePath("a/"+name), quotePath("b/"+name)
+	meta := func(format string, args ...any) {
+		fmt.Fprintln(w, pal.paint(colorBold, fmt.Sprintf(format, args...)))
+	}
+	meta("diff --git %s %s", a, b)
+	meta("new file mode %s", mode)
+	meta("index %s..%s", strings.Repeat("0", 40), blobHash(data))
+	if len(data) == 0 {
+		return
+	}
+	if isBinary(data) {
+		meta("GIT binary patch")
+		meta("literal %d", len(data))
+		writeBase85(w, deflate(data))
+		fmt.Fprintf(w, "\n")
+		return
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	meta("--- /dev/null")
+	meta("+++ %s", b)
+	if len(lines) == 1 {
+		fmt.Fprintln(w, pal.paint(colorCyan, "@@ -0,0 +1 @@"))
+	} else {
+		fmt.Fprintln(w, pal.paint(colorCyan, fmt.Sprintf("@@ -0,0 +1,%d @@", len(lines))))
+	}
+	for _, line := range lines {
+		text, nl := strings.CutSuffix(line, "\n")
+		w.WriteString(pal.paint(colorGreen, "+"+text))
+		if nl {
+			w.WriteString("\n")
+		}
+	}
+	if !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		w.WriteString("\n\\ No newline at end of file\n")
+	}
+}
+
+// isBinary reports whether data looks like binary content,
+// using the same heuristic as git: a NUL byte in the first 8000 bytes.
+func isBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// blobHash returns the git object name of a blob holding data.
+func blobHash(data []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(data))
+	h.Write(data)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func deflate(data []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+const base85Alphabet = "0123456789" +
+	"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
+	"abcdefghijklmnopqrstuvwxyz" +
+	"!#$%&()*+-;<=>?@^_`{|}~"
+
+// writeBase85 writes data in the line-oriented base85 encoding
+// used by git binary patches: each line holds up to 52 bytes,
+// prefixed by a character giving the line's byte count.
+func writeBase85(w *bufio.Writer, data []byte) {
+	for len(data) > 0 {
+		n := min(len(data), 52)
+		if n <= 26 {
+			w.WriteByte(byte('A' + n - 1))
+		} else {
+			w.WriteByte(byte('a' + n - 27))
+		}
+		for chunk := data[:n]; len(chunk) > 0; {
+			var acc uint32
+			for i := 0; i < 4; i++ {
+				acc <<= 8
+				if i < len(chunk) {
+					acc |= uint32(chunk[i])
+				}
+			}
+			var enc [5]byte
+			for i := 4; i >= 0; i-- {
+				enc[i] = base85Alphabet[acc%85]
+				acc /= 85
+			}
+			w.Write(enc[:])
+			chunk = chunk[min(len(chunk), 4):]
+		}
+		w.WriteByte('\n')
+		data = data[n:]
+	}
+}
+
+// quotePath quotes name the way git does in patch headers
+// when it contains special or non-ASCII bytes.
+func quotePath(name string) string {
+	needs := false
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c < 0x20 || c >= 0x7f || c == '"' || c == '\\' {
+			needs = true
+			break
+		}
+	}
+	if !needs {
+		return name
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		default:
+			if c < 0x20 || c >= 0x7f {
+				fmt.Fprintf(&b, "\\%03o", c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// interactive reports whether gonew may prompt for missing inputs:
+// standard input must be a terminal, and -no-input must not be given.
+func interactive() bool {
+	if *noInput {
+		return false
+	}
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// ask prompts on standard error for a value, described by question,
+// and reads the answer from standard input. An empty answer means def.
+// If check is not nil, ask repeats the question until check accepts
+// the answer.
+func ask(question, def string, check func(string) error) (string, error) {
+	for {
+		if def != "" {
+			fmt.Fprintf(os.Stderr, "%s [%s]: ", question, def)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: ", question)
+		}
+		line, err := stdin.ReadString('\n')
+		if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+			if errors.Is(err, io.EOF) {
+				fmt.Fprintln(os.Stderr)
+				return "", fmt.Errorf("no answer for %s", question)
+			}
+			return "", err
+		}
+		answer := strings.TrimSpace(line)
+		if answer == "" {
+			answer = def
+		}
+		if check != nil {
+			if err := check(answer); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				continue
+			}
+		}
+		return answer, nil
+	}
+}
+
+// askVar asks for the value of the template variable name,
+// as a gonew.Options.Ask function.
+func askVar(name, description, def string) (string, error) {
+	question := name
+	if description != "" {
+		question += " (" + description + ")"
+	}
+	return ask(question, def, nil)
+}
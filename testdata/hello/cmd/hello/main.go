@@ -0,0 +1,11 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/example/hello"
+)
+
+func main() {
+	fmt.Println(hello.Hello())
+}
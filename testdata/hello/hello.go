@@ -0,0 +1,8 @@
+// Package hello greets people.
+package hello
+
+// Greeting is what Hello says.
+const Greeting = "hello, world"
+
+// Hello returns the greeting.
+func Hello() string { return Greeting }
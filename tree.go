@@ -0,0 +1,57 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// printTree writes to w an indented listing of the tree rooted at root.
+// Each file is marked as rewritten if its slash-separated path relative
+// to root is in rewritten, and as new otherwise.
+func printTree(w io.Writer, root string, rewritten []string) error {
+	isRewritten := make(map[string]bool)
+	for _, name := range rewritten {
+		isRewritten[name] = true
+	}
+	bw := bufio.NewWriter(w)
+	err := filepath.WalkDir(root, func(src string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(root, src)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			bw.WriteString(filepath.Base(root) + "/\n")
+			return nil
+		}
+		bw.WriteString(strings.Repeat("    ", strings.Count(rel, "/")+1))
+		bw.WriteString(d.Name())
+		switch {
+		case d.IsDir():
+			bw.WriteString("/")
+		case isRewritten[rel]:
+			bw.WriteString(" [rewritten]")
+		default:
+			bw.WriteString(" [new]")
+		}
+		bw.WriteString("\n")
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}